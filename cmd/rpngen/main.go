@@ -0,0 +1,63 @@
+// rpngen generates a Go function which evaluates a Reverse Polish
+// Notation expression.
+//
+// Example (for go:generate):
+//
+//	//go:generate go run github.com/xaionaro-go/rpn/cmd/rpngen -package main -func price -params x,y -symbols amount=x -expr "amount 2 * y +" -o price_gen.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/xaionaro-go/rpn/codegen"
+)
+
+func main() {
+	pkgName := flag.String("package", "main", "the package name of the generated file")
+	funcName := flag.String("func", "", "the name of the generated function")
+	expression := flag.String("expr", "", "the RPN expression")
+	params := flag.String("params", "", "comma-separated names of the function parameters")
+	symbols := flag.String("symbols", "", "comma-separated mapping of symbols to parameters (for example: \"a=x,b=y\")")
+	output := flag.String("o", "", "the output file (stdout if empty)")
+	flag.Parse()
+
+	fn := codegen.Func{
+		Name:       *funcName,
+		Expression: *expression,
+		Symbols:    map[string]string{},
+	}
+	if *params != "" {
+		fn.Params = strings.Split(*params, ",")
+	}
+	if *symbols != "" {
+		for _, mapping := range strings.Split(*symbols, ",") {
+			parts := strings.SplitN(mapping, "=", 2)
+			if len(parts) != 2 {
+				fatalf("invalid symbol mapping '%s', expected 'symbol=param'", mapping)
+			}
+			fn.Symbols[parts[0]] = parts[1]
+		}
+	}
+
+	source, err := codegen.GenerateFile(*pkgName, fn)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	if *output == "" {
+		_, _ = os.Stdout.Write(source)
+		return
+	}
+	if err := ioutil.WriteFile(*output, source, 0644); err != nil {
+		fatalf("unable to write file '%s': %v", *output, err)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(2)
+}
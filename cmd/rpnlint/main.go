@@ -0,0 +1,79 @@
+// rpnlint reports possible problems of a Reverse Polish Notation
+// expression (like a division by zero) for the given ranges of
+// the symbols (see ir.Expr.Lint). The exit code is 1 if a problem
+// of severity "error" is found.
+//
+// Example:
+//
+//	rpnlint -ranges "x=-1:2,y=3:4" -expr "1 x / y 0.5 ^ +"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+func main() {
+	expression := flag.String("expr", "", "the RPN expression")
+	rangesString := flag.String("ranges", "", "comma-separated ranges of symbols (for example: \"x=-1:2,y=0:1e3\")")
+	flag.Parse()
+
+	ranges, err := parseRanges(*rangesString)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	expr, err := ir.ParseUnbound(*expression)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	exitCode := 0
+	for _, diag := range expr.Lint(ranges) {
+		fmt.Println(diag)
+		if diag.Severity == ir.SeverityError {
+			exitCode = 1
+		}
+	}
+	os.Exit(exitCode)
+}
+
+func parseRanges(s string) (map[string][2]float64, error) {
+	ranges := map[string][2]float64{}
+	if s == "" {
+		return ranges, nil
+	}
+	for _, mapping := range strings.Split(s, ",") {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid range '%s', expected 'symbol=lo:hi'", mapping)
+		}
+		bounds := strings.SplitN(parts[1], ":", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid range '%s', expected 'symbol=lo:hi'", mapping)
+		}
+		var r [2]float64
+		for idx, bound := range bounds {
+			v, err := strconv.ParseFloat(bound, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse bound '%s' of range '%s': %w", bound, mapping, err)
+			}
+			r[idx] = v
+		}
+		if r[0] > r[1] {
+			return nil, fmt.Errorf("invalid range '%s': the lower bound is greater than the upper one", mapping)
+		}
+		ranges[parts[0]] = r
+	}
+	return ranges, nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(2)
+}
@@ -0,0 +1,293 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// Func is a description of a Go function to be generated.
+type Func struct {
+	// Name is the name of the function.
+	Name string
+
+	// Expression is the Reverse Polish Notation expression
+	// evaluated by the function.
+	Expression string
+
+	// Params are the names of the function parameters (in the order).
+	Params []string
+
+	// Symbols maps symbols of the expression to the parameters. Symbols
+	// which are equal to a parameter name are mapped implicitly.
+	Symbols map[string]string
+
+	// Options are passed to ir.Parse (for example ir.O1 or
+	// ir.CompensatedSummation).
+	Options []ir.Option
+}
+
+// GenerateFile returns a formatted Go source file of package `pkgName`
+// with functions `funcs`.
+func GenerateFile(pkgName string, funcs ...Func) ([]byte, error) {
+	var body bytes.Buffer
+	isMathUsed := false
+	for _, fn := range funcs {
+		source, usesMath, err := fn.source()
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate function '%s': %w", fn.Name, err)
+		}
+		isMathUsed = isMathUsed || usesMath
+		body.WriteString("\n")
+		body.WriteString(source)
+	}
+
+	var file bytes.Buffer
+	file.WriteString("// Code generated by rpngen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&file, "package %s\n", pkgName)
+	if isMathUsed {
+		file.WriteString("\nimport \"math\"\n")
+	}
+	file.Write(body.Bytes())
+
+	result, err := format.Source(file.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("unable to format the generated code: %w", err)
+	}
+	return result, nil
+}
+
+// Source returns Go source code of the function (it could require
+// package "math" to be imported).
+func (fn Func) Source() (string, error) {
+	source, _, err := fn.source()
+	return source, err
+}
+
+func (fn Func) source() (source string, isMathUsed bool, err error) {
+	if !token.IsIdentifier(fn.Name) || isReservedName(fn.Name) {
+		return "", false, fmt.Errorf("invalid function name '%s'", fn.Name)
+	}
+	symbols := map[string]string{}
+	for _, param := range fn.Params {
+		if !token.IsIdentifier(param) || isReservedName(param) {
+			return "", false, fmt.Errorf("invalid parameter name '%s'", param)
+		}
+		if _, ok := symbols[param]; ok {
+			return "", false, fmt.Errorf("duplicate parameter '%s'", param)
+		}
+		symbols[param] = param
+	}
+	for sym, param := range fn.Symbols {
+		isParam := false
+		for _, paramCandidate := range fn.Params {
+			if param == paramCandidate {
+				isParam = true
+				break
+			}
+		}
+		if !isParam {
+			return "", false, fmt.Errorf("symbol '%s' is mapped to unknown parameter '%s'", sym, param)
+		}
+		symbols[sym] = param
+	}
+
+	irExpr, err := ir.Parse(fn.Expression, paramResolver(symbols), fn.Options...)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to parse expression '%s': %w", fn.Expression, err)
+	}
+
+	gen := &generator{
+		symbols: symbols,
+	}
+	result := gen.generate(irExpr.Root)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "// %s evaluates expression %q.\n", fn.Name, fn.Expression)
+	fmt.Fprintf(&out, "func %s(", fn.Name)
+	if len(fn.Params) > 0 {
+		fmt.Fprintf(&out, "%s float64", strings.Join(fn.Params, ", "))
+	}
+	out.WriteString(") float64 {\n")
+	for _, statement := range gen.statements {
+		out.WriteString(statement)
+		out.WriteString("\n")
+	}
+	fmt.Fprintf(&out, "return %s\n}\n", result.Code)
+	return out.String(), gen.isMathUsed, nil
+}
+
+// tempPrefix is the prefix of the names of the temporary variables of
+// the generated code.
+const tempPrefix = "_t"
+
+// isReservedName returns true if `name` is used by the generated code:
+// it is either package "math" or a temporary variable.
+func isReservedName(name string) bool {
+	if name == "math" {
+		return true
+	}
+	digits := strings.TrimPrefix(name, tempPrefix)
+	if digits == name || digits == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(digits, 10, 64)
+	return err == nil
+}
+
+// paramResolver resolves symbols mapped to function parameters. The values
+// are never loaded, they are required only to pass the validation of
+// the parser.
+type paramResolver map[string]string
+
+// Resolve implements types.SymbolResolver.
+func (r paramResolver) Resolve(sym string) (types.ValueLoader, error) {
+	if _, ok := r[sym]; !ok {
+		return nil, fmt.Errorf("symbol '%s' is not mapped to a parameter", sym)
+	}
+	return types.FuncValue(math.NaN), nil
+}
+
+type value struct {
+	Code       string
+	ConstValue types.NullFloat64
+}
+
+type generator struct {
+	symbols    map[string]string
+	statements []string
+	tempCount  int
+	isMathUsed bool
+}
+
+func (gen *generator) constValue(v float64) value {
+	return value{
+		Code: gen.literal(v),
+		ConstValue: types.NullFloat64{
+			Float64: v,
+			Valid:   true,
+		},
+	}
+}
+
+func (gen *generator) literal(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		gen.isMathUsed = true
+		return "math.NaN()"
+	case math.IsInf(v, 1):
+		gen.isMathUsed = true
+		return "math.Inf(1)"
+	case math.IsInf(v, -1):
+		gen.isMathUsed = true
+		return "math.Inf(-1)"
+	case v == 0 && math.Signbit(v):
+		gen.isMathUsed = true
+		return "math.Copysign(0, -1)"
+	case v < 0:
+		return "(" + strconv.FormatFloat(v, 'g', -1, 64) + ")"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func (gen *generator) generate(node *ir.Node) value {
+	if node.Op == types.OpFetch {
+		switch {
+		case node.ConstValue.Valid:
+			return gen.constValue(node.ConstValue.Float64)
+		case node.Sum != nil:
+			return gen.generateSum(node.Sum)
+		}
+		return value{Code: gen.symbols[node.Token]}
+	}
+
+	lhs := gen.generate(node.LHS)
+	rhs := gen.generate(node.RHS)
+	if node.Op == types.OpFMA {
+		addend := gen.generate(node.Addend)
+		if lhs.ConstValue.Valid && rhs.ConstValue.Valid && addend.ConstValue.Valid {
+			return gen.constValue(node.Op.Eval3(lhs.ConstValue.Float64, rhs.ConstValue.Float64, addend.ConstValue.Float64))
+		}
+		gen.isMathUsed = true
+		return value{Code: fmt.Sprintf("math.FMA(%s, %s, %s)", lhs.Code, rhs.Code, addend.Code)}
+	}
+	if lhs.ConstValue.Valid && rhs.ConstValue.Valid {
+		return gen.constValue(node.Op.Eval(lhs.ConstValue.Float64, rhs.ConstValue.Float64))
+	}
+
+	switch node.Op {
+	case types.OpPlus, types.OpMinus, types.OpDivide:
+		return value{Code: fmt.Sprintf("(%s %s %s)", lhs.Code, node.Op, rhs.Code)}
+	case types.OpMultiply:
+		// the explicit conversion forbids the compiler to fuse the
+		// multiplication with an addition (to get the same results
+		// as the other implementations).
+		return value{Code: fmt.Sprintf("float64(%s * %s)", lhs.Code, rhs.Code)}
+	case types.OpPower:
+		gen.isMathUsed = true
+		return value{Code: fmt.Sprintf("math.Pow(%s, %s)", lhs.Code, rhs.Code)}
+	case types.OpIf:
+		if lhs.ConstValue.Valid {
+			if lhs.ConstValue.Float64 > 0 {
+				return rhs
+			}
+			return gen.constValue(0)
+		}
+		temp := gen.temp()
+		gen.statements = append(gen.statements,
+			fmt.Sprintf("%s := float64(0)\nif %s > 0 {\n%s = %s\n}", temp, lhs.Code, temp, rhs.Code),
+		)
+		return value{Code: temp}
+	default:
+		panic("do not know how to generate op: " + node.Op.String())
+	}
+}
+
+// generateSum generates Kahan-Babuska-Neumaier summation (the same as
+// ir.Expr.CompensateSums does).
+func (gen *generator) generateSum(sum *ir.Sum) value {
+	gen.isMathUsed = true
+	total := gen.temp()
+	compensation := gen.temp()
+	for idx, termNode := range sum.Terms {
+		termValue := gen.generate(termNode)
+		term := termValue.Code
+		if sum.IsNegative[idx] {
+			if termValue.ConstValue.Valid {
+				term = gen.literal(-termValue.ConstValue.Float64)
+			} else {
+				term = "-" + term
+			}
+		}
+		if idx == 0 {
+			gen.statements = append(gen.statements,
+				fmt.Sprintf("%s := float64(%s)\n%s := float64(0)", total, term, compensation),
+			)
+			continue
+		}
+		v, t := gen.temp(), gen.temp()
+		gen.statements = append(gen.statements, fmt.Sprintf(
+			"%[1]s := float64(%[2]s)\n%[3]s := %[4]s + %[1]s\nif math.Abs(%[4]s) >= math.Abs(%[1]s) {\n%[5]s += (%[4]s - %[3]s) + %[1]s\n} else {\n%[5]s += (%[1]s - %[3]s) + %[4]s\n}\n%[4]s = %[3]s",
+			v, term, t, total, compensation,
+		))
+	}
+	gen.statements = append(gen.statements, fmt.Sprintf(
+		"if !math.IsInf(%[1]s, 0) && !math.IsNaN(%[1]s) {\n%[1]s += %[2]s\n}",
+		total, compensation,
+	))
+	return value{Code: total}
+}
+
+func (gen *generator) temp() string {
+	temp := fmt.Sprintf("%s%d", tempPrefix, gen.tempCount)
+	gen.tempCount++
+	return temp
+}
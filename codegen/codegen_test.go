@@ -0,0 +1,214 @@
+package codegen_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/codegen"
+	calltree "github.com/xaionaro-go/rpn/implementations/calltree"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+var updateGenerated = flag.Bool("update", false, "update "+parityFileName)
+
+const parityFileName = "parity_gen_test.go"
+
+type variables struct {
+	X, Y float64
+}
+
+func (r *variables) Resolve(sym string) (types.ValueLoader, error) {
+	switch sym {
+	case "x", "a":
+		return types.FuncValue(func() float64 {
+			return r.X
+		}), nil
+	case "y", "b":
+		return types.FuncValue(func() float64 {
+			return r.Y
+		}), nil
+	}
+	return nil, fmt.Errorf("symbol '%s' not found", sym)
+}
+
+var parityExpressions = func() []string {
+	result := []string{
+		"x y +",
+		"x y - y *",
+		"x 2 ^ y /",
+		"x y if 3 +",
+		"1 2 + x *",
+		"a b * y +",
+		"0 0 / x +",
+		"1 0 / x *",
+		"-1 x if",
+		"1 x if y +",
+		"b10 h1f + x -",
+		"x 0.5 ^ y x - if",
+		"x y if y x if *",
+		"0 -1 * x +",
+		"2 3 ^",
+		"x y 0.5 fma",
+		"2 3 x fma y -1 fma",
+		"2 3 4 fma x *",
+	}
+
+	valDict := []string{"x", "y", "a", "b", "0", "1", "-1", "0.5", "1e2"}
+	opDict := []string{"+", "-", "*", "/", "^", "if"}
+	randGen := rand.New(rand.NewSource(0))
+	for len(result) < 50 {
+		parts := []string{valDict[randGen.Intn(len(valDict))]}
+		for i := randGen.Intn(8); i >= 0; i-- {
+			parts = append(parts, valDict[randGen.Intn(len(valDict))], opDict[randGen.Intn(len(opDict))])
+		}
+		result = append(result, strings.Join(parts, " "))
+	}
+	return result
+}()
+
+// compensatedExpressions are generated with ir.CompensatedSummation.
+var compensatedExpressions = []string{
+	"x y + x - y -",
+	"x 1e100 + x + 1e100 - y +",
+	"x y - 0.1 + 0.2 + 0.3 - y x * +",
+	"x y + 1 - y x 2 ^ + 3 - *",
+	"x 1e308 + 1e308 + 1e308 - y -",
+	"0 x - y - 1 -",
+}
+
+func parityFuncs() []codegen.Func {
+	var funcs []codegen.Func
+	for idx, exprString := range parityExpressions {
+		funcs = append(funcs, codegen.Func{
+			Name:       fmt.Sprintf("parity%d", idx),
+			Expression: exprString,
+			Params:     []string{"x", "y"},
+			Symbols: map[string]string{
+				"a": "x",
+				"b": "y",
+			},
+		})
+	}
+	for idx, exprString := range compensatedExpressions {
+		funcs = append(funcs, codegen.Func{
+			Name:       fmt.Sprintf("compensated%d", idx),
+			Expression: exprString,
+			Params:     []string{"x", "y"},
+			Options:    []ir.Option{ir.CompensatedSummation},
+		})
+	}
+	return funcs
+}
+
+func TestGenerateFile_upToDate(t *testing.T) {
+	funcs := parityFuncs()
+	source, err := codegen.GenerateFile("codegen_test", funcs...)
+	require.NoError(t, err)
+
+	// the list of the generated functions to be used in TestGenerateFile_parity
+	source = append(source, "\nvar parityGenerated = []func(x, y float64) float64{\n"...)
+	for _, fn := range funcs {
+		source = append(source, "\t"+fn.Name+",\n"...)
+	}
+	source = append(source, "}\n"...)
+
+	if *updateGenerated {
+		require.NoError(t, ioutil.WriteFile(parityFileName, source, 0644))
+	}
+	committed, err := ioutil.ReadFile(parityFileName)
+	require.NoError(t, err)
+	require.Equal(t, string(committed), string(source), "run 'go test -update' to regenerate "+parityFileName)
+}
+
+func TestGenerateFile_parity(t *testing.T) {
+	vars := &variables{}
+	randGen := rand.New(rand.NewSource(0))
+	inputs := []float64{0, 1, -1, 0.5, 2, -3.25, 1e10}
+	for idx, exprString := range parityExpressions {
+		expr, err := calltree.Parse(exprString, vars)
+		require.NoError(t, err)
+		fn := parityGenerated[idx]
+		for i := 0; i < 100; i++ {
+			vars.X = inputs[randGen.Intn(len(inputs))]
+			vars.Y = randGen.Float64()*20 - 10
+			expected := expr.Eval()
+			actual := fn(vars.X, vars.Y)
+			if math.IsNaN(expected) && math.IsNaN(actual) {
+				continue
+			}
+			require.Equal(t, expected, actual, fmt.Sprintf("'%s' with x=%v y=%v", exprString, vars.X, vars.Y))
+		}
+	}
+}
+
+func TestGenerateFile_compensatedSummation(t *testing.T) {
+	vars := &variables{}
+	randGen := rand.New(rand.NewSource(0))
+	inputs := []float64{0, 1, -1, 0.1, 1e16, -3.25, 1e100, math.Inf(1)}
+	for idx, exprString := range compensatedExpressions {
+		expr, err := calltree.Parse(exprString, vars, ir.CompensatedSummation)
+		require.NoError(t, err)
+		fn := parityGenerated[len(parityExpressions)+idx]
+		for i := 0; i < 100; i++ {
+			vars.X = inputs[randGen.Intn(len(inputs))]
+			vars.Y = inputs[randGen.Intn(len(inputs))]
+			expected := expr.Eval()
+			actual := fn(vars.X, vars.Y)
+			if math.IsNaN(expected) && math.IsNaN(actual) {
+				continue
+			}
+			require.Equal(t, expected, actual, fmt.Sprintf("'%s' with x=%v y=%v", exprString, vars.X, vars.Y))
+		}
+	}
+	require.Equal(t, float64(2), parityGenerated[len(parityExpressions)+1](1, 0))
+}
+
+func TestFunc_Source(t *testing.T) {
+	source, err := codegen.Func{
+		Name:       "price",
+		Expression: "amount 2 3 * * y +",
+		Params:     []string{"amount", "y"},
+	}.Source()
+	require.NoError(t, err)
+	require.Contains(t, source, "float64(amount * 6)")
+
+	_, err = codegen.Func{
+		Name:       "price",
+		Expression: "z 2 *",
+		Params:     []string{"x"},
+	}.Source()
+	require.Error(t, err)
+
+	_, err = codegen.Func{
+		Name:       "price",
+		Expression: "z 2 *",
+		Params:     []string{"x"},
+		Symbols:    map[string]string{"z": "unknown"},
+	}.Source()
+	require.Error(t, err)
+
+	// the names used by the generated code
+	for _, name := range []string{"math", "_t0", "_t12"} {
+		_, err = codegen.Func{
+			Name:       "price",
+			Expression: "x 0 " + name + " if +",
+			Params:     []string{"x", name},
+		}.Source()
+		require.Error(t, err, name)
+	}
+
+	source, err = codegen.Func{
+		Name:       "price",
+		Expression: "v0 v1 if math_ + _t *",
+		Params:     []string{"v0", "v1", "math_", "_t"},
+	}.Source()
+	require.NoError(t, err)
+	require.NotContains(t, source, "v0 :=", "a temporary should not collide with a parameter")
+}
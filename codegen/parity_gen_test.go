@@ -0,0 +1,643 @@
+// Code generated by rpngen. DO NOT EDIT.
+
+package codegen_test
+
+import "math"
+
+// parity0 evaluates expression "x y +".
+func parity0(x, y float64) float64 {
+	return (x + y)
+}
+
+// parity1 evaluates expression "x y - y *".
+func parity1(x, y float64) float64 {
+	return float64((x - y) * y)
+}
+
+// parity2 evaluates expression "x 2 ^ y /".
+func parity2(x, y float64) float64 {
+	return (math.Pow(x, 2) / y)
+}
+
+// parity3 evaluates expression "x y if 3 +".
+func parity3(x, y float64) float64 {
+	_t0 := float64(0)
+	if x > 0 {
+		_t0 = y
+	}
+	return (_t0 + 3)
+}
+
+// parity4 evaluates expression "1 2 + x *".
+func parity4(x, y float64) float64 {
+	return float64(3 * x)
+}
+
+// parity5 evaluates expression "a b * y +".
+func parity5(x, y float64) float64 {
+	return (float64(x*y) + y)
+}
+
+// parity6 evaluates expression "0 0 / x +".
+func parity6(x, y float64) float64 {
+	return (math.NaN() + x)
+}
+
+// parity7 evaluates expression "1 0 / x *".
+func parity7(x, y float64) float64 {
+	return float64(math.Inf(1) * x)
+}
+
+// parity8 evaluates expression "-1 x if".
+func parity8(x, y float64) float64 {
+	return 0
+}
+
+// parity9 evaluates expression "1 x if y +".
+func parity9(x, y float64) float64 {
+	return (x + y)
+}
+
+// parity10 evaluates expression "b10 h1f + x -".
+func parity10(x, y float64) float64 {
+	return (33 - x)
+}
+
+// parity11 evaluates expression "x 0.5 ^ y x - if".
+func parity11(x, y float64) float64 {
+	_t0 := float64(0)
+	if math.Pow(x, 0.5) > 0 {
+		_t0 = (y - x)
+	}
+	return _t0
+}
+
+// parity12 evaluates expression "x y if y x if *".
+func parity12(x, y float64) float64 {
+	_t0 := float64(0)
+	if x > 0 {
+		_t0 = y
+	}
+	_t1 := float64(0)
+	if y > 0 {
+		_t1 = x
+	}
+	return float64(_t0 * _t1)
+}
+
+// parity13 evaluates expression "0 -1 * x +".
+func parity13(x, y float64) float64 {
+	return (math.Copysign(0, -1) + x)
+}
+
+// parity14 evaluates expression "2 3 ^".
+func parity14(x, y float64) float64 {
+	return 8
+}
+
+// parity15 evaluates expression "x y 0.5 fma".
+func parity15(x, y float64) float64 {
+	return math.FMA(x, y, 0.5)
+}
+
+// parity16 evaluates expression "2 3 x fma y -1 fma".
+func parity16(x, y float64) float64 {
+	return math.FMA(math.FMA(2, 3, x), y, (-1))
+}
+
+// parity17 evaluates expression "2 3 4 fma x *".
+func parity17(x, y float64) float64 {
+	return float64(10 * x)
+}
+
+// parity18 evaluates expression "x 0 ^ 1e2 ^ 0.5 if".
+func parity18(x, y float64) float64 {
+	_t0 := float64(0)
+	if math.Pow(math.Pow(x, 0), 100) > 0 {
+		_t0 = 0.5
+	}
+	return _t0
+}
+
+// parity19 evaluates expression "x -1 if".
+func parity19(x, y float64) float64 {
+	_t0 := float64(0)
+	if x > 0 {
+		_t0 = (-1)
+	}
+	return _t0
+}
+
+// parity20 evaluates expression "a -1 + a * y + 1 + 1e2 +".
+func parity20(x, y float64) float64 {
+	return (((float64((x+(-1))*x) + y) + 1) + 100)
+}
+
+// parity21 evaluates expression "a 0 + 0.5 ^ b *".
+func parity21(x, y float64) float64 {
+	return float64(math.Pow((x+0), 0.5) * y)
+}
+
+// parity22 evaluates expression "1 1e2 - a ^ 1 + y + b *".
+func parity22(x, y float64) float64 {
+	return float64(((math.Pow((-99), x) + 1) + y) * y)
+}
+
+// parity23 evaluates expression "0 b + b / a ^ -1 ^ 0 + b * 1 * b if".
+func parity23(x, y float64) float64 {
+	_t0 := float64(0)
+	if float64(float64((math.Pow(math.Pow(((0+y)/y), x), (-1))+0)*y)*1) > 0 {
+		_t0 = y
+	}
+	return _t0
+}
+
+// parity24 evaluates expression "-1 0 if 0.5 * 1e2 -".
+func parity24(x, y float64) float64 {
+	return (-100)
+}
+
+// parity25 evaluates expression "b 1e2 / y if 0 ^ b / x + a - y /".
+func parity25(x, y float64) float64 {
+	_t0 := float64(0)
+	if (y / 100) > 0 {
+		_t0 = y
+	}
+	return ((((math.Pow(_t0, 0) / y) + x) - x) / y)
+}
+
+// parity26 evaluates expression "a x + 1e2 * 1e2 ^".
+func parity26(x, y float64) float64 {
+	return math.Pow(float64((x+x)*100), 100)
+}
+
+// parity27 evaluates expression "0 b ^ b - y if".
+func parity27(x, y float64) float64 {
+	_t0 := float64(0)
+	if (math.Pow(0, y) - y) > 0 {
+		_t0 = y
+	}
+	return _t0
+}
+
+// parity28 evaluates expression "1 x / b ^ 0.5 * 0.5 ^ -1 / b /".
+func parity28(x, y float64) float64 {
+	return ((math.Pow(float64(math.Pow((1/x), y)*0.5), 0.5) / (-1)) / y)
+}
+
+// parity29 evaluates expression "b a /".
+func parity29(x, y float64) float64 {
+	return (y / x)
+}
+
+// parity30 evaluates expression "x 1 * b / b - -1 - b /".
+func parity30(x, y float64) float64 {
+	return ((((float64(x*1) / y) - y) - (-1)) / y)
+}
+
+// parity31 evaluates expression "a x + b - b + 1e2 if y /".
+func parity31(x, y float64) float64 {
+	_t0 := float64(0)
+	if (((x + x) - y) + y) > 0 {
+		_t0 = 100
+	}
+	return (_t0 / y)
+}
+
+// parity32 evaluates expression "0 1 / x - 0 * 0 ^ 0.5 ^ x if".
+func parity32(x, y float64) float64 {
+	_t0 := float64(0)
+	if math.Pow(math.Pow(float64((0-x)*0), 0), 0.5) > 0 {
+		_t0 = x
+	}
+	return _t0
+}
+
+// parity33 evaluates expression "-1 0.5 ^ b * 0 - -1 / 0 * 0.5 if a + a ^".
+func parity33(x, y float64) float64 {
+	_t0 := float64(0)
+	if float64(((float64(math.NaN()*y)-0)/(-1))*0) > 0 {
+		_t0 = 0.5
+	}
+	return math.Pow((_t0 + x), x)
+}
+
+// parity34 evaluates expression "1 y if b if".
+func parity34(x, y float64) float64 {
+	_t0 := float64(0)
+	if y > 0 {
+		_t0 = y
+	}
+	return _t0
+}
+
+// parity35 evaluates expression "0 y if b ^ b / x ^ 0.5 * x /".
+func parity35(x, y float64) float64 {
+	return (float64(math.Pow((math.Pow(0, y)/y), x)*0.5) / x)
+}
+
+// parity36 evaluates expression "1e2 a * a * 1e2 - a ^ 1e2 ^ b ^ a - x if".
+func parity36(x, y float64) float64 {
+	_t0 := float64(0)
+	if (math.Pow(math.Pow(math.Pow((float64(float64(100*x)*x)-100), x), 100), y) - x) > 0 {
+		_t0 = x
+	}
+	return _t0
+}
+
+// parity37 evaluates expression "-1 x + -1 ^ a if b + 0.5 * y * a *".
+func parity37(x, y float64) float64 {
+	_t0 := float64(0)
+	if math.Pow(((-1)+x), (-1)) > 0 {
+		_t0 = x
+	}
+	return float64(float64(float64((_t0+y)*0.5)*y) * x)
+}
+
+// parity38 evaluates expression "-1 1e2 - b ^ y +".
+func parity38(x, y float64) float64 {
+	return (math.Pow((-101), y) + y)
+}
+
+// parity39 evaluates expression "b b / -1 + x - 0.5 ^".
+func parity39(x, y float64) float64 {
+	return math.Pow((((y / y) + (-1)) - x), 0.5)
+}
+
+// parity40 evaluates expression "b 1e2 + a ^".
+func parity40(x, y float64) float64 {
+	return math.Pow((y + 100), x)
+}
+
+// parity41 evaluates expression "1 y ^ -1 + 0 -".
+func parity41(x, y float64) float64 {
+	return ((math.Pow(1, y) + (-1)) - 0)
+}
+
+// parity42 evaluates expression "b a - y * a if".
+func parity42(x, y float64) float64 {
+	_t0 := float64(0)
+	if float64((y-x)*y) > 0 {
+		_t0 = x
+	}
+	return _t0
+}
+
+// parity43 evaluates expression "-1 -1 / b * 0.5 / -1 - 1e2 /".
+func parity43(x, y float64) float64 {
+	return (((float64(1*y) / 0.5) - (-1)) / 100)
+}
+
+// parity44 evaluates expression "y 1e2 ^ 0.5 if -1 * -1 + a + y if".
+func parity44(x, y float64) float64 {
+	_t0 := float64(0)
+	if math.Pow(y, 100) > 0 {
+		_t0 = 0.5
+	}
+	_t1 := float64(0)
+	if ((float64(_t0*(-1)) + (-1)) + x) > 0 {
+		_t1 = y
+	}
+	return _t1
+}
+
+// parity45 evaluates expression "a -1 *".
+func parity45(x, y float64) float64 {
+	return float64(x * (-1))
+}
+
+// parity46 evaluates expression "0 y / y / 1 + 0 / 1 if a - b *".
+func parity46(x, y float64) float64 {
+	_t0 := float64(0)
+	if ((((0 / y) / y) + 1) / 0) > 0 {
+		_t0 = 1
+	}
+	return float64((_t0 - x) * y)
+}
+
+// parity47 evaluates expression "y 0 / b ^ 1 - a *".
+func parity47(x, y float64) float64 {
+	return float64((math.Pow((y/0), y) - 1) * x)
+}
+
+// parity48 evaluates expression "1e2 1e2 - a ^ -1 / -1 + a ^ 0.5 ^ a if".
+func parity48(x, y float64) float64 {
+	_t0 := float64(0)
+	if math.Pow(math.Pow(((math.Pow(0, x)/(-1))+(-1)), x), 0.5) > 0 {
+		_t0 = x
+	}
+	return _t0
+}
+
+// parity49 evaluates expression "y a / -1 ^".
+func parity49(x, y float64) float64 {
+	return math.Pow((y / x), (-1))
+}
+
+// compensated0 evaluates expression "x y + x - y -".
+func compensated0(x, y float64) float64 {
+	_t0 := float64(x)
+	_t1 := float64(0)
+	_t2 := float64(y)
+	_t3 := _t0 + _t2
+	if math.Abs(_t0) >= math.Abs(_t2) {
+		_t1 += (_t0 - _t3) + _t2
+	} else {
+		_t1 += (_t2 - _t3) + _t0
+	}
+	_t0 = _t3
+	_t4 := float64(-x)
+	_t5 := _t0 + _t4
+	if math.Abs(_t0) >= math.Abs(_t4) {
+		_t1 += (_t0 - _t5) + _t4
+	} else {
+		_t1 += (_t4 - _t5) + _t0
+	}
+	_t0 = _t5
+	_t6 := float64(-y)
+	_t7 := _t0 + _t6
+	if math.Abs(_t0) >= math.Abs(_t6) {
+		_t1 += (_t0 - _t7) + _t6
+	} else {
+		_t1 += (_t6 - _t7) + _t0
+	}
+	_t0 = _t7
+	if !math.IsInf(_t0, 0) && !math.IsNaN(_t0) {
+		_t0 += _t1
+	}
+	return _t0
+}
+
+// compensated1 evaluates expression "x 1e100 + x + 1e100 - y +".
+func compensated1(x, y float64) float64 {
+	_t0 := float64(x)
+	_t1 := float64(0)
+	_t2 := float64(1e+100)
+	_t3 := _t0 + _t2
+	if math.Abs(_t0) >= math.Abs(_t2) {
+		_t1 += (_t0 - _t3) + _t2
+	} else {
+		_t1 += (_t2 - _t3) + _t0
+	}
+	_t0 = _t3
+	_t4 := float64(x)
+	_t5 := _t0 + _t4
+	if math.Abs(_t0) >= math.Abs(_t4) {
+		_t1 += (_t0 - _t5) + _t4
+	} else {
+		_t1 += (_t4 - _t5) + _t0
+	}
+	_t0 = _t5
+	_t6 := float64((-1e+100))
+	_t7 := _t0 + _t6
+	if math.Abs(_t0) >= math.Abs(_t6) {
+		_t1 += (_t0 - _t7) + _t6
+	} else {
+		_t1 += (_t6 - _t7) + _t0
+	}
+	_t0 = _t7
+	_t8 := float64(y)
+	_t9 := _t0 + _t8
+	if math.Abs(_t0) >= math.Abs(_t8) {
+		_t1 += (_t0 - _t9) + _t8
+	} else {
+		_t1 += (_t8 - _t9) + _t0
+	}
+	_t0 = _t9
+	if !math.IsInf(_t0, 0) && !math.IsNaN(_t0) {
+		_t0 += _t1
+	}
+	return _t0
+}
+
+// compensated2 evaluates expression "x y - 0.1 + 0.2 + 0.3 - y x * +".
+func compensated2(x, y float64) float64 {
+	_t0 := float64(x)
+	_t1 := float64(0)
+	_t2 := float64(-y)
+	_t3 := _t0 + _t2
+	if math.Abs(_t0) >= math.Abs(_t2) {
+		_t1 += (_t0 - _t3) + _t2
+	} else {
+		_t1 += (_t2 - _t3) + _t0
+	}
+	_t0 = _t3
+	_t4 := float64(0.1)
+	_t5 := _t0 + _t4
+	if math.Abs(_t0) >= math.Abs(_t4) {
+		_t1 += (_t0 - _t5) + _t4
+	} else {
+		_t1 += (_t4 - _t5) + _t0
+	}
+	_t0 = _t5
+	_t6 := float64(0.2)
+	_t7 := _t0 + _t6
+	if math.Abs(_t0) >= math.Abs(_t6) {
+		_t1 += (_t0 - _t7) + _t6
+	} else {
+		_t1 += (_t6 - _t7) + _t0
+	}
+	_t0 = _t7
+	_t8 := float64((-0.3))
+	_t9 := _t0 + _t8
+	if math.Abs(_t0) >= math.Abs(_t8) {
+		_t1 += (_t0 - _t9) + _t8
+	} else {
+		_t1 += (_t8 - _t9) + _t0
+	}
+	_t0 = _t9
+	_t10 := float64(float64(y * x))
+	_t11 := _t0 + _t10
+	if math.Abs(_t0) >= math.Abs(_t10) {
+		_t1 += (_t0 - _t11) + _t10
+	} else {
+		_t1 += (_t10 - _t11) + _t0
+	}
+	_t0 = _t11
+	if !math.IsInf(_t0, 0) && !math.IsNaN(_t0) {
+		_t0 += _t1
+	}
+	return _t0
+}
+
+// compensated3 evaluates expression "x y + 1 - y x 2 ^ + 3 - *".
+func compensated3(x, y float64) float64 {
+	_t0 := float64(x)
+	_t1 := float64(0)
+	_t2 := float64(y)
+	_t3 := _t0 + _t2
+	if math.Abs(_t0) >= math.Abs(_t2) {
+		_t1 += (_t0 - _t3) + _t2
+	} else {
+		_t1 += (_t2 - _t3) + _t0
+	}
+	_t0 = _t3
+	_t4 := float64((-1))
+	_t5 := _t0 + _t4
+	if math.Abs(_t0) >= math.Abs(_t4) {
+		_t1 += (_t0 - _t5) + _t4
+	} else {
+		_t1 += (_t4 - _t5) + _t0
+	}
+	_t0 = _t5
+	if !math.IsInf(_t0, 0) && !math.IsNaN(_t0) {
+		_t0 += _t1
+	}
+	_t6 := float64(y)
+	_t7 := float64(0)
+	_t8 := float64(math.Pow(x, 2))
+	_t9 := _t6 + _t8
+	if math.Abs(_t6) >= math.Abs(_t8) {
+		_t7 += (_t6 - _t9) + _t8
+	} else {
+		_t7 += (_t8 - _t9) + _t6
+	}
+	_t6 = _t9
+	_t10 := float64((-3))
+	_t11 := _t6 + _t10
+	if math.Abs(_t6) >= math.Abs(_t10) {
+		_t7 += (_t6 - _t11) + _t10
+	} else {
+		_t7 += (_t10 - _t11) + _t6
+	}
+	_t6 = _t11
+	if !math.IsInf(_t6, 0) && !math.IsNaN(_t6) {
+		_t6 += _t7
+	}
+	return float64(_t0 * _t6)
+}
+
+// compensated4 evaluates expression "x 1e308 + 1e308 + 1e308 - y -".
+func compensated4(x, y float64) float64 {
+	_t0 := float64(x)
+	_t1 := float64(0)
+	_t2 := float64(1e+308)
+	_t3 := _t0 + _t2
+	if math.Abs(_t0) >= math.Abs(_t2) {
+		_t1 += (_t0 - _t3) + _t2
+	} else {
+		_t1 += (_t2 - _t3) + _t0
+	}
+	_t0 = _t3
+	_t4 := float64(1e+308)
+	_t5 := _t0 + _t4
+	if math.Abs(_t0) >= math.Abs(_t4) {
+		_t1 += (_t0 - _t5) + _t4
+	} else {
+		_t1 += (_t4 - _t5) + _t0
+	}
+	_t0 = _t5
+	_t6 := float64((-1e+308))
+	_t7 := _t0 + _t6
+	if math.Abs(_t0) >= math.Abs(_t6) {
+		_t1 += (_t0 - _t7) + _t6
+	} else {
+		_t1 += (_t6 - _t7) + _t0
+	}
+	_t0 = _t7
+	_t8 := float64(-y)
+	_t9 := _t0 + _t8
+	if math.Abs(_t0) >= math.Abs(_t8) {
+		_t1 += (_t0 - _t9) + _t8
+	} else {
+		_t1 += (_t8 - _t9) + _t0
+	}
+	_t0 = _t9
+	if !math.IsInf(_t0, 0) && !math.IsNaN(_t0) {
+		_t0 += _t1
+	}
+	return _t0
+}
+
+// compensated5 evaluates expression "0 x - y - 1 -".
+func compensated5(x, y float64) float64 {
+	_t0 := float64(0)
+	_t1 := float64(0)
+	_t2 := float64(-x)
+	_t3 := _t0 + _t2
+	if math.Abs(_t0) >= math.Abs(_t2) {
+		_t1 += (_t0 - _t3) + _t2
+	} else {
+		_t1 += (_t2 - _t3) + _t0
+	}
+	_t0 = _t3
+	_t4 := float64(-y)
+	_t5 := _t0 + _t4
+	if math.Abs(_t0) >= math.Abs(_t4) {
+		_t1 += (_t0 - _t5) + _t4
+	} else {
+		_t1 += (_t4 - _t5) + _t0
+	}
+	_t0 = _t5
+	_t6 := float64((-1))
+	_t7 := _t0 + _t6
+	if math.Abs(_t0) >= math.Abs(_t6) {
+		_t1 += (_t0 - _t7) + _t6
+	} else {
+		_t1 += (_t6 - _t7) + _t0
+	}
+	_t0 = _t7
+	if !math.IsInf(_t0, 0) && !math.IsNaN(_t0) {
+		_t0 += _t1
+	}
+	return _t0
+}
+
+var parityGenerated = []func(x, y float64) float64{
+	parity0,
+	parity1,
+	parity2,
+	parity3,
+	parity4,
+	parity5,
+	parity6,
+	parity7,
+	parity8,
+	parity9,
+	parity10,
+	parity11,
+	parity12,
+	parity13,
+	parity14,
+	parity15,
+	parity16,
+	parity17,
+	parity18,
+	parity19,
+	parity20,
+	parity21,
+	parity22,
+	parity23,
+	parity24,
+	parity25,
+	parity26,
+	parity27,
+	parity28,
+	parity29,
+	parity30,
+	parity31,
+	parity32,
+	parity33,
+	parity34,
+	parity35,
+	parity36,
+	parity37,
+	parity38,
+	parity39,
+	parity40,
+	parity41,
+	parity42,
+	parity43,
+	parity44,
+	parity45,
+	parity46,
+	parity47,
+	parity48,
+	parity49,
+	compensated0,
+	compensated1,
+	compensated2,
+	compensated3,
+	compensated4,
+	compensated5,
+}
@@ -1,10 +1,9 @@
 package rpn
 
 import (
-	"strings"
-
 	callslice "github.com/xaionaro-go/rpn/implementations/callslice"
 	calltree "github.com/xaionaro-go/rpn/implementations/calltree"
+	"github.com/xaionaro-go/rpn/ir"
 	"github.com/xaionaro-go/rpn/types"
 )
 
@@ -15,9 +14,21 @@ type Expr = types.Expr
 
 // Parse converts Reverse Polish Notation expression "expression" to
 // a Eval()-uatable implementation Expr.
-func Parse(expression string, symResolver types.SymbolResolver) (Expr, error) {
-	if len(strings.Split(expression, " ")) > 20 {
-		return callslice.Parse(expression, symResolver)
+//
+// Options (like ir.O1) are passed to ir.Parse.
+func Parse(expression string, symResolver types.SymbolResolver, opts ...ir.Option) (Expr, error) {
+	irExpr, err := ir.Parse(expression, symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return FromIR(irExpr)
+}
+
+// FromIR builds an Expr from an already parsed expression (choosing
+// the implementation by the size of the expression).
+func FromIR(irExpr *ir.Expr) (Expr, error) {
+	if irExpr.Len() > 20 {
+		return callslice.FromIR(irExpr)
 	}
-	return calltree.Parse(expression, symResolver)
+	return calltree.FromIR(irExpr)
 }
@@ -0,0 +1,312 @@
+package rpn
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+var (
+	_ types.Expr              = &Expr{}
+	_ types.SpecializableExpr = &Expr{}
+)
+
+// DefaultPrecision is the precision (in bits of the mantissa) used by
+// Parse and FromIR.
+const DefaultPrecision = 256
+
+// Expr is an implementation of types.Expr which evaluates the expression
+// with arbitrary precision (using big.Float, see EvalBig). It is much
+// slower than the other implementations.
+//
+// The literals are parsed with the precision of the expression (so "0.1"
+// is rounded to Precision bits instead of 53 bits, and large integers
+// like "0x7fffffffffffffff" are exact), and the values of the symbols
+// are loaded with arbitrary precision if they are resolved to
+// types.BigValueLoader (otherwise they are float64 values).
+//
+// Each operation is rounded to Precision bits (to nearest even), and
+// the exponent range is the one of big.Float (much wider than the one of
+// float64, but an overflow to Inf is still possible). Eval also could
+// overflow to Inf when the result is converted to float64.
+// "^" is exact (up to the rounding) for integer exponents, and for
+// exponent 0.5 (the square root), other exponents are evaluated
+// with float64 precision.
+//
+// Note: the optimizations (like ir.O1) and ir.Expr.Specialize fold
+// constant sub-expressions with float64 precision (see ir.Node.IsFolded),
+// while Specialize of Expr does not fold anything (see
+// ir.Expr.Substitute).
+type Expr struct {
+	Ops                  []types.Op
+	Syms                 []Symbol
+	Precision            uint
+	ResultCache          types.NullFloat64
+	IsMemoizationEnabled bool
+	Snapshot             *ir.Snapshot
+	evalStack            []*big.Float
+	product              *big.Float
+
+	// IR is the parsed expression the Expr is built from (see Specialize).
+	IR *ir.Expr
+}
+
+// Symbol provides information how to extract the value and what name
+// the symbol (of the expression) has.
+type Symbol struct {
+	internal.ParsedValue
+	Name string
+
+	// Const is the value of a literal with the precision of
+	// the expression (nil for symbols and NaN).
+	Const *big.Float
+}
+
+// Parse converts Reverse Polish Notation expression "expression" to
+// a Eval()-uatable implementation Expr with precision DefaultPrecision.
+//
+// input example: "z x y + *"
+// calculation interpretation: z * (x + y)
+func Parse(expression string, symResolver types.SymbolResolver, opts ...ir.Option) (*Expr, error) {
+	irExpr, err := ir.Parse(expression, symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return FromIR(irExpr)
+}
+
+// FromIR builds an Expr with precision DefaultPrecision from an already
+// parsed expression.
+func FromIR(irExpr *ir.Expr) (*Expr, error) {
+	return FromIRWithPrecision(irExpr, DefaultPrecision)
+}
+
+// FromIRWithPrecision builds an Expr with precision `prec` (in bits of
+// the mantissa) from an already parsed expression.
+func FromIRWithPrecision(irExpr *ir.Expr, prec uint) (*Expr, error) {
+	if prec == 0 || prec > big.MaxPrec/2 {
+		return nil, fmt.Errorf("invalid precision %d", prec)
+	}
+	expr := &Expr{
+		Precision: prec,
+		Snapshot:  irExpr.Snapshot,
+		IR:        irExpr,
+		product:   new(big.Float),
+	}
+	depth, maxDepth := 0, 0
+	irExpr.ExpandSums().Root.Walk(func(node *ir.Node) {
+		expr.Ops = append(expr.Ops, node.Op)
+		if node.Op != types.OpFetch {
+			depth -= node.Op.Arity() - 1
+			return
+		}
+		sym := Symbol{
+			Name: node.Token,
+			ParsedValue: internal.ParsedValue{
+				ConstValue: node.ConstValue,
+				FuncValue:  node.FuncValue,
+				IsSymbol:   node.IsSymbol,
+				BigFunc:    node.BigFunc,
+			},
+		}
+		if node.ConstValue.Valid && !math.IsNaN(node.ConstValue.Float64) {
+			var err error
+			isLiteral := !node.IsSymbol && !node.IsFolded
+			if isLiteral {
+				sym.Const, err = internal.ParseBigLiteral(node.Token, prec)
+			}
+			if !isLiteral || err != nil {
+				// a constant symbol or a constant folded by an optimization
+				sym.Const = new(big.Float).SetPrec(prec).SetFloat64(node.ConstValue.Float64)
+			}
+		}
+		expr.Syms = append(expr.Syms, sym)
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	})
+	expr.evalStack = make([]*big.Float, maxDepth)
+	for idx := range expr.evalStack {
+		expr.evalStack[idx] = new(big.Float).SetPrec(prec)
+	}
+	return expr, nil
+}
+
+// Eval implements types.Expr. It returns the result of EvalBig rounded
+// to float64 (NaN if EvalBig returns an error).
+func (expr *Expr) Eval() float64 {
+	if expr.IsMemoizationEnabled && expr.ResultCache.Valid {
+		return expr.ResultCache.Float64
+	}
+
+	var r float64
+	result, err := expr.EvalBig()
+	if err != nil {
+		r = math.NaN()
+	} else {
+		r, _ = result.Float64()
+	}
+
+	if expr.IsMemoizationEnabled {
+		expr.ResultCache.Float64 = r
+		expr.ResultCache.Valid = true
+	}
+	return r
+}
+
+// EvalBig evaluates the expression with arbitrary precision. It returns
+// an error if the result is not a number (for example for "0 0 /").
+func (expr *Expr) EvalBig() (result *big.Float, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errNaN, ok := r.(big.ErrNaN)
+			if !ok {
+				panic(r)
+			}
+			result, err = nil, fmt.Errorf("the result is not a number: %s", errNaN.Error())
+		}
+	}()
+
+	if expr.Snapshot != nil {
+		expr.Snapshot.Load()
+	}
+	stack := expr.evalStack
+	symIdx := 0
+	stackLen := 0
+	for _, op := range expr.Ops {
+		switch op {
+		case types.OpFetch:
+			expr.Syms[symIdx].load(stack[stackLen])
+			symIdx++
+			stackLen++
+		case types.OpFMA:
+			stackLen -= 2
+			a, b, c := stack[stackLen-1], stack[stackLen], stack[stackLen+1]
+			// the product of two values of precision P is exact with
+			// precision 2*P, so the result is rounded only once
+			expr.product.SetPrec(2*expr.Precision).Mul(a, b)
+			a.Add(expr.product, c)
+		default:
+			stackLen--
+			lhs, rhs := stack[stackLen-1], stack[stackLen]
+			expr.evalOp(op, lhs, rhs)
+		}
+	}
+	return new(big.Float).Copy(stack[0]), nil
+}
+
+// load stores the value of the symbol to `dst`.
+func (sym *Symbol) load(dst *big.Float) {
+	switch {
+	case sym.Const != nil:
+		dst.Set(sym.Const)
+	case sym.BigFunc != nil:
+		if v := sym.BigFunc(); v != nil {
+			dst.Set(v)
+			return
+		}
+		// panics with big.ErrNaN, see types.BigFuncValue
+		dst.SetFloat64(sym.Load())
+	default:
+		// panics with big.ErrNaN for NaN
+		dst.SetFloat64(sym.Load())
+	}
+}
+
+// evalOp stores the result of "lhs rhs op" to `lhs`.
+func (expr *Expr) evalOp(op types.Op, lhs, rhs *big.Float) {
+	switch op {
+	case types.OpPlus:
+		lhs.Add(lhs, rhs)
+	case types.OpMinus:
+		lhs.Sub(lhs, rhs)
+	case types.OpMultiply:
+		lhs.Mul(lhs, rhs)
+	case types.OpDivide:
+		lhs.Quo(lhs, rhs)
+	case types.OpPower:
+		expr.pow(lhs, rhs)
+	case types.OpIf:
+		if lhs.Sign() > 0 {
+			lhs.Set(rhs)
+		} else {
+			lhs.SetInt64(0)
+		}
+	default:
+		panic("do not know how to evaluate op: " + op.String())
+	}
+}
+
+// pow stores "base^exponent" to `base`.
+func (expr *Expr) pow(base, exponent *big.Float) {
+	if exponent.IsInt() && !exponent.IsInf() {
+		if n, accuracy := exponent.Int64(); accuracy == big.Exact {
+			powInt(base, n)
+			return
+		}
+	}
+	if exponent.Cmp(big.NewFloat(0.5)) == 0 {
+		// panics with big.ErrNaN for a negative base
+		base.Sqrt(base)
+		return
+	}
+	b, _ := base.Float64()
+	e, _ := exponent.Float64()
+	// panics with big.ErrNaN for NaN
+	base.SetFloat64(math.Pow(b, e))
+}
+
+// powInt stores "base^n" to `base`.
+func powInt(base *big.Float, n int64) {
+	prec := base.Prec()
+	isNegative := n < 0
+	if isNegative {
+		n = -n
+	}
+
+	// binary exponentiation with guard bits to round the result once
+	// (almost)
+	workPrec := prec + 64
+	r := new(big.Float).SetPrec(workPrec).SetInt64(1)
+	x := new(big.Float).SetPrec(workPrec).Set(base)
+	for ; n > 0; n >>= 1 {
+		if n&1 != 0 {
+			r.Mul(r, x)
+		}
+		if n > 1 {
+			x.Mul(x, x)
+		}
+	}
+	if isNegative {
+		r.Quo(new(big.Float).SetPrec(workPrec).SetInt64(1), r)
+	}
+	base.Set(r)
+}
+
+// String implements types.Expr
+func (expr *Expr) String() string {
+	return fmt.Sprintf("%v with %v (precision %d)", expr.Ops, expr.Syms, expr.Precision)
+}
+
+// EnableMemoization implements types.Expr
+func (expr *Expr) EnableMemoization(newValue bool) (oldValue bool) {
+	oldValue = expr.IsMemoizationEnabled
+	expr.IsMemoizationEnabled = newValue
+	return
+}
+
+// Specialize implements types.SpecializableExpr. The symbols are
+// replaced with constants without folding (see ir.Expr.Substitute), and
+// the specialized expression has the same precision.
+func (expr *Expr) Specialize(values map[string]float64) (types.Expr, error) {
+	specialized, err := FromIRWithPrecision(expr.IR.Substitute(values), expr.Precision)
+	if err != nil {
+		return nil, err
+	}
+	return specialized, nil
+}
@@ -0,0 +1,160 @@
+package rpn_test
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	rpn "github.com/xaionaro-go/rpn/implementations/bigfloat"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/tests"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+type bigResolver map[string]*big.Float
+
+func (r bigResolver) Resolve(sym string) (types.ValueLoader, error) {
+	v, ok := r[sym]
+	if !ok {
+		return nil, nil
+	}
+	return types.BigFuncValue(func() *big.Float { return v }), nil
+}
+
+type bigResolverFunc func() *big.Float
+
+func (f bigResolverFunc) Resolve(sym string) (types.ValueLoader, error) {
+	return types.BigFuncValue(f), nil
+}
+
+func mustParseBig(t *testing.T, s string, prec uint) *big.Float {
+	r, _, err := big.ParseFloat(s, 10, prec, big.ToNearestEven)
+	require.NoError(t, err)
+	return r
+}
+
+func TestExpr_EvalBig(t *testing.T) {
+	for _, testCase := range []struct {
+		expression string
+		expected   string
+	}{
+		{"0.1 0.2 +", "0.3"},
+		{"0x7fffffffffffffff 0x7ffffffffffffffe -", "1"},
+		{"h7fffffffffffffff 1 +", "9223372036854775808"},
+		{"b1011 o17 *", "165"},
+		{"1 3 /", "0.333333333333333333333333333333333333333333333333333333333333333333333333333"},
+		{"2 -2 ^", "0.25"},
+		{"2 0 ^", "1"},
+		{"2 0.5 ^", "1.41421356237309504880168872420969807856967187537694807317667973799073247846211"},
+		{"1.0001 365 ^", "1.0371724113025519299020280170563286312437111314056920738810007787775818275234624"},
+		{"10 400 ^ 10 -400 ^ *", "1"},
+		{"3 0.1 0.2 fma", "0.5"},
+		{"1 0.2 if", "0.2"},
+		{"-1 0.2 if", "0"},
+	} {
+		t.Run(testCase.expression, func(t *testing.T) {
+			expr, err := rpn.Parse(testCase.expression, nil)
+			require.NoError(t, err)
+			result, err := expr.EvalBig()
+			require.NoError(t, err)
+
+			expected := mustParseBig(t, testCase.expected, rpn.DefaultPrecision)
+			diff := new(big.Float).Sub(result, expected)
+			maxDiff := new(big.Float).Mul(expected, big.NewFloat(1e-70))
+			require.True(t, diff.Abs(diff).Cmp(maxDiff.Abs(maxDiff)) <= 0, "%s != %s", result.Text('g', 80), testCase.expected)
+		})
+	}
+}
+
+func TestExpr_exactLiterals(t *testing.T) {
+	// float64 rounds both the literals to 2^63
+	expr, err := rpn.Parse("0x7fffffffffffffff 0x7ffffffffffffffe -", nil)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), expr.Eval())
+}
+
+func TestExpr_precision(t *testing.T) {
+	irExpr, err := ir.Parse("0.1 0.2 + 0.3 -", nil)
+	require.NoError(t, err)
+
+	expr53, err := rpn.FromIRWithPrecision(irExpr, 53)
+	require.NoError(t, err)
+	a, b, c := 0.1, 0.2, 0.3
+	require.Equal(t, a+b-c, expr53.Eval())
+
+	expr1000, err := rpn.FromIRWithPrecision(irExpr, 1000)
+	require.NoError(t, err)
+	result, err := expr1000.EvalBig()
+	require.NoError(t, err)
+	require.Equal(t, uint(1000), result.Prec())
+	require.True(t, new(big.Float).Abs(result).Cmp(big.NewFloat(1e-300)) < 0, result.String())
+
+	_, err = rpn.FromIRWithPrecision(irExpr, 0)
+	require.Error(t, err)
+}
+
+func TestExpr_bigValueLoader(t *testing.T) {
+	x := mustParseBig(t, "1e-20", 100)
+	resolver := bigResolver{"x": x}
+	expr, err := rpn.Parse("x 1 + 1 -", resolver)
+	require.NoError(t, err)
+	result, err := expr.EvalBig()
+	require.NoError(t, err)
+	require.Equal(t, 0, result.Cmp(x), result.String())
+
+	x.SetInt64(2)
+	require.Equal(t, float64(2), expr.Eval())
+}
+
+func TestExpr_nan(t *testing.T) {
+	for _, expression := range []string{"0 0 /", "-1 0.5 ^", "-1 0.3 ^", "y 1 +"} {
+		expr, err := rpn.Parse(expression, bigResolver{"y": nil})
+		require.NoError(t, err)
+		_, err = expr.EvalBig()
+		require.Error(t, err, expression)
+		require.True(t, math.IsNaN(expr.Eval()), expression)
+	}
+}
+
+func TestExpr_specialize(t *testing.T) {
+	irExpr, err := ir.Parse("x0 3 /", tests.DummyResolver{T: t})
+	require.NoError(t, err)
+	expr, err := rpn.FromIRWithPrecision(irExpr, 100)
+	require.NoError(t, err)
+	specialized, err := expr.Specialize(map[string]float64{"x0": 1})
+	require.NoError(t, err)
+	require.Equal(t, uint(100), specialized.(*rpn.Expr).Precision)
+	require.Equal(t, 1.0/3, specialized.Eval())
+}
+
+func TestExpr_specializeExact(t *testing.T) {
+	expr, err := rpn.Parse("x0 0.1 0.2 + *", tests.DummyResolver{T: t})
+	require.NoError(t, err)
+	expected, err := expr.EvalBig()
+	require.NoError(t, err)
+	for _, values := range []map[string]float64{nil, {"y": 1}, {"x0": 2}} {
+		specialized, err := expr.Specialize(values)
+		require.NoError(t, err)
+		result, err := specialized.(*rpn.Expr).EvalBig()
+		require.NoError(t, err)
+		require.Equal(t, 0, result.Cmp(expected), "%v: %s != %s", values, result, expected)
+	}
+}
+
+func TestExpr_snapshotSymbols(t *testing.T) {
+	fetches := 0
+	resolver := bigResolverFunc(func() *big.Float {
+		// the value changes between two fetches
+		fetches++
+		return big.NewFloat(float64(fetches))
+	})
+	expr, err := rpn.Parse("x x -", resolver, ir.SnapshotSymbols)
+	require.NoError(t, err)
+	for i := 1; i <= 2; i++ {
+		result, err := expr.EvalBig()
+		require.NoError(t, err)
+		require.Zero(t, result.Sign())
+		require.Equal(t, i, fetches)
+	}
+}
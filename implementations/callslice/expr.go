@@ -1,16 +1,16 @@
 package rpn
 
 import (
-	"fmt"
 	"math"
-	"strings"
 
 	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
 	"github.com/xaionaro-go/rpn/types"
 )
 
 var (
-	_ types.Expr = &Expr{}
+	_ types.Expr              = &Expr{}
+	_ types.SpecializableExpr = &Expr{}
 )
 
 // x y z + +
@@ -31,6 +31,9 @@ type Expr struct {
 	RAM                  []float64
 	IsMemoizationEnabled bool
 	Description          string
+
+	// IR is the parsed expression the Expr is built from (see Specialize).
+	IR *ir.Expr
 }
 
 // Symbol provides information how to extract the value and what name
@@ -75,31 +78,78 @@ type value struct {
 //
 // input example: "z x y + *"
 // calculation interpretation: z * (x + y)
-func Parse(expression string, symResolver types.SymbolResolver) (*Expr, error) {
+func Parse(expression string, symResolver types.SymbolResolver, opts ...ir.Option) (*Expr, error) {
+	irExpr, err := ir.Parse(expression, symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return FromIR(irExpr)
+}
+
+// FromIR builds an Expr from an already parsed expression.
+func FromIR(irExpr *ir.Expr) (*Expr, error) {
 	expr := &Expr{
-		Description: expression,
+		Description: irExpr.Source,
+		IR:          irExpr,
 	}
-	parts := strings.Split(expression, " ")
+	if snapshot := irExpr.Snapshot; snapshot != nil {
+		expr.CallNodes = append(expr.CallNodes, snapshot.Load)
+	}
+
 	values := make([]value, 0, 2)
-	for partIdx, part := range parts {
-		if part == "" {
-			continue
-		}
-		op := types.ParseOp(part)
 
-		if op == types.OpUndefined {
-			parsedValue, err := internal.ParseValue(part, symResolver)
-			if err != nil {
-				return nil, fmt.Errorf("unable to parse value '%s': %w", part, err)
-			}
+	// the shared (referenced more than once) nodes are evaluated once
+	// to a RAM slot, which is reused by all the references.
+	refCounts := irExpr.RefCounts()
+	sharedValues := map[*ir.Node]value{}
 
-			values = append(values, value{ParsedValue: parsedValue, RAMIdx: -1})
-			continue
+	irExpr.Root.WalkDAG(func(node *ir.Node, isVisited bool) {
+		if isVisited {
+			values = append(values, sharedValues[node])
+			return
+		}
+		op := node.Op
+		if op == types.OpFetch {
+			if refCounts[node] > 1 && node.FuncValue != nil {
+				ramIdx := len(expr.RAM)
+				expr.RAM = append(expr.RAM, float64(0))
+				fn := node.FuncValue
+				expr.CallNodes = append(expr.CallNodes, func() {
+					expr.RAM[ramIdx] = fn()
+				})
+				sharedValues[node] = value{RAMIdx: ramIdx}
+				values = append(values, sharedValues[node])
+				return
+			}
+			values = append(values, value{
+				ParsedValue: internal.ParsedValue{
+					ConstValue: node.ConstValue,
+					FuncValue:  node.FuncValue,
+				},
+				RAMIdx: -1,
+			})
+			sharedValues[node] = values[len(values)-1]
+			return
 		}
 
-		unusedSymsCount := len(values)
-		if unusedSymsCount < 2 {
-			return nil, fmt.Errorf("expected at least 2 values in stack, but found only %d (partIdx: %d; expression: '%s')", unusedSymsCount, partIdx, expression)
+		if op == types.OpFMA {
+			aSym, bSym, cSym := values[len(values)-3], values[len(values)-2], values[len(values)-1]
+			values = values[:len(values)-3]
+
+			ramIdx := len(expr.RAM)
+			expr.RAM = append(expr.RAM, float64(0))
+			values = append(values, value{RAMIdx: ramIdx})
+			sharedValues[node] = value{RAMIdx: ramIdx}
+
+			if aSym.ConstValue.Valid && bSym.ConstValue.Valid && cSym.ConstValue.Valid {
+				expr.RAM[ramIdx] = math.FMA(aSym.ConstValue.Float64, bSym.ConstValue.Float64, cSym.ConstValue.Float64)
+				return
+			}
+			a, b, c := expr.loader(aSym), expr.loader(bSym), expr.loader(cSym)
+			expr.CallNodes = append(expr.CallNodes, func() {
+				expr.RAM[ramIdx] = math.FMA(a(), b(), c())
+			})
+			return
 		}
 
 		lhsSym := values[len(values)-2]
@@ -109,6 +159,7 @@ func Parse(expression string, symResolver types.SymbolResolver) (*Expr, error) {
 		ramIdx := len(expr.RAM)
 		expr.RAM = append(expr.RAM, float64(0))
 		values = append(values, value{RAMIdx: ramIdx})
+		sharedValues[node] = value{RAMIdx: ramIdx}
 
 		switch {
 		case lhsSym.ConstValue.Valid && rhsSym.ConstValue.Valid:
@@ -341,7 +392,7 @@ func Parse(expression string, symResolver types.SymbolResolver) (*Expr, error) {
 				expr.RAM[ramIdx] = 0
 			})
 		}
-	}
+	})
 
 	if len(values) == 1 && len(expr.RAM) == 0 {
 		// This is the case when no operators is given but just a value only
@@ -362,6 +413,24 @@ func Parse(expression string, symResolver types.SymbolResolver) (*Expr, error) {
 	return expr, nil
 }
 
+// loader returns a function which loads the value.
+func (expr *Expr) loader(v value) types.FuncValue {
+	switch {
+	case v.ConstValue.Valid:
+		constValue := v.ConstValue.Float64
+		return func() float64 {
+			return constValue
+		}
+	case v.FuncValue != nil:
+		return v.FuncValue
+	default:
+		ramIdx := v.RAMIdx
+		return func() float64 {
+			return expr.RAM[ramIdx]
+		}
+	}
+}
+
 // String implements types.Expr
 func (expr *Expr) String() string {
 	return expr.Description
@@ -373,3 +442,12 @@ func (expr *Expr) EnableMemoization(newValue bool) (oldValue bool) {
 	expr.IsMemoizationEnabled = newValue
 	return
 }
+
+// Specialize implements types.SpecializableExpr
+func (expr *Expr) Specialize(values map[string]float64) (types.Expr, error) {
+	specialized, err := FromIR(expr.IR.Specialize(values))
+	if err != nil {
+		return nil, err
+	}
+	return specialized, nil
+}
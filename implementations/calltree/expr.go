@@ -3,14 +3,16 @@ package rpn
 import (
 	"fmt"
 	"math"
-	"strings"
 
 	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
 	"github.com/xaionaro-go/rpn/types"
 )
 
 var (
-	_ types.Expr = &Expr{}
+	_ types.Expr              = &Expr{}
+	_ types.GradExpr          = &Expr{}
+	_ types.SpecializableExpr = &Expr{}
 )
 
 // Expr is an implementation of types.Expr which tries to precalculate as
@@ -21,10 +23,21 @@ type Expr struct {
 	IsMemoizationEnabled bool
 	RootFunc             func() float64
 	ResultCache          types.NullFloat64
+
+	// IR is the parsed expression the Expr is built from (see Specialize).
+	IR *ir.Expr
+
+	// gradEvaluator is built on the first EvalGrad.
+	gradEvaluator *gradEvaluator
+
+	// generation is incremented on each evaluation, it is used to
+	// evaluate the shared sub-expressions only once per Eval.
+	generation uint64
 }
 
 // Eval implements types.Expr
 func (expr *Expr) Eval() float64 {
+	expr.generation++
 	if !expr.IsMemoizationEnabled {
 		return expr.RootFunc()
 	}
@@ -40,260 +53,324 @@ func (expr *Expr) Eval() float64 {
 	return r
 }
 
-type stack []*internal.ParsedValue
-
-func (s *stack) Push(node internal.ParsedValue) *internal.ParsedValue {
-	*s = append(*s, &node)
-	return s.First()
-}
-
-func (s *stack) First() *internal.ParsedValue {
-	return (*s)[len(*s)-1]
-}
-
-func (s *stack) Pop() *internal.ParsedValue {
-	r := s.First()
-	*s = (*s)[:len(*s)-1]
-	return r
-}
-
 // Parse converts Reverse Polish Notation expression "expression" to
 // a Eval()-uatable implementation Expr.
 //
 // input example: "z x y + *"
 // calculation interpretation: z * (x + y)
-func Parse(expression string, symResolver types.SymbolResolver) (*Expr, error) {
+func Parse(expression string, symResolver types.SymbolResolver, opts ...ir.Option) (*Expr, error) {
+	irExpr, err := ir.Parse(expression, symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return FromIR(irExpr)
+}
+
+// FromIR builds an Expr from an already parsed expression.
+func FromIR(irExpr *ir.Expr) (*Expr, error) {
 	expr := &Expr{
-		Description: expression,
+		Description: irExpr.Source,
+		IR:          irExpr,
 	}
-	parts := strings.Split(expression, " ")
-	stack := stack{}
-	for partIdx, part := range parts {
-		if part == "" {
-			continue
+	b := &builder{
+		expr:      expr,
+		refCounts: irExpr.RefCounts(),
+		built:     map[*ir.Node]internal.ParsedValue{},
+	}
+	rootCallNode := b.build(irExpr.Root)
+
+	if rootCallNode.ConstValue.Valid {
+		expr.RootFunc = func() float64 {
+			return rootCallNode.ConstValue.Float64
 		}
-		op := types.ParseOp(part)
+	} else {
+		expr.RootFunc = rootCallNode.FuncValue
+	}
 
-		if op == types.OpUndefined {
-			parsedValue, err := internal.ParseValue(part, symResolver)
-			if err != nil {
-				return nil, fmt.Errorf("unable to parse value '%s': %w", part, err)
-			}
-			stack.Push(parsedValue)
-			continue
+	if snapshot := irExpr.Snapshot; snapshot != nil {
+		rootFunc := expr.RootFunc
+		expr.RootFunc = func() float64 {
+			snapshot.Load()
+			return rootFunc()
 		}
+	}
+
+	return expr, nil
+}
 
-		if len(stack) < 2 {
-			return nil, fmt.Errorf("invalid expression '%s' at part index %d: expected at least two entries in the stack", expression, partIdx)
+type builder struct {
+	expr      *Expr
+	refCounts map[*ir.Node]int
+	built     map[*ir.Node]internal.ParsedValue
+}
+
+// build returns the call node of the IR node. The call nodes of shared
+// (referenced more than once) IR nodes are built once and evaluate
+// the sub-expression once per Eval.
+func (b *builder) build(node *ir.Node) internal.ParsedValue {
+	if callNode, ok := b.built[node]; ok {
+		return callNode
+	}
+	callNode := buildCallNode(node, b.build)
+	if b.refCounts[node] > 1 && !callNode.ConstValue.Valid {
+		callNode.FuncValue = b.shared(callNode.FuncValue)
+	}
+	b.built[node] = callNode
+	return callNode
+}
+
+func (b *builder) shared(fn types.FuncValue) types.FuncValue {
+	expr := b.expr
+	var (
+		cache           float64
+		cacheGeneration uint64
+	)
+	return func() float64 {
+		if cacheGeneration == expr.generation {
+			return cache
 		}
-		rhs := *stack.Pop()
-		lhs := *stack.Pop()
+		cache = fn()
+		cacheGeneration = expr.generation
+		return cache
+	}
+}
 
-		switch op {
-		case types.OpPlus:
-			switch {
-			case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					ConstValue: types.NullFloat64{
-						Valid:   true,
-						Float64: lhs.ConstValue.Float64 + rhs.ConstValue.Float64,
-					},
-				})
-			case rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.FuncValue() + rhs.ConstValue.Float64
-					},
-				})
-			case lhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.ConstValue.Float64 + rhs.FuncValue()
-					},
-				})
-			default:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.FuncValue() + rhs.FuncValue()
-					},
-				})
+func buildCallNode(node *ir.Node, build func(*ir.Node) internal.ParsedValue) internal.ParsedValue {
+	if node.Op == types.OpFetch {
+		return internal.ParsedValue{
+			ConstValue: node.ConstValue,
+			FuncValue:  node.FuncValue,
+		}
+	}
+
+	lhs := build(node.LHS)
+	rhs := build(node.RHS)
+
+	switch node.Op {
+	case types.OpPlus:
+		switch {
+		case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				ConstValue: types.NullFloat64{
+					Valid:   true,
+					Float64: lhs.ConstValue.Float64 + rhs.ConstValue.Float64,
+				},
 			}
-		case types.OpMinus:
-			switch {
-			case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					ConstValue: types.NullFloat64{
-						Valid:   true,
-						Float64: lhs.ConstValue.Float64 - rhs.ConstValue.Float64,
-					},
-				})
-			case rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.FuncValue() - rhs.ConstValue.Float64
-					},
-				})
-			case lhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.ConstValue.Float64 - rhs.FuncValue()
-					},
-				})
-			default:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.FuncValue() - rhs.FuncValue()
-					},
-				})
+		case rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.FuncValue() + rhs.ConstValue.Float64
+				},
 			}
-		case types.OpMultiply:
-			switch {
-			case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					ConstValue: types.NullFloat64{
-						Valid:   true,
-						Float64: lhs.ConstValue.Float64 * rhs.ConstValue.Float64,
-					},
-				})
-			case rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.FuncValue() * rhs.ConstValue.Float64
-					},
-				})
-			case lhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.ConstValue.Float64 * rhs.FuncValue()
-					},
-				})
-			default:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.FuncValue() * rhs.FuncValue()
-					},
-				})
+		case lhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.ConstValue.Float64 + rhs.FuncValue()
+				},
 			}
-		case types.OpDivide:
-			switch {
-			case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					ConstValue: types.NullFloat64{
-						Valid:   true,
-						Float64: lhs.ConstValue.Float64 / rhs.ConstValue.Float64,
-					},
-				})
-			case rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.FuncValue() / rhs.ConstValue.Float64
-					},
-				})
-			case lhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.ConstValue.Float64 / rhs.FuncValue()
-					},
-				})
-			default:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return lhs.FuncValue() / rhs.FuncValue()
-					},
-				})
+		default:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.FuncValue() + rhs.FuncValue()
+				},
 			}
-		case types.OpPower:
-			switch {
-			case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					ConstValue: types.NullFloat64{
-						Valid:   true,
-						Float64: math.Pow(lhs.ConstValue.Float64, rhs.ConstValue.Float64),
-					},
-				})
-			case rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return math.Pow(lhs.FuncValue(), rhs.ConstValue.Float64)
-					},
-				})
-			case lhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return math.Pow(lhs.ConstValue.Float64, rhs.FuncValue())
-					},
-				})
-			default:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						return math.Pow(lhs.FuncValue(), rhs.FuncValue())
-					},
-				})
+		}
+	case types.OpMinus:
+		switch {
+		case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				ConstValue: types.NullFloat64{
+					Valid:   true,
+					Float64: lhs.ConstValue.Float64 - rhs.ConstValue.Float64,
+				},
 			}
-		case types.OpIf:
-			switch {
-			case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
-				v := float64(0)
-				if lhs.ConstValue.Float64 > 0 {
-					v = rhs.ConstValue.Float64
-				}
-				stack.Push(internal.ParsedValue{
-					ConstValue: types.NullFloat64{
-						Valid:   true,
-						Float64: v,
-					},
-				})
-			case rhs.ConstValue.Valid:
-				stack.Push(internal.ParsedValue{
+		case rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.FuncValue() - rhs.ConstValue.Float64
+				},
+			}
+		case lhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.ConstValue.Float64 - rhs.FuncValue()
+				},
+			}
+		default:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.FuncValue() - rhs.FuncValue()
+				},
+			}
+		}
+	case types.OpMultiply:
+		switch {
+		case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				ConstValue: types.NullFloat64{
+					Valid:   true,
+					Float64: lhs.ConstValue.Float64 * rhs.ConstValue.Float64,
+				},
+			}
+		case rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.FuncValue() * rhs.ConstValue.Float64
+				},
+			}
+		case lhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.ConstValue.Float64 * rhs.FuncValue()
+				},
+			}
+		default:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.FuncValue() * rhs.FuncValue()
+				},
+			}
+		}
+	case types.OpDivide:
+		switch {
+		case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				ConstValue: types.NullFloat64{
+					Valid:   true,
+					Float64: lhs.ConstValue.Float64 / rhs.ConstValue.Float64,
+				},
+			}
+		case rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.FuncValue() / rhs.ConstValue.Float64
+				},
+			}
+		case lhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.ConstValue.Float64 / rhs.FuncValue()
+				},
+			}
+		default:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return lhs.FuncValue() / rhs.FuncValue()
+				},
+			}
+		}
+	case types.OpPower:
+		switch {
+		case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				ConstValue: types.NullFloat64{
+					Valid:   true,
+					Float64: math.Pow(lhs.ConstValue.Float64, rhs.ConstValue.Float64),
+				},
+			}
+		case rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return math.Pow(lhs.FuncValue(), rhs.ConstValue.Float64)
+				},
+			}
+		case lhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return math.Pow(lhs.ConstValue.Float64, rhs.FuncValue())
+				},
+			}
+		default:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return math.Pow(lhs.FuncValue(), rhs.FuncValue())
+				},
+			}
+		}
+	case types.OpIf:
+		switch {
+		case lhs.ConstValue.Valid && rhs.ConstValue.Valid:
+			v := float64(0)
+			if lhs.ConstValue.Float64 > 0 {
+				v = rhs.ConstValue.Float64
+			}
+			return internal.ParsedValue{
+				ConstValue: types.NullFloat64{
+					Valid:   true,
+					Float64: v,
+				},
+			}
+		case rhs.ConstValue.Valid:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					if lhs.FuncValue() > 0 {
+						return rhs.ConstValue.Float64
+					}
+					return 0
+				},
+			}
+		case lhs.ConstValue.Valid:
+			if lhs.ConstValue.Float64 > 0 {
+				return internal.ParsedValue{
 					FuncValue: func() float64 {
-						if lhs.FuncValue() > 0 {
-							return rhs.ConstValue.Float64
-						}
-						return 0
+						return rhs.FuncValue()
 					},
-				})
-			case lhs.ConstValue.Valid:
-				if lhs.ConstValue.Float64 > 0 {
-					stack.Push(internal.ParsedValue{
-						FuncValue: func() float64 {
-							return rhs.FuncValue()
-						},
-					})
-				} else {
-					stack.Push(internal.ParsedValue{
-						ConstValue: types.NullFloat64{
-							Float64: 0,
-							Valid:   true,
-						},
-					})
 				}
-			default:
-				stack.Push(internal.ParsedValue{
-					FuncValue: func() float64 {
-						if lhs.FuncValue() > 0 {
-							return rhs.FuncValue()
-						}
-						return 0
-					},
-				})
+			}
+			return internal.ParsedValue{
+				ConstValue: types.NullFloat64{
+					Float64: 0,
+					Valid:   true,
+				},
+			}
+		default:
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					if lhs.FuncValue() > 0 {
+						return rhs.FuncValue()
+					}
+					return 0
+				},
+			}
+		}
+	case types.OpFMA:
+		addend := build(node.Addend)
+		switch {
+		case lhs.ConstValue.Valid && rhs.ConstValue.Valid && addend.ConstValue.Valid:
+			return internal.ParsedValue{
+				ConstValue: types.NullFloat64{
+					Valid:   true,
+					Float64: math.FMA(lhs.ConstValue.Float64, rhs.ConstValue.Float64, addend.ConstValue.Float64),
+				},
+			}
+		case !lhs.ConstValue.Valid && !rhs.ConstValue.Valid && addend.ConstValue.Valid:
+			// the usual case of Horner's method: "acc x * c +"
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return math.FMA(lhs.FuncValue(), rhs.FuncValue(), addend.ConstValue.Float64)
+				},
+			}
+		default:
+			a, b, c := funcOf(lhs), funcOf(rhs), funcOf(addend)
+			return internal.ParsedValue{
+				FuncValue: func() float64 {
+					return math.FMA(a(), b(), c())
+				},
 			}
 		}
 	}
+	panic(fmt.Sprintf("unknown op: %s", node.Op))
+}
 
-	if len(stack) != 1 {
-		return nil, fmt.Errorf("expected stack length is 1, but got %d", len(stack))
+// funcOf returns a function which returns the value of the call node.
+func funcOf(callNode internal.ParsedValue) types.FuncValue {
+	if !callNode.ConstValue.Valid {
+		return callNode.FuncValue
 	}
-	rootCallNode := stack[0]
-
-	if rootCallNode.ConstValue.Valid {
-		expr.RootFunc = func() float64 {
-			return rootCallNode.ConstValue.Float64
-		}
-	} else {
-		expr.RootFunc = rootCallNode.FuncValue
+	v := callNode.ConstValue.Float64
+	return func() float64 {
+		return v
 	}
-
-	return expr, nil
 }
 
 // String implements types.Expr
@@ -307,3 +384,12 @@ func (expr *Expr) EnableMemoization(newValue bool) (oldValue bool) {
 	expr.IsMemoizationEnabled = newValue
 	return
 }
+
+// Specialize implements types.SpecializableExpr
+func (expr *Expr) Specialize(values map[string]float64) (types.Expr, error) {
+	specialized, err := FromIR(expr.IR.Specialize(values))
+	if err != nil {
+		return nil, err
+	}
+	return specialized, nil
+}
@@ -0,0 +1,132 @@
+package rpn
+
+import (
+	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// gradFunc evaluates a (sub-)expression: it returns the value and
+// stores the gradient (the partial derivatives with respect to the
+// symbols) to `grad`.
+type gradFunc func(grad []float64) float64
+
+// gradEvaluator evaluates the expression on dual numbers.
+type gradEvaluator struct {
+	Symbols     []string
+	Uncertainty *internal.Uncertainty
+	RootFunc    gradFunc
+	Snapshot    *ir.Snapshot
+	grad        []float64
+}
+
+// EvalGrad implements types.GradExpr
+func (expr *Expr) EvalGrad() (value float64, grad map[string]float64) {
+	return expr.getGradEvaluator().Eval()
+}
+
+// EvalUncertainty implements types.GradExpr
+func (expr *Expr) EvalUncertainty(correlations map[[2]string]float64) (value, stdDev float64) {
+	return expr.getGradEvaluator().EvalUncertainty(correlations)
+}
+
+func (expr *Expr) getGradEvaluator() *gradEvaluator {
+	if expr.gradEvaluator == nil {
+		expr.gradEvaluator = newGradEvaluator(expr.IR.ExpandSums())
+	}
+	return expr.gradEvaluator
+}
+
+func newGradEvaluator(irExpr *ir.Expr) *gradEvaluator {
+	symbols := irExpr.Symbols()
+	symbolIndices := make(map[string]int, len(symbols))
+	for idx, name := range symbols {
+		symbolIndices[name] = idx
+	}
+
+	built := map[*ir.Node]gradFunc{}
+	var build func(node *ir.Node) gradFunc
+	build = func(node *ir.Node) gradFunc {
+		if fn, ok := built[node]; ok {
+			return fn
+		}
+		fn := buildGradFunc(node, len(symbols), symbolIndices, build)
+		built[node] = fn
+		return fn
+	}
+
+	return &gradEvaluator{
+		Symbols:     symbols,
+		Uncertainty: internal.NewUncertainty(symbols, irExpr.StdDevFuncs(symbols)),
+		RootFunc:    build(irExpr.Root),
+		Snapshot:    irExpr.Snapshot,
+		grad:        make([]float64, len(symbols)),
+	}
+}
+
+func buildGradFunc(node *ir.Node, gradLen int, symbolIndices map[string]int, build func(*ir.Node) gradFunc) gradFunc {
+	switch {
+	case node.Op == types.OpFetch:
+		value := internal.ParsedValue{
+			ConstValue: node.ConstValue,
+			FuncValue:  node.FuncValue,
+		}
+		symbolIdx := -1
+		if node.IsSymbol {
+			symbolIdx = symbolIndices[node.Token]
+		}
+		return func(grad []float64) float64 {
+			for idx := range grad {
+				grad[idx] = 0
+			}
+			if symbolIdx >= 0 {
+				grad[symbolIdx] = 1
+			}
+			return value.Load()
+		}
+
+	case node.Op == types.OpFMA:
+		a, b, c := build(node.LHS), build(node.RHS), build(node.Addend)
+		bGrad, cGrad := make([]float64, gradLen), make([]float64, gradLen)
+		return func(grad []float64) float64 {
+			aValue := a(grad)
+			bValue := b(bGrad)
+			cValue := c(cGrad)
+			return internal.EvalDualFMA(aValue, grad, bValue, bGrad, cValue, cGrad, grad)
+		}
+
+	default:
+		op := node.Op
+		lhs, rhs := build(node.LHS), build(node.RHS)
+		rhsGrad := make([]float64, gradLen)
+		return func(grad []float64) float64 {
+			lhsValue := lhs(grad)
+			rhsValue := rhs(rhsGrad)
+			return internal.EvalDual(op, lhsValue, grad, rhsValue, rhsGrad, grad)
+		}
+	}
+}
+
+// Eval evaluates the value and the gradient.
+func (evaluator *gradEvaluator) Eval() (value float64, grad map[string]float64) {
+	value, gradSlice := evaluator.evalDual()
+	grad = make(map[string]float64, len(evaluator.Symbols))
+	for idx, name := range evaluator.Symbols {
+		grad[name] = gradSlice[idx]
+	}
+	return
+}
+
+// EvalUncertainty evaluates the value and its standard deviation.
+func (evaluator *gradEvaluator) EvalUncertainty(correlations map[[2]string]float64) (value, stdDev float64) {
+	value, grad := evaluator.evalDual()
+	return value, evaluator.Uncertainty.StdDev(grad, correlations)
+}
+
+// evalDual returns the value and the gradient (indexed as Symbols).
+func (evaluator *gradEvaluator) evalDual() (float64, []float64) {
+	if evaluator.Snapshot != nil {
+		evaluator.Snapshot.Load()
+	}
+	return evaluator.RootFunc(evaluator.grad), evaluator.grad
+}
@@ -0,0 +1,157 @@
+package rpn
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/cpu"
+)
+
+// BatchFeatures defines which CPU extensions could be used by
+// Expr.EvalBatch.
+type BatchFeatures struct {
+	// AVX enables 4-wide packed AVX instructions. If disabled then 2-wide
+	// packed SSE2 instructions are used.
+	AVX bool
+
+	// FMA enables evaluation of "fma" (see ir.ContractFMA) by a fused
+	// multiply-add instruction (VFMADD), otherwise such expressions are
+	// evaluated row by row. It is used only if AVX is enabled as well.
+	FMA bool
+}
+
+// DetectBatchFeatures returns BatchFeatures supported by the current CPU.
+func DetectBatchFeatures() BatchFeatures {
+	return BatchFeatures{
+		AVX: cpu.X86.HasAVX,
+		FMA: cpu.X86.HasAVX && cpu.X86.HasFMA,
+	}
+}
+
+type batchKernel struct {
+	features BatchFeatures
+	eval     func()
+	cleanup  func()
+
+	// columns contains pointers to the values of each symbol and
+	// to the output (the last item).
+	columns []unsafe.Pointer
+
+	// params contains the index masks of each symbol (0 -- always read
+	// the first value, ^0 -- read the value of the current row), and
+	// two more items: amount of bytes to be processed by packed
+	// instructions and total amount of bytes to be processed.
+	params []uint64
+
+	// broadcast contains the values of the symbols which has no column.
+	broadcast []float64
+}
+
+// EvalBatch evaluates the expression for every row of `columns` and stores
+// the results into `out`.
+//
+// `columns` maps symbol names to their values; the length of each column
+// should be equal to len(out). Symbols which has no column are loaded
+// once per EvalBatch call.
+//
+// Expressions which are too deep to keep the stack in registers are
+// evaluated row by row. Expressions with compensated sums (see
+// ir.CompensatedSummation) are not supported.
+func (expr *Expr) EvalBatch(columns map[string][]float64, out []float64) error {
+	if expr.hasSums {
+		return fmt.Errorf("compensated sums are not supported by EvalBatch")
+	}
+	for name, column := range columns {
+		if len(column) != len(out) {
+			return fmt.Errorf("invalid length of column '%s': %d != %d", name, len(column), len(out))
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	if expr.Snapshot != nil {
+		// the symbols without columns are loaded from the snapshot (by
+		// the kernel and by evalRows)
+		expr.Snapshot.Load()
+	}
+
+	kernel, err := expr.getBatchKernel()
+	if err != nil {
+		expr.evalRows(columns, out)
+		return nil
+	}
+
+	symsCount := len(expr.Syms)
+	for idx, sym := range expr.Syms {
+		if column, ok := columns[sym.Name]; ok && !sym.ConstValue.Valid {
+			kernel.columns[idx] = unsafe.Pointer(&column[0])
+			kernel.params[idx] = ^uint64(0)
+			continue
+		}
+		broadcast := kernel.broadcast[idx*batchMaxWidth : (idx+1)*batchMaxWidth]
+		value := sym.Load()
+		for i := range broadcast {
+			broadcast[i] = value
+		}
+		kernel.columns[idx] = unsafe.Pointer(&broadcast[0])
+		kernel.params[idx] = 0
+	}
+	itemSize := uint64(unsafe.Sizeof(float64(0)))
+	width := uint64(kernel.features.width())
+	kernel.columns[symsCount] = unsafe.Pointer(&out[0])
+	kernel.params[symsCount] = uint64(len(out)) / width * width * itemSize
+	kernel.params[symsCount+1] = uint64(len(out)) * itemSize
+
+	kernel.eval()
+
+	// do not keep the caller's memory alive
+	for idx := range kernel.columns {
+		kernel.columns[idx] = nil
+	}
+	return nil
+}
+
+func (expr *Expr) getBatchKernel() (*batchKernel, error) {
+	if expr.batchKernel != nil && expr.batchKernel.features == expr.BatchFeatures {
+		return expr.batchKernel, nil
+	}
+	if expr.batchKernel != nil {
+		expr.batchKernel.cleanup()
+		expr.batchKernel = nil
+	}
+
+	symsCount := len(expr.Syms)
+	kernel := &batchKernel{
+		features:  expr.BatchFeatures,
+		columns:   make([]unsafe.Pointer, symsCount+1),
+		params:    make([]uint64, symsCount+2),
+		broadcast: make([]float64, symsCount*batchMaxWidth),
+	}
+	code, err := expr.Ops.assembleBatch(kernel.columns, kernel.params, kernel.features)
+	if err != nil {
+		return nil, err
+	}
+	fns, cleanup := loadFuncs(code, []int{0})
+	kernel.eval, kernel.cleanup = fns[0], cleanup
+	expr.batchKernel = kernel
+	return kernel, nil
+}
+
+// evalRows is the fallback for expressions which cannot be
+// evaluated by a batch kernel.
+func (expr *Expr) evalRows(columns map[string][]float64, out []float64) {
+	values := expr.values
+	for idx, sym := range expr.Syms {
+		if !sym.ConstValue.Valid {
+			values[idx] = sym.Load()
+		}
+	}
+	for row := range out {
+		for _, idx := range expr.nonStaticValueIndices {
+			if column, ok := columns[expr.Syms[idx].Name]; ok {
+				values[idx] = column[row]
+			}
+		}
+		out[row] = expr.Code()
+	}
+}
@@ -0,0 +1,258 @@
+package rpn
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	asm "github.com/twitchyliquid64/golang-asm"
+	"github.com/twitchyliquid64/golang-asm/obj"
+	"github.com/twitchyliquid64/golang-asm/obj/x86"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+const (
+	// batchMaxWidth is the maximal amount of values processed by
+	// a single packed instruction (4 float64-s in a YMM register).
+	batchMaxWidth = 4
+
+	// batchMaxStackDepth is the amount of XMM/YMM registers used as
+	// the evaluation stack. X14 is reserved and X15 should be kept zero
+	// (Go ABI requirement).
+	batchMaxStackDepth = 14
+)
+
+func (features BatchFeatures) width() int {
+	if features.AVX {
+		return 4
+	}
+	return 2
+}
+
+// batchInstructions is a set of instructions which are used to evaluate
+// an expression in one of modes (packed AVX, packed SSE2 or scalar).
+type batchInstructions struct {
+	Move      obj.As
+	Plus      obj.As
+	Minus     obj.As
+	Multiply  obj.As
+	Divide    obj.As
+	FMA213    obj.As
+	IsVEX     bool
+	FirstReg  int16
+	ItemCount int64
+}
+
+var (
+	batchInstructionsAVX = batchInstructions{
+		Move:      x86.AVMOVUPD,
+		Plus:      x86.AVADDPD,
+		Minus:     x86.AVSUBPD,
+		Multiply:  x86.AVMULPD,
+		Divide:    x86.AVDIVPD,
+		FMA213:    x86.AVFMADD213PD,
+		IsVEX:     true,
+		FirstReg:  x86.REG_Y0,
+		ItemCount: 4,
+	}
+	batchInstructionsSSE2 = batchInstructions{
+		Move:      x86.AMOVUPD,
+		Plus:      x86.AADDPD,
+		Minus:     x86.ASUBPD,
+		Multiply:  x86.AMULPD,
+		Divide:    x86.ADIVPD,
+		FirstReg:  x86.REG_X0,
+		ItemCount: 2,
+	}
+	batchInstructionsScalar = batchInstructions{
+		Move:      x86.AMOVSD,
+		Plus:      x86.AADDSD,
+		Minus:     x86.ASUBSD,
+		Multiply:  x86.AMULSD,
+		Divide:    x86.ADIVSD,
+		FMA213:    x86.AVFMADD213SD,
+		FirstReg:  x86.REG_X0,
+		ItemCount: 1,
+	}
+)
+
+func regAddr(reg int16) obj.Addr {
+	return obj.Addr{Type: obj.TYPE_REG, Reg: reg}
+}
+
+func indexedAddr(regBase, regIndex int16) obj.Addr {
+	return obj.Addr{Type: obj.TYPE_MEM, Reg: regBase, Index: regIndex, Scale: 1}
+}
+
+func offsetAddr(regBase int16, offset int64) obj.Addr {
+	return obj.Addr{Type: obj.TYPE_MEM, Reg: regBase, Offset: offset}
+}
+
+func move(builder *asm.Builder, as obj.As, to, from obj.Addr) *obj.Prog {
+	prog := builder.NewProg()
+	prog.As = as
+	prog.To = to
+	prog.From = from
+	return prog
+}
+
+// arithmetic returns "regTo = regLHS <as> regRHS" for VEX instructions
+// and "regTo <as>= regRHS" for SSE instructions (regLHS should be
+// equal to regTo in this case).
+func arithmetic(builder *asm.Builder, as obj.As, isVEX bool, regTo, regLHS, regRHS int16) *obj.Prog {
+	prog := builder.NewProg()
+	prog.As = as
+	prog.To = regAddr(regTo)
+	prog.From = regAddr(regRHS)
+	if isVEX {
+		prog.SetFrom3(regAddr(regLHS))
+	}
+	return prog
+}
+
+func jump(builder *asm.Builder, as obj.As, target *obj.Prog) *obj.Prog {
+	prog := builder.NewProg()
+	prog.As = as
+	prog.To.Type = obj.TYPE_BRANCH
+	prog.To.SetTarget(target)
+	return prog
+}
+
+func nop(builder *asm.Builder) *obj.Prog {
+	prog := builder.NewProg()
+	prog.As = obj.ANOP
+	return prog
+}
+
+// assembleBatch converts ops to a native code which evaluates the
+// expression for multiple rows at once.
+//
+// The code reads the pointers to the values of the symbols (and to the
+// output) from `columnsRaw` and the index masks and the sizes
+// from `paramsRaw`, see batchKernel.
+func (ops Ops) assembleBatch(columnsRaw []unsafe.Pointer, paramsRaw []uint64, features BatchFeatures) (machineCode []byte, err error) {
+	columnsPtr := uint64((*reflect.SliceHeader)(unsafe.Pointer(&columnsRaw)).Data)
+	paramsPtr := uint64((*reflect.SliceHeader)(unsafe.Pointer(&paramsRaw)).Data)
+	symsCount := int64(len(columnsRaw) - 1)
+	isFMAEnabled := features.AVX && features.FMA
+
+	builder, _ := asm.NewBuilder("amd64", 64)
+	addInstruction := func(prog *obj.Prog) *obj.Prog {
+		builder.AddInstruction(prog)
+		return prog
+	}
+
+	// All general purpose registers are caller-saved in Go ABI, the only
+	// registers we should not touch are: SP, BP, R14 (current goroutine)
+	// and X15 (should be zero).
+	columnsPtrReg := int16(x86.REG_BX)
+	paramsPtrReg := int16(x86.REG_SI)
+	offsetReg := int16(x86.REG_CX)
+	packedEndReg := int16(x86.REG_R8)
+	endReg := int16(x86.REG_R9)
+	outPtrReg := int16(x86.REG_R10)
+	valuePtrReg := int16(x86.REG_AX)
+	valueOffsetReg := int16(x86.REG_DX)
+
+	itemSize := int64(unsafe.Sizeof(float64(0)))
+	addInstruction(pushQ(builder, x86.REG_BP))
+	addInstruction(movQImmediate(builder, x86.REG_BP, x86.REG_SP))
+
+	addInstruction(movQImmediateConst(builder, columnsPtrReg, int64(columnsPtr)))
+	addInstruction(movQImmediateConst(builder, paramsPtrReg, int64(paramsPtr)))
+	addInstruction(move(builder, x86.AMOVQ, regAddr(outPtrReg), offsetAddr(columnsPtrReg, symsCount*itemSize)))
+	addInstruction(move(builder, x86.AMOVQ, regAddr(packedEndReg), offsetAddr(paramsPtrReg, symsCount*itemSize)))
+	addInstruction(move(builder, x86.AMOVQ, regAddr(endReg), offsetAddr(paramsPtrReg, (symsCount+1)*itemSize)))
+	addInstruction(movQImmediateConst(builder, offsetReg, 0))
+
+	// addLoop adds a loop which evaluates the expression for rows
+	// from offsetReg to endReg.
+	addLoop := func(instructions batchInstructions, endReg int16) error {
+		reg := func(stackIdx int) int16 {
+			return instructions.FirstReg + int16(stackIdx)
+		}
+		fetch := func(symIdx, stackIdx int) {
+			addInstruction(move(builder, x86.AMOVQ, regAddr(valuePtrReg), offsetAddr(columnsPtrReg, int64(symIdx)*itemSize)))
+			addInstruction(move(builder, x86.AMOVQ, regAddr(valueOffsetReg), offsetAddr(paramsPtrReg, int64(symIdx)*itemSize)))
+			addInstruction(move(builder, x86.AANDQ, regAddr(valueOffsetReg), regAddr(offsetReg)))
+			addInstruction(move(builder, instructions.Move, regAddr(reg(stackIdx)), indexedAddr(valuePtrReg, valueOffsetReg)))
+		}
+
+		done := nop(builder)
+		addInstruction(move(builder, x86.ACMPQ, regAddr(endReg), regAddr(offsetReg)))
+		addInstruction(jump(builder, x86.AJGE, done))
+		loopBegin := addInstruction(nop(builder))
+
+		symIdx := 0
+		stackLen := 0
+		for opIdx := 0; opIdx < len(ops); opIdx++ {
+			op := ops[opIdx]
+			if op == types.OpFetch {
+				if stackLen >= batchMaxStackDepth {
+					return fmt.Errorf("the expression requires more than %d registers", batchMaxStackDepth)
+				}
+				fetch(symIdx, stackLen)
+				symIdx++
+				stackLen++
+				continue
+			}
+
+			if op == types.OpFMA {
+				if !isFMAEnabled || instructions.FMA213 == obj.AXXX {
+					return fmt.Errorf("operation '%s' is not supported", op)
+				}
+				// "a b c fma" -> a = b * a + c
+				addInstruction(arithmetic(builder, instructions.FMA213, true, reg(stackLen-3), reg(stackLen-2), reg(stackLen-1)))
+				stackLen -= 2
+				continue
+			}
+
+			var as obj.As
+			switch op {
+			case types.OpPlus:
+				as = instructions.Plus
+			case types.OpMinus:
+				as = instructions.Minus
+			case types.OpMultiply:
+				as = instructions.Multiply
+			case types.OpDivide:
+				as = instructions.Divide
+			default:
+				return fmt.Errorf("operation '%s' is not supported", op)
+			}
+			addInstruction(arithmetic(builder, as, instructions.IsVEX, reg(stackLen-2), reg(stackLen-2), reg(stackLen-1)))
+			stackLen--
+		}
+		if stackLen != 1 {
+			return fmt.Errorf("expected stack length is 1, but got %d", stackLen)
+		}
+
+		addInstruction(move(builder, instructions.Move, indexedAddr(outPtrReg, offsetReg), regAddr(reg(0))))
+		addInstruction(addQImmediateConst(builder, offsetReg, instructions.ItemCount*itemSize))
+		addInstruction(move(builder, x86.ACMPQ, regAddr(endReg), regAddr(offsetReg)))
+		addInstruction(jump(builder, x86.AJLT, loopBegin))
+		addInstruction(done)
+		return nil
+	}
+
+	packedInstructions := batchInstructionsSSE2
+	if features.AVX {
+		packedInstructions = batchInstructionsAVX
+	}
+	if err := addLoop(packedInstructions, packedEndReg); err != nil {
+		return nil, err
+	}
+	if features.AVX {
+		addInstruction(move(builder, x86.AVZEROUPPER, obj.Addr{}, obj.Addr{}))
+	}
+	if err := addLoop(batchInstructionsScalar, endReg); err != nil {
+		return nil, err
+	}
+
+	addInstruction(movQImmediate(builder, x86.REG_SP, x86.REG_BP))
+	addInstruction(popQ(builder, x86.REG_BP))
+	addInstruction(ret(builder))
+
+	machineCode = builder.Assemble()
+	return
+}
@@ -0,0 +1,11 @@
+package rpn
+
+// SetNativeFMA overrides if "fma" is compiled into VFMADD231SD (see
+// hasNativeFMA) and returns the function to restore the old value.
+func SetNativeFMA(value bool) (restore func()) {
+	old := hasNativeFMA
+	hasNativeFMA = value
+	return func() {
+		hasNativeFMA = old
+	}
+}
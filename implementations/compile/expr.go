@@ -1,32 +1,44 @@
 package rpn
 
 import (
-	"fmt"
 	"runtime"
-	"strings"
 
 	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
 	"github.com/xaionaro-go/rpn/types"
 )
 
 var (
-	_ types.Expr = &Expr{}
+	_ types.Expr              = &Expr{}
+	_ types.SpecializableExpr = &Expr{}
 )
 
 // Expr is an implementation of types.Expr which uses LLVM JIT code to
 // evaluate the expression.
 //
 // WARNING! This is unsafe implementation, do not use it if you haven't
-// checked Ops.Compile by yourself!
+// checked Ops.Compile by yourself! See also Expr.Disassemble.
 type Expr struct {
 	Description           string
+	Ops                   Ops
 	Code                  func() float64
 	Syms                  []Symbol
 	ResultCache           types.NullFloat64
 	IsMemoizationEnabled  bool
+	BatchFeatures         BatchFeatures
+	Snapshot              *ir.Snapshot
+	machineCode           []byte
 	stack                 []float64
 	values                []float64
 	nonStaticValueIndices []int
+	batchKernel           *batchKernel
+
+	// IR is the parsed expression the Expr is built from (see Specialize).
+	IR *ir.Expr
+
+	// hasSums is true if the expression contains compensated sums
+	// (see ir.CompensatedSummation), which cannot be evaluated by batches.
+	hasSums bool
 }
 
 // Symbol provides information how to extract the value and what name
@@ -56,6 +68,9 @@ func (expr *Expr) Eval() float64 {
 }
 
 func (expr *Expr) eval() float64 {
+	if expr.Snapshot != nil {
+		expr.Snapshot.Load()
+	}
 	values := expr.values
 	for _, idx := range expr.nonStaticValueIndices {
 		values[idx] = expr.Syms[idx].Load()
@@ -68,41 +83,50 @@ func (expr *Expr) eval() float64 {
 //
 // input example: "z x y + *"
 // calculation interpretation: z * (x + y)
-func Parse(expression string, symResolver types.SymbolResolver) (*Expr, error) {
+func Parse(expression string, symResolver types.SymbolResolver, opts ...ir.Option) (*Expr, error) {
+	irExpr, err := ir.Parse(expression, symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return FromIR(irExpr)
+}
 
+// FromIR builds an Expr from an already parsed expression.
+func FromIR(irExpr *ir.Expr) (*Expr, error) {
 	ops := Ops{}
 	expr := &Expr{
-		Description: expression,
+		Description:   irExpr.Source,
+		BatchFeatures: DetectBatchFeatures(),
+		Snapshot:      irExpr.Snapshot,
+		IR:            irExpr,
 	}
-	parts := strings.Split(expression, " ")
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		op := types.ParseOp(part)
-
-		if op != types.OpUndefined {
-			ops = append(ops, op)
-			continue
-		}
-		ops = append(ops, types.OpFetch)
-
-		parsedValue, err := internal.ParseValue(part, symResolver)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse value '%s': %w", part, err)
+	irExpr.Root.Walk(func(node *ir.Node) {
+		ops = append(ops, node.Op)
+		if node.Op != types.OpFetch {
+			return
 		}
 
 		sym := Symbol{
-			ParsedValue: parsedValue,
-			Name:        part,
+			ParsedValue: internal.ParsedValue{
+				ConstValue: node.ConstValue,
+				FuncValue:  node.FuncValue,
+			},
+			Name: node.Token,
 		}
-		if !parsedValue.ConstValue.Valid {
+		if node.Sum != nil {
+			expr.hasSums = true
+		}
+		if !node.ConstValue.Valid {
 			expr.nonStaticValueIndices = append(expr.nonStaticValueIndices, len(expr.Syms))
 		}
 		expr.Syms = append(expr.Syms, sym)
-	}
+	})
 
-	expr.stack = make([]float64, len(expr.Syms))
+	stackDepth := ops.StackDepth()
+	if stackDepth == 0 {
+		stackDepth = 1
+	}
+	expr.stack = make([]float64, stackDepth)
 	expr.values = make([]float64, len(expr.Syms))
 
 	for idx, sym := range expr.Syms {
@@ -111,10 +135,17 @@ func Parse(expression string, symResolver types.SymbolResolver) (*Expr, error) {
 		}
 	}
 
+	expr.Ops = ops
+	var entryPoints, fallbackFMAIdxs []int
+	expr.machineCode, entryPoints, fallbackFMAIdxs = ops.assemble(expr.stack, expr.values)
+
 	var cleanup func()
-	expr.Code, cleanup = ops.Compile(expr.stack, expr.values)
+	expr.Code, cleanup = loadCode(expr.machineCode, entryPoints, fallbackFMAIdxs, expr.stack)
 	runtime.SetFinalizer(expr, func(expr *Expr) {
 		cleanup()
+		if expr.batchKernel != nil {
+			expr.batchKernel.cleanup()
+		}
 	})
 	return expr, nil
 }
@@ -124,9 +155,32 @@ func (expr *Expr) String() string {
 	return expr.Description
 }
 
+// MachineCode returns the native code generated for the expression.
+//
+// The code contains the addresses of the internal buffers, so it differs
+// from one Parse to another; use Disassemble to compare the generated code.
+func (expr *Expr) MachineCode() []byte {
+	return append([]byte{}, expr.machineCode...)
+}
+
+// Disassemble returns a human-readable listing of the native code generated
+// for the expression. See also Ops.Disassemble.
+func (expr *Expr) Disassemble() string {
+	return expr.Ops.Disassemble(expr.stack, expr.values)
+}
+
 // EnableMemoization implements types.Expr
 func (expr *Expr) EnableMemoization(newValue bool) (oldValue bool) {
 	oldValue = expr.IsMemoizationEnabled
 	expr.IsMemoizationEnabled = newValue
 	return
 }
+
+// Specialize implements types.SpecializableExpr
+func (expr *Expr) Specialize(values map[string]float64) (types.Expr, error) {
+	specialized, err := FromIR(expr.IR.Specialize(values))
+	if err != nil {
+		return nil, err
+	}
+	return specialized, nil
+}
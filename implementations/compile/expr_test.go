@@ -0,0 +1,236 @@
+package rpn_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	rpn "github.com/xaionaro-go/rpn/implementations/compile"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/tests"
+	"github.com/xaionaro-go/rpn/types"
+	"golang.org/x/sys/cpu"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+func TestExpr_Disassemble(t *testing.T) {
+	for name, exprString := range map[string]string{
+		"const":          "b10 3.5 4 + *",
+		"syms":           "y x0 x1 + *",
+		"all_operations": "x0 x1 + y - z * x1 /",
+		"deep":           strings.Repeat("z ", 16) + strings.Repeat("+ ", 15),
+		"fma":            "y x0 x1 z fma *",
+		"fma_fallback":   "y x0 x1 z fma *",
+	} {
+		t.Run(name, func(t *testing.T) {
+			// the code is not executed, so the listing does not depend on the CPU
+			defer rpn.SetNativeFMA(name != "fma_fallback")()
+			expr, err := rpn.Parse(exprString, tests.DummyResolver{T: t})
+			require.NoError(t, err)
+
+			goldenPath := filepath.Join("testdata", name+".golden")
+			if *updateGolden {
+				require.NoError(t, ioutil.WriteFile(goldenPath, []byte(expr.Disassemble()), 0644))
+			}
+			golden, err := ioutil.ReadFile(goldenPath)
+			require.NoError(t, err)
+			require.Equal(t, string(golden), expr.Disassemble(), exprString)
+
+			// the listing does not depend on the addresses of the buffers
+			exprAgain, err := rpn.Parse(exprString, tests.DummyResolver{T: t})
+			require.NoError(t, err)
+			require.Equal(t, expr.Disassemble(), exprAgain.Disassemble())
+		})
+	}
+}
+
+func TestExpr_MachineCode(t *testing.T) {
+	expr, err := rpn.Parse("y x0 x1 + *", tests.DummyResolver{T: t})
+	require.NoError(t, err)
+
+	code := expr.MachineCode()
+	require.NotEmpty(t, code)
+	require.Equal(t, byte(0xc3), code[len(code)-1]) // RET
+	code[0] = 0
+	require.NotEqual(t, code, expr.MachineCode(), "the code should be returned as a copy")
+	require.Equal(t, float64(20), expr.Eval())
+}
+
+func TestExpr_LargeExpression(t *testing.T) {
+	for name, exprString := range map[string]string{
+		"left_deep":  "z" + strings.Repeat(" z +", 9999),
+		"right_deep": strings.Repeat("z ", 10000) + strings.Repeat("+ ", 9999),
+		"mixed":      strings.Repeat("z x0 x1 * + ", 5000) + strings.Repeat("+ ", 4999),
+	} {
+		t.Run(name, func(t *testing.T) {
+			expr, err := rpn.Parse(exprString, tests.DummyResolver{T: t})
+			require.NoError(t, err)
+			expected := float64(10000)
+			if name == "mixed" {
+				expected = 5000 * (1 + 2*3)
+			}
+			require.Equal(t, expected, expr.Eval())
+		})
+	}
+}
+
+// rowResolver resolves symbols to the values of the current row of columns.
+type rowResolver struct {
+	Columns map[string][]float64
+	Row     int
+}
+
+func (r *rowResolver) Resolve(sym string) (types.ValueLoader, error) {
+	switch sym {
+	case "c":
+		return types.StaticValue(3), nil
+	case "k":
+		return types.FuncValue(func() float64 {
+			return 0.5
+		}), nil
+	}
+	if _, ok := r.Columns[sym]; !ok {
+		return nil, fmt.Errorf("unknown symbol '%s'", sym)
+	}
+	return types.FuncValue(func() float64 {
+		return r.Columns[sym][r.Row]
+	}), nil
+}
+
+func TestExpr_EvalBatch(t *testing.T) {
+	randGen := rand.New(rand.NewSource(0))
+	columns := map[string][]float64{}
+	for _, name := range []string{"a", "b", "x"} {
+		column := make([]float64, 37)
+		for idx := range column {
+			column[idx] = randGen.Float64()*200 - 100
+		}
+		columns[name] = column
+	}
+
+	detected := rpn.DetectBatchFeatures()
+	for _, features := range []rpn.BatchFeatures{{}, {AVX: true}, {AVX: true, FMA: true}} {
+		if (features.AVX && !detected.AVX) || (features.FMA && !detected.FMA) {
+			continue
+		}
+		t.Run(fmt.Sprintf("%+v", features), func(t *testing.T) {
+			exprStrings := []string{
+				"x",
+				"c",
+				"a b + x *",
+				"a b - x / c k * -",
+				"a b * x +",
+				"x a b * +",
+				"a b * c k * + x x * -",
+				strings.Repeat("a ", 20) + strings.Repeat("+ ", 19),
+			}
+			exprStrings = append(exprStrings, "a b x fma", "x a b c fma *")
+			for _, exprString := range exprStrings {
+				resolver := &rowResolver{Columns: columns}
+				expr, err := rpn.Parse(exprString, resolver)
+				require.NoError(t, err)
+				expr.BatchFeatures = features
+
+				for _, rows := range []int{0, 1, 3, 4, 5, 37} {
+					batchColumns := map[string][]float64{}
+					for name, column := range columns {
+						batchColumns[name] = column[:rows]
+					}
+					out := make([]float64, rows)
+					require.NoError(t, expr.EvalBatch(batchColumns, out))
+
+					for row := range out {
+						resolver.Row = row
+						expected := expr.Eval()
+						require.Equal(t, expected, out[row], "'%s' row %d", exprString, row)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("invalid_column", func(t *testing.T) {
+		expr, err := rpn.Parse("a b +", &rowResolver{Columns: columns})
+		require.NoError(t, err)
+		require.Error(t, expr.EvalBatch(map[string][]float64{"a": columns["a"][:3]}, make([]float64, 2)))
+	})
+}
+
+func TestExpr_EvalBatch_snapshotSymbols(t *testing.T) {
+	x := []float64{1, 2, 3}
+	resolver := &rowResolver{Columns: map[string][]float64{"x": x, "y": {0}}}
+	for _, exprString := range []string{
+		"x y +",
+		// too deep for the batch kernel (evaluated by rows)
+		"x " + strings.Repeat("y ", 20) + strings.Repeat("+ ", 20),
+	} {
+		expr, err := rpn.Parse(exprString, resolver, ir.SnapshotSymbols)
+		require.NoError(t, err)
+		for _, y := range []float64{5, 7} {
+			resolver.Columns["y"][0] = y
+			out := make([]float64, len(x))
+			require.NoError(t, expr.EvalBatch(map[string][]float64{"x": x}, out))
+			for row := range out {
+				require.Equal(t, x[row]+y*float64(strings.Count(exprString, "y")), out[row], "'%s' row %d", exprString, row)
+			}
+		}
+	}
+}
+
+func BenchmarkExpr_EvalBatch(b *testing.B) {
+	columns := map[string][]float64{
+		"a": make([]float64, 1024),
+		"b": make([]float64, 1024),
+		"x": make([]float64, 1024),
+	}
+	out := make([]float64, 1024)
+	expr, err := rpn.Parse("a b * x + a /", &rowResolver{Columns: columns})
+	require.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = expr.EvalBatch(columns, out)
+	}
+}
+
+func TestExpr_fma(t *testing.T) {
+	for _, isNative := range []bool{true, false} {
+		if isNative && !cpu.X86.HasFMA {
+			continue
+		}
+		t.Run(fmt.Sprintf("native=%v", isNative), func(t *testing.T) {
+			defer rpn.SetNativeFMA(isNative)()
+
+			// spills the register stack (see registerStackSize)
+			exprString := strings.Repeat("x0 ", 16) + strings.Repeat("x1 fma ", 15)
+			expected := math.FMA(2, 2, 3)
+			for i := 1; i < 15; i++ {
+				expected = math.FMA(2, expected, 3)
+			}
+			expr, err := rpn.Parse(exprString, tests.DummyResolver{T: t})
+			require.NoError(t, err)
+			require.Equal(t, 17, expr.Ops.StackDepth())
+			require.Equal(t, expected, expr.Eval())
+
+			// rounded once: 0.1*10 is exactly 1+2^-54
+			expr, err = rpn.Parse("x0 0.1 10 -1 fma *", tests.DummyResolver{T: t})
+			require.NoError(t, err)
+			require.Equal(t, 2*math.FMA(0.1, 10, -1), expr.Eval())
+			require.NotZero(t, expr.Eval())
+		})
+	}
+}
+
+func TestExpr_EvalBatch_compensatedSums(t *testing.T) {
+	expr, err := rpn.Parse("x0 x1 + z + y *", tests.DummyResolver{T: t}, ir.CompensatedSummation)
+	require.NoError(t, err)
+	require.Equal(t, float64(24), expr.Eval())
+	require.Error(t, expr.EvalBatch(map[string][]float64{"x0": {1}}, make([]float64, 1)))
+}
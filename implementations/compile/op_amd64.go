@@ -1,7 +1,11 @@
 package rpn
 
 import (
+	"fmt"
+	"math"
 	"reflect"
+	"strings"
+	"sync"
 	"unsafe"
 
 	"github.com/nelhage/gojit"
@@ -9,8 +13,14 @@ import (
 	"github.com/twitchyliquid64/golang-asm/obj"
 	"github.com/twitchyliquid64/golang-asm/obj/x86"
 	"github.com/xaionaro-go/rpn/types"
+	"golang.org/x/sys/cpu"
 )
 
+// hasNativeFMA defines if "fma" is compiled into VFMADD231SD. Otherwise
+// each "fma" ends a chunk (see Assemble) and it is evaluated by math.FMA
+// after the function of the chunk.
+var hasNativeFMA = cpu.X86.HasFMA
+
 func addQImmediateConst(builder *asm.Builder, reg int16, in int64) *obj.Prog {
 	prog := builder.NewProg()
 	prog.As = x86.AADDQ
@@ -31,16 +41,6 @@ func movQImmediateConst(builder *asm.Builder, reg int16, in int64) *obj.Prog {
 	return prog
 }
 
-func subQImmediateConst(builder *asm.Builder, reg int16, in int64) *obj.Prog {
-	prog := builder.NewProg()
-	prog.As = x86.ASUBQ
-	prog.To.Type = obj.TYPE_REG
-	prog.To.Reg = reg
-	prog.From.Type = obj.TYPE_CONST
-	prog.From.Offset = in
-	return prog
-}
-
 func movQImmediate(builder *asm.Builder, regTo, regFrom int16) *obj.Prog {
 	prog := builder.NewProg()
 	prog.As = x86.AMOVQ
@@ -51,16 +51,6 @@ func movQImmediate(builder *asm.Builder, regTo, regFrom int16) *obj.Prog {
 	return prog
 }
 
-func load(builder *asm.Builder, regTo, regFrom int16) *obj.Prog {
-	prog := builder.NewProg()
-	prog.As = x86.AMOVQ
-	prog.To.Type = obj.TYPE_REG
-	prog.To.Reg = regTo
-	prog.From.Type = obj.TYPE_MEM
-	prog.From.Reg = regFrom
-	return prog
-}
-
 func loadSDOffset(builder *asm.Builder, regTo, regFrom int16, offset int64) *obj.Prog {
 	prog := builder.NewProg()
 	prog.As = x86.AMOVSD
@@ -72,16 +62,6 @@ func loadSDOffset(builder *asm.Builder, regTo, regFrom int16, offset int64) *obj
 	return prog
 }
 
-func store(builder *asm.Builder, regTo, regFrom int16) *obj.Prog {
-	prog := builder.NewProg()
-	prog.As = x86.AMOVQ
-	prog.To.Type = obj.TYPE_MEM
-	prog.To.Reg = regTo
-	prog.From.Type = obj.TYPE_REG
-	prog.From.Reg = regFrom
-	return prog
-}
-
 func storeSDOffset(builder *asm.Builder, regTo, regFrom int16, offset int64) *obj.Prog {
 	prog := builder.NewProg()
 	prog.As = x86.AMOVSD
@@ -133,6 +113,32 @@ func divSD(builder *asm.Builder, regTo, regFrom int16) *obj.Prog {
 	return prog
 }
 
+// fmaSD returns "regTo += regLHS * regRHS" (rounded once), it requires
+// the FMA extension of the CPU (see hasNativeFMA).
+func fmaSD(builder *asm.Builder, regTo, regLHS, regRHS int16) *obj.Prog {
+	prog := builder.NewProg()
+	prog.As = x86.AVFMADD231SD
+	prog.To.Type = obj.TYPE_REG
+	prog.To.Reg = regTo
+	prog.From.Type = obj.TYPE_REG
+	prog.From.Reg = regRHS
+	prog.SetFrom3(obj.Addr{
+		Type: obj.TYPE_REG,
+		Reg:  regLHS,
+	})
+	return prog
+}
+
+func movSD(builder *asm.Builder, regTo, regFrom int16) *obj.Prog {
+	prog := builder.NewProg()
+	prog.As = x86.AMOVSD
+	prog.To.Type = obj.TYPE_REG
+	prog.To.Reg = regTo
+	prog.From.Type = obj.TYPE_REG
+	prog.From.Reg = regFrom
+	return prog
+}
+
 func pushQ(builder *asm.Builder, reg int16) *obj.Prog {
 	prog := builder.NewProg()
 	prog.As = x86.APUSHQ
@@ -155,89 +161,312 @@ func ret(builder *asm.Builder) *obj.Prog {
 	return prog
 }
 
+const (
+	// registerStackSize is the amount of XMM registers used to store
+	// the top of the evaluation stack (X0-X13), the rest of the stack
+	// is stored in the memory.
+	registerStackSize = 14
+
+	// maxChunkLength is the maximal amount of Ops compiled into
+	// a single native function.
+	maxChunkLength = 2048
+)
+
 // Compile converts ops to a native code which could be executed by calling
 // function `eval`. It will always read incoming values from the pointer
 // stored in slice `valuesRaw`.
+//
+// The length of `stackRaw` should be not less than ops.StackDepth().
 func (ops Ops) Compile(stackRaw []float64, valuesRaw []float64) (eval func() float64, cleanup func()) {
-	// See also: http://staffwww.fullcoll.edu/aclifton/cs241/lecture-floating-point-simd.html
+	code, entryPoints, fallbackFMAIdxs := ops.assemble(stackRaw, valuesRaw)
+	return loadCode(code, entryPoints, fallbackFMAIdxs, stackRaw)
+}
+
+// StackDepth returns the maximal amount of values in the stack
+// during the evaluation.
+func (ops Ops) StackDepth() int {
+	depth, maxDepth := 0, 0
+	for _, op := range ops {
+		if op == types.OpFetch {
+			depth++
+		} else {
+			depth -= op.Arity() - 1
+		}
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+	return maxDepth
+}
+
+// Assemble converts ops to a native code (without loading it to an
+// executable memory).
+//
+// Large expressions are split into chunks of maxChunkLength Ops, each
+// chunk is a separate function and the functions should be called one
+// after another. `entryPoints` are the offsets of the functions in
+// `machineCode`.
+//
+// If the CPU does not support FMA, then each "fma" also ends a chunk,
+// and it should be evaluated on the stack (in the memory) after the
+// function of the chunk (Compile does that with math.FMA).
+func (ops Ops) Assemble(stackRaw []float64, valuesRaw []float64) (machineCode []byte, entryPoints []int) {
+	machineCode, entryPoints, _ = ops.assemble(stackRaw, valuesRaw)
+	return
+}
+
+// assemble is Assemble which also returns the indices of the stack
+// to evaluate "fma" at after each function (-1 if there is no such
+// "fma", see assembledChunk.FallbackFMAIdx).
+func (ops Ops) assemble(stackRaw []float64, valuesRaw []float64) (machineCode []byte, entryPoints []int, fallbackFMAIdxs []int) {
+	chunks := ops.assembleChunks(stackRaw, valuesRaw)
+	for _, chunk := range chunks {
+		entryPoints = append(entryPoints, len(machineCode))
+		fallbackFMAIdxs = append(fallbackFMAIdxs, chunk.FallbackFMAIdx)
+		machineCode = append(machineCode, chunk.Code...)
+	}
+	return
+}
+
+// Disassemble returns a human-readable listing of the code returned by
+// Assemble.
+//
+// The listing is stable between runs: addresses of `stackRaw` and
+// `valuesRaw` are printed as "$stack" and "$values" and their bytes are
+// masked as "??".
+func (ops Ops) Disassemble(stackRaw []float64, valuesRaw []float64) string {
+	var result strings.Builder
+	offset := 0
+	for idx, chunk := range ops.assembleChunks(stackRaw, valuesRaw) {
+		fmt.Fprintf(&result, "chunk %d:\n", idx)
+		result.WriteString(disassemble(chunk.Code, offset, chunk.Progs, chunk.AddressNames))
+		if idx := chunk.FallbackFMAIdx; idx >= 0 {
+			fmt.Fprintf(&result, "\tmath.FMA($stack[%d], $stack[%d], $stack[%d]) -> $stack[%d]\n", idx, idx+1, idx+2, idx)
+		}
+		offset += len(chunk.Code)
+	}
+	return result.String()
+}
+
+type assembledChunk struct {
+	Code         []byte
+	Progs        []*obj.Prog
+	AddressNames map[*obj.Prog]string
 
+	// FallbackFMAIdx is the index of the first operand of "fma" in
+	// the stack, which should be evaluated after the function of the chunk
+	// (see hasNativeFMA). It is -1 if the chunk does not end with "fma".
+	FallbackFMAIdx int
+}
+
+func (ops Ops) assembleChunks(stackRaw []float64, valuesRaw []float64) []assembledChunk {
 	stackPtr := uint64((*reflect.SliceHeader)(unsafe.Pointer(&stackRaw)).Data)
 	valuesPtr := uint64((*reflect.SliceHeader)(unsafe.Pointer(&valuesRaw)).Data)
 
+	// the ends of the chunks
+	var ends []int
+	begin := 0
+	for idx, op := range ops {
+		if idx+1-begin == maxChunkLength || (op == types.OpFMA && !hasNativeFMA) {
+			ends = append(ends, idx+1)
+			begin = idx + 1
+		}
+	}
+	if begin < len(ops) || len(ends) == 0 {
+		ends = append(ends, len(ops))
+	}
+	chunks := make([]assembledChunk, len(ends))
+
+	var wg sync.WaitGroup
+	symIdx, stackLen := 0, 0
+	begin = 0
+	for chunkIdx, end := range ends {
+		chunkOps := ops[begin:end]
+		begin = end
+
+		wg.Add(1)
+		go func(chunk *assembledChunk, symIdx, stackLen int) {
+			defer wg.Done()
+			*chunk = chunkOps.assembleChunk(stackPtr, valuesPtr, symIdx, stackLen)
+		}(&chunks[chunkIdx], symIdx, stackLen)
+
+		for _, op := range chunkOps {
+			if op == types.OpFetch {
+				symIdx++
+				stackLen++
+			} else {
+				stackLen -= op.Arity() - 1
+			}
+		}
+	}
+	wg.Wait()
+	return chunks
+}
+
+// assembleChunk converts ops to a native function. The function expects
+// `stackLen` values in the stack (in memory) and starts reading values
+// from index `symIdx`. All the stack is stored back to the memory
+// at the end of the function.
+func (ops Ops) assembleChunk(stackPtr, valuesPtr uint64, symIdx, stackLen int) assembledChunk {
+	// See also: http://staffwww.fullcoll.edu/aclifton/cs241/lecture-floating-point-simd.html
+
 	builder, _ := asm.NewBuilder("amd64", 64)
+	chunk := assembledChunk{
+		AddressNames:   map[*obj.Prog]string{},
+		FallbackFMAIdx: -1,
+	}
+	addInstruction := func(prog *obj.Prog) *obj.Prog {
+		builder.AddInstruction(prog)
+		chunk.Progs = append(chunk.Progs, prog)
+		return prog
+	}
 
-	tempReg := int16(x86.REG_AX)
+	// All registers except SP, BP, R14 and X15 are caller-saved in Go ABI,
+	// so we do not need to restore them.
 	stackPtrReg := int16(x86.REG_DI)
 	valuesPtrReg := int16(x86.REG_SI)
 
 	itemSize := int64(unsafe.Sizeof(float64(0)))
-	builder.AddInstruction(pushQ(builder, x86.REG_BP))
-	builder.AddInstruction(movQImmediate(builder, x86.REG_BP, x86.REG_SP))
-	builder.AddInstruction(subQImmediateConst(builder, x86.REG_SP, 32))
-	builder.AddInstruction(storeSDOffset(builder, x86.REG_SP, x86.REG_X0, 0))
-	builder.AddInstruction(storeSDOffset(builder, x86.REG_SP, x86.REG_X1, 16))
-	builder.AddInstruction(pushQ(builder, tempReg))
-	builder.AddInstruction(pushQ(builder, stackPtrReg))
-	builder.AddInstruction(pushQ(builder, valuesPtrReg))
-
-	builder.AddInstruction(movQImmediateConst(builder, stackPtrReg, int64(stackPtr)))
-	builder.AddInstruction(movQImmediateConst(builder, valuesPtrReg, int64(valuesPtr)))
+	addInstruction(pushQ(builder, x86.REG_BP))
+	addInstruction(movQImmediate(builder, x86.REG_BP, x86.REG_SP))
+	chunk.AddressNames[addInstruction(movQImmediateConst(builder, stackPtrReg, int64(stackPtr)))] = "stack"
+	chunk.AddressNames[addInstruction(movQImmediateConst(builder, valuesPtrReg, int64(valuesPtr)))] = "values"
+
+	// The value of index `idx` of the stack is stored in register
+	// X<idx % registerStackSize> if idx >= spilledLen, and in the memory
+	// otherwise.
+	spilledLen := stackLen
+	reg := func(idx int) int16 {
+		return x86.REG_X0 + int16(idx%registerStackSize)
+	}
+	spill := func() {
+		addInstruction(storeSDOffset(builder, stackPtrReg, reg(spilledLen), int64(spilledLen)*itemSize))
+		spilledLen++
+	}
+	fill := func() {
+		spilledLen--
+		addInstruction(loadSDOffset(builder, reg(spilledLen), stackPtrReg, int64(spilledLen)*itemSize))
+	}
 
 	for _, op := range ops {
 		if op == types.OpFetch {
-			builder.AddInstruction(load(builder, tempReg, valuesPtrReg))
-			builder.AddInstruction(addQImmediateConst(builder, valuesPtrReg, itemSize))
-			builder.AddInstruction(store(builder, stackPtrReg, tempReg))
-			builder.AddInstruction(addQImmediateConst(builder, stackPtrReg, itemSize))
+			if stackLen-spilledLen == registerStackSize {
+				spill()
+			}
+			addInstruction(loadSDOffset(builder, reg(stackLen), valuesPtrReg, int64(symIdx)*itemSize))
+			symIdx++
+			stackLen++
 			continue
 		}
 
-		builder.AddInstruction(addQImmediateConst(builder, stackPtrReg, -itemSize))
-		builder.AddInstruction(load(builder, tempReg, stackPtrReg))
-		builder.AddInstruction(movQImmediate(builder, x86.REG_X1, tempReg))
-		builder.AddInstruction(addQImmediateConst(builder, stackPtrReg, -itemSize))
-		builder.AddInstruction(load(builder, tempReg, stackPtrReg))
-		builder.AddInstruction(movQImmediate(builder, x86.REG_X0, tempReg))
+		for stackLen-spilledLen < op.Arity() {
+			fill()
+		}
+		if op == types.OpFMA && !hasNativeFMA {
+			// the last op of the chunk: the operands are spilled and
+			// "fma" is evaluated after the function (see loadCode)
+			chunk.FallbackFMAIdx = stackLen - 3
+			continue
+		}
+		if op == types.OpFMA {
+			// "a b c fma" -> c += a * b; a = c
+			a, b, c := reg(stackLen-3), reg(stackLen-2), reg(stackLen-1)
+			addInstruction(fmaSD(builder, c, a, b))
+			addInstruction(movSD(builder, a, c))
+			stackLen -= 2
+			continue
+		}
+		lhs, rhs := reg(stackLen-2), reg(stackLen-1)
 		switch op {
 		case types.OpPlus:
-			builder.AddInstruction(addSD(builder, x86.REG_X0, x86.REG_X1))
+			addInstruction(addSD(builder, lhs, rhs))
 		case types.OpMinus:
-			builder.AddInstruction(subSD(builder, x86.REG_X0, x86.REG_X1))
+			addInstruction(subSD(builder, lhs, rhs))
 		case types.OpMultiply:
-			builder.AddInstruction(mulSD(builder, x86.REG_X0, x86.REG_X1))
+			addInstruction(mulSD(builder, lhs, rhs))
 		case types.OpDivide:
-			builder.AddInstruction(divSD(builder, x86.REG_X0, x86.REG_X1))
+			addInstruction(divSD(builder, lhs, rhs))
 		case types.OpPower:
 			panic("not implemented")
 		case types.OpIf:
 			panic("not implemented")
 		}
-		builder.AddInstruction(movQImmediate(builder, tempReg, x86.REG_X0))
-		builder.AddInstruction(store(builder, stackPtrReg, tempReg))
-		builder.AddInstruction(addQImmediateConst(builder, stackPtrReg, itemSize))
+		stackLen--
+	}
+	for spilledLen < stackLen {
+		spill()
+	}
+
+	addInstruction(popQ(builder, x86.REG_BP))
+	addInstruction(ret(builder))
+
+	chunk.Code = builder.Assemble()
+	return chunk
+}
+
+// disassemble returns a listing of the assembled progs: an offset,
+// the encoded bytes and the instruction (in Go assembler syntax) per line.
+func disassemble(code []byte, offset int, progs []*obj.Prog, addressNames map[*obj.Prog]string) string {
+	var result strings.Builder
+	for idx, prog := range progs {
+		end := int64(len(code))
+		if idx+1 < len(progs) {
+			end = progs[idx+1].Pc
+		}
+		encoded := make([]string, 0, end-prog.Pc)
+		for _, b := range code[prog.Pc:end] {
+			encoded = append(encoded, fmt.Sprintf("%02x", b))
+		}
+		text := prog.InstructionString()
+		if name, ok := addressNames[prog]; ok {
+			// the address is the last (64bit) immediate of the instruction
+			for idx := len(encoded) - 8; idx < len(encoded); idx++ {
+				encoded[idx] = "??"
+			}
+			text = strings.Replace(text, fmt.Sprintf("$%d", prog.From.Offset), "$"+name, 1)
+		}
+		fmt.Fprintf(&result, "%04x\t%-32s\t%s\n", int64(offset)+prog.Pc, strings.Join(encoded, " "), text)
 	}
-	builder.AddInstruction(popQ(builder, valuesPtrReg))
-	builder.AddInstruction(popQ(builder, stackPtrReg))
-	builder.AddInstruction(popQ(builder, tempReg))
-	builder.AddInstruction(loadSDOffset(builder, x86.REG_X1, x86.REG_SP, 16))
-	builder.AddInstruction(loadSDOffset(builder, x86.REG_X0, x86.REG_SP, 0))
-	builder.AddInstruction(movQImmediate(builder, x86.REG_SP, x86.REG_BP))
-	builder.AddInstruction(popQ(builder, x86.REG_BP))
+	return result.String()
+}
 
-	builder.AddInstruction(ret(builder))
+// loadCode loads the code returned by assemble: after calling function
+// `idx`, "fma" is evaluated by math.FMA on the stack at
+// `fallbackFMAIdxs[idx]` (if it is not negative).
+func loadCode(code []byte, entryPoints []int, fallbackFMAIdxs []int, stackRaw []float64) (eval func() float64, cleanup func()) {
+	fns, cleanup := loadFuncs(code, entryPoints)
+	if len(fns) == 1 && fallbackFMAIdxs[0] < 0 {
+		fn := fns[0]
+		eval = func() float64 {
+			fn()
+			return stackRaw[0]
+		}
+		return
+	}
+	eval = func() float64 {
+		for fnIdx, fn := range fns {
+			fn()
+			if idx := fallbackFMAIdxs[fnIdx]; idx >= 0 {
+				stackRaw[idx] = math.FMA(stackRaw[idx], stackRaw[idx+1], stackRaw[idx+2])
+			}
+		}
+		return stackRaw[0]
+	}
+	return
+}
 
-	code := builder.Assemble()
+// loadFuncs copies the code into an executable memory and returns
+// the functions which starts at offsets `entryPoints`.
+func loadFuncs(code []byte, entryPoints []int) (fns []func(), cleanup func()) {
 	b, e := gojit.Alloc((len(code)/gojit.PageSize + 1) * gojit.PageSize)
 	if e != nil {
 		panic(e)
 	}
 	copy(b, code)
 
-	var fn func()
-	gojit.BuildTo(b, &fn)
-	eval = func() float64 {
-		fn()
-		return stackRaw[0]
+	fns = make([]func(), len(entryPoints))
+	for idx, entryPoint := range entryPoints {
+		gojit.BuildTo(b[entryPoint:], &fns[idx])
 	}
 	cleanup = func() {
 		err := gojit.Release(b)
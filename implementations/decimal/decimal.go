@@ -0,0 +1,96 @@
+package rpn
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Rounding is the policy of rounding a value to the scale of an Expr.
+type Rounding uint8
+
+const (
+	// RoundHalfEven rounds to the nearest value, and a tie to the value
+	// with an even last digit (the banker's rounding): 0.125 -> 0.12.
+	RoundHalfEven = Rounding(iota)
+
+	// RoundHalfUp rounds to the nearest value, and a tie away from
+	// zero: 0.125 -> 0.13, -0.125 -> -0.13.
+	RoundHalfUp
+
+	// RoundTruncate rounds toward zero: 0.129 -> 0.12, -0.129 -> -0.12.
+	RoundTruncate
+)
+
+// String implements fmt.Stringer
+func (rounding Rounding) String() string {
+	switch rounding {
+	case RoundHalfEven:
+		return "half_even"
+	case RoundHalfUp:
+		return "half_up"
+	case RoundTruncate:
+		return "truncate"
+	default:
+		return fmt.Sprintf("unknown_rounding_%d", rounding)
+	}
+}
+
+// round rounds `v` to a multiple of 1/`denom`.
+func (rounding Rounding) round(v *big.Rat, denom *big.Int) *big.Rat {
+	if v.IsInt() {
+		return v
+	}
+	num := new(big.Int).Mul(v.Num(), denom)
+	q, m := new(big.Int).QuoRem(num, v.Denom(), new(big.Int))
+	if m.Sign() != 0 && rounding != RoundTruncate {
+		// QuoRem truncates toward zero, so compare the remainder with
+		// the half to decide if the result should go away from zero
+		cmp := new(big.Int).Lsh(m.Abs(m), 1).Cmp(v.Denom())
+		if cmp > 0 || (cmp == 0 && (rounding == RoundHalfUp || q.Bit(0) == 1)) {
+			q.Add(q, big.NewInt(int64(num.Sign())))
+		}
+	}
+	return v.SetFrac(q, denom)
+}
+
+// Decimal is a decimal number with a fixed amount of fractional digits:
+// Unscaled * 10^(-Scale).
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    uint
+}
+
+// Rat returns the exact value of the decimal.
+func (d Decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(d.Unscaled, pow10(d.Scale))
+}
+
+// Float64 returns the nearest float64 value to the decimal.
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+// String implements fmt.Stringer. It returns the value with exactly
+// Scale fractional digits, for example "-0.30".
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.Unscaled).String()
+	if pad := int(d.Scale) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	sign := ""
+	if d.Unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	intLen := len(digits) - int(d.Scale)
+	if d.Scale == 0 {
+		return sign + digits
+	}
+	return sign + digits[:intLen] + "." + digits[intLen:]
+}
+
+// pow10 returns 10^n.
+func pow10(n uint) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,328 @@
+package rpn
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+var (
+	_ types.Expr              = &Expr{}
+	_ types.SpecializableExpr = &Expr{}
+)
+
+const (
+	// DefaultScale is the amount of fractional digits used by Parse
+	// and FromIR.
+	DefaultScale = 2
+
+	// DefaultRounding is the rounding policy used by Parse and FromIR.
+	DefaultRounding = RoundHalfEven
+
+	// MaxExponent is the maximal absolute value of an exponent of "^".
+	MaxExponent = 1 << 16
+)
+
+// Expr is an implementation of types.Expr which evaluates the expression
+// with exact decimal arithmetic with a fixed amount of fractional digits
+// (Scale), which is useful for money (see EvalDecimal). It is much
+// slower than the other implementations.
+//
+// The literals are parsed as decimals (so "0.1 0.2 +" is exactly 0.3),
+// and the values of the symbols (which are float64) are converted to
+// decimals by their shortest representations (so 0.1 is exactly 0.1).
+// The value of a literal or a symbol is rounded to Scale digits
+// according to Rounding, and so is the result of each operation: "+"
+// and "-" are exact, while "*", "/", "^" and fma are computed exactly
+// and then rounded once.
+//
+// "^" supports only integer exponents (up to MaxExponent by absolute
+// value), and an exponent should be an integer before it is rounded (so
+// "2 0.5 ^" is an error even with scale 0). Division by zero and
+// non-integer exponents are errors (instead of NaN and Inf).
+//
+// Note: the optimizations (like ir.O1) and ir.Expr.Specialize fold
+// constant sub-expressions with float64 precision, so FromIR refuses
+// expressions with folded constants (see ir.Node.IsFolded). Specialize
+// of Expr does not fold anything (see ir.Expr.Substitute).
+type Expr struct {
+	Ops                  []types.Op
+	Syms                 []Symbol
+	Scale                uint
+	Rounding             Rounding
+	ResultCache          types.NullFloat64
+	IsMemoizationEnabled bool
+	Snapshot             *ir.Snapshot
+	evalStack            []*big.Rat
+	isIntStack           []bool
+	denom                *big.Int
+
+	// IR is the parsed expression the Expr is built from (see Specialize).
+	IR *ir.Expr
+}
+
+// Symbol provides information how to extract the value and what name
+// the symbol (of the expression) has.
+type Symbol struct {
+	internal.ParsedValue
+	Name string
+
+	// Const is the value of a constant before it is rounded (nil for
+	// non-constant symbols).
+	Const *big.Rat
+}
+
+// Parse converts Reverse Polish Notation expression "expression" to
+// a Eval()-uatable implementation Expr with scale DefaultScale and
+// rounding DefaultRounding.
+//
+// input example: "z x y + *"
+// calculation interpretation: z * (x + y)
+func Parse(expression string, symResolver types.SymbolResolver, opts ...ir.Option) (*Expr, error) {
+	irExpr, err := ir.Parse(expression, symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return FromIR(irExpr)
+}
+
+// FromIR builds an Expr with scale DefaultScale and rounding
+// DefaultRounding from an already parsed expression.
+func FromIR(irExpr *ir.Expr) (*Expr, error) {
+	return FromIRWithScale(irExpr, DefaultScale, DefaultRounding)
+}
+
+// FromIRWithScale builds an Expr which rounds the values to `scale`
+// fractional digits according to `rounding` from an already parsed
+// expression.
+func FromIRWithScale(irExpr *ir.Expr, scale uint, rounding Rounding) (*Expr, error) {
+	if rounding > RoundTruncate {
+		return nil, fmt.Errorf("invalid rounding: %s", rounding)
+	}
+	expr := &Expr{
+		Scale:    scale,
+		Rounding: rounding,
+		Snapshot: irExpr.Snapshot,
+		IR:       irExpr,
+		denom:    pow10(scale),
+	}
+	var err error
+	depth, maxDepth := 0, 0
+	irExpr.ExpandSums().Root.Walk(func(node *ir.Node) {
+		expr.Ops = append(expr.Ops, node.Op)
+		if node.Op != types.OpFetch {
+			depth -= node.Op.Arity() - 1
+			return
+		}
+		sym := Symbol{
+			Name: node.Token,
+			ParsedValue: internal.ParsedValue{
+				ConstValue: node.ConstValue,
+				FuncValue:  node.FuncValue,
+				IsSymbol:   node.IsSymbol,
+			},
+		}
+		switch {
+		case node.IsFolded:
+			if err == nil {
+				err = fmt.Errorf("constant '%s' at %s is folded with float64 precision by an optimization", node.Token, node.Pos)
+			}
+		case node.ConstValue.Valid:
+			var constErr error
+			if node.IsSymbol {
+				sym.Const, constErr = ratFromFloat64(node.ConstValue.Float64)
+			} else {
+				// a literal or a value substituted by ir.Expr.Substitute
+				sym.Const, constErr = internal.ParseRatLiteral(node.Token)
+			}
+			if constErr != nil && err == nil {
+				err = fmt.Errorf("unable to use constant '%s' at %s: %w", node.Token, node.Pos, constErr)
+			}
+		}
+		expr.Syms = append(expr.Syms, sym)
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	expr.evalStack = make([]*big.Rat, maxDepth)
+	expr.isIntStack = make([]bool, maxDepth)
+	for idx := range expr.evalStack {
+		expr.evalStack[idx] = new(big.Rat)
+	}
+	return expr, nil
+}
+
+// ratFromFloat64 returns the value of the shortest decimal representation
+// of `v` (so 0.1 is exactly 1/10), or an error if `v` is not finite.
+func ratFromFloat64(v float64) (*big.Rat, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("%g is not a finite number", v)
+	}
+	return internal.ParseRatLiteral(strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// Eval implements types.Expr. It returns the result of EvalDecimal
+// converted to float64 (NaN if EvalDecimal returns an error).
+func (expr *Expr) Eval() float64 {
+	if expr.IsMemoizationEnabled && expr.ResultCache.Valid {
+		return expr.ResultCache.Float64
+	}
+
+	r := math.NaN()
+	if result, err := expr.EvalDecimal(); err == nil {
+		r = result.Float64()
+	}
+
+	if expr.IsMemoizationEnabled {
+		expr.ResultCache.Float64 = r
+		expr.ResultCache.Valid = true
+	}
+	return r
+}
+
+// EvalDecimal evaluates the expression with exact decimal arithmetic.
+// It returns an error on division by zero, on a non-integer exponent of
+// "^" and if a symbol has a non-finite value.
+func (expr *Expr) EvalDecimal() (Decimal, error) {
+	if expr.Snapshot != nil {
+		expr.Snapshot.Load()
+	}
+	stack := expr.evalStack
+	// isInt defines if the values of the stack were integers before
+	// they were rounded
+	isInt := expr.isIntStack
+	symIdx := 0
+	stackLen := 0
+	for _, op := range expr.Ops {
+		switch op {
+		case types.OpFetch:
+			if err := expr.Syms[symIdx].load(stack[stackLen]); err != nil {
+				return Decimal{}, fmt.Errorf("unable to load symbol '%s': %w", expr.Syms[symIdx].Name, err)
+			}
+			isInt[stackLen] = stack[stackLen].IsInt()
+			expr.Rounding.round(stack[stackLen], expr.denom)
+			symIdx++
+			stackLen++
+			continue
+		case types.OpFMA:
+			stackLen -= 2
+			a, b, c := stack[stackLen-1], stack[stackLen], stack[stackLen+1]
+			a.Mul(a, b).Add(a, c)
+		default:
+			stackLen--
+			lhs, rhs := stack[stackLen-1], stack[stackLen]
+			if op == types.OpPower && !isInt[stackLen] {
+				return Decimal{}, fmt.Errorf("non-integer exponent (%s after rounding)", rhs.FloatString(3))
+			}
+			if err := evalOp(op, lhs, rhs); err != nil {
+				return Decimal{}, err
+			}
+		}
+		isInt[stackLen-1] = stack[stackLen-1].IsInt()
+		expr.Rounding.round(stack[stackLen-1], expr.denom)
+	}
+
+	// all the values are rounded, so the denominator divides 10^Scale
+	r := stack[0]
+	unscaled := new(big.Int).Mul(r.Num(), expr.denom)
+	return Decimal{
+		Unscaled: unscaled.Quo(unscaled, r.Denom()),
+		Scale:    expr.Scale,
+	}, nil
+}
+
+// load stores the value of the symbol to `dst`.
+func (sym *Symbol) load(dst *big.Rat) error {
+	if sym.Const != nil {
+		dst.Set(sym.Const)
+		return nil
+	}
+	v, err := ratFromFloat64(sym.Load())
+	if err != nil {
+		return err
+	}
+	dst.Set(v)
+	return nil
+}
+
+// evalOp stores the exact result of "lhs rhs op" to `lhs`.
+func evalOp(op types.Op, lhs, rhs *big.Rat) error {
+	switch op {
+	case types.OpPlus:
+		lhs.Add(lhs, rhs)
+	case types.OpMinus:
+		lhs.Sub(lhs, rhs)
+	case types.OpMultiply:
+		lhs.Mul(lhs, rhs)
+	case types.OpDivide:
+		if rhs.Sign() == 0 {
+			return fmt.Errorf("division of %s by zero", lhs.FloatString(3))
+		}
+		lhs.Quo(lhs, rhs)
+	case types.OpPower:
+		return pow(lhs, rhs)
+	case types.OpIf:
+		if lhs.Sign() > 0 {
+			lhs.Set(rhs)
+		} else {
+			lhs.SetInt64(0)
+		}
+	default:
+		panic("do not know how to evaluate op: " + op.String())
+	}
+	return nil
+}
+
+// pow stores "base^exponent" to `base`.
+func pow(base, exponent *big.Rat) error {
+	if !exponent.IsInt() {
+		return fmt.Errorf("non-integer exponent %s", exponent.FloatString(3))
+	}
+	n := exponent.Num()
+	if n.CmpAbs(big.NewInt(MaxExponent)) > 0 {
+		return fmt.Errorf("exponent %s is out of [%d, %d]", n, -MaxExponent, MaxExponent)
+	}
+	if n.Sign() < 0 && base.Sign() == 0 {
+		return fmt.Errorf("division by zero: zero to negative power %s", n)
+	}
+	absN := new(big.Int).Abs(n)
+	num := new(big.Int).Exp(base.Num(), absN, nil)
+	denom := new(big.Int).Exp(base.Denom(), absN, nil)
+	if n.Sign() < 0 {
+		num, denom = denom, num
+	}
+	base.SetFrac(num, denom)
+	return nil
+}
+
+// String implements types.Expr
+func (expr *Expr) String() string {
+	return fmt.Sprintf("%v with %v (scale %d, rounding %s)", expr.Ops, expr.Syms, expr.Scale, expr.Rounding)
+}
+
+// EnableMemoization implements types.Expr
+func (expr *Expr) EnableMemoization(newValue bool) (oldValue bool) {
+	oldValue = expr.IsMemoizationEnabled
+	expr.IsMemoizationEnabled = newValue
+	return
+}
+
+// Specialize implements types.SpecializableExpr. The symbols are
+// replaced with constants without folding (see ir.Expr.Substitute), and
+// the specialized expression has the same scale and rounding.
+func (expr *Expr) Specialize(values map[string]float64) (types.Expr, error) {
+	specialized, err := FromIRWithScale(expr.IR.Substitute(values), expr.Scale, expr.Rounding)
+	if err != nil {
+		return nil, err
+	}
+	return specialized, nil
+}
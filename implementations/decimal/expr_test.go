@@ -0,0 +1,195 @@
+package rpn_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	rpn "github.com/xaionaro-go/rpn/implementations/decimal"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/tests"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+type staticResolver map[string]float64
+
+func (r staticResolver) Resolve(sym string) (types.ValueLoader, error) {
+	v := r[sym]
+	return types.FuncValue(func() float64 { return v }), nil
+}
+
+func evalDecimal(t *testing.T, expression string, scale uint, rounding rpn.Rounding) string {
+	irExpr, err := ir.Parse(expression, staticResolver{"x": 0.1})
+	require.NoError(t, err)
+	expr, err := rpn.FromIRWithScale(irExpr, scale, rounding)
+	require.NoError(t, err)
+	result, err := expr.EvalDecimal()
+	require.NoError(t, err)
+	return result.String()
+}
+
+func TestExpr_EvalDecimal(t *testing.T) {
+	for _, testCase := range []struct {
+		expression string
+		scale      uint
+		expected   string
+	}{
+		{"0.1 0.2 +", 2, "0.30"},
+		{"0.1 0.2 + 0.3 -", 20, "0.00000000000000000000"},
+		{"x x + x +", 20, "0.30000000000000000000"},
+		{"0x7fffffffffffffff 0x7ffffffffffffffe -", 0, "1"},
+		{"b101 o17 h10 + *", 0, "155"},
+		{"1e-3 1000 *", 3, "1.000"},
+		{"10 3 /", 4, "3.3333"},
+		{"-2 3 /", 4, "-0.6667"},
+		{"1.05 12 ^", 6, "1.795856"},
+		{"2 -2 ^", 2, "0.25"},
+		{"-2 3 ^", 0, "-8"},
+		{"5 0 ^", 0, "1"},
+		{"0.5 0.5 0.001 fma", 2, "0.25"},
+		{"1 0.2 if", 1, "0.2"},
+		{"-1 0.2 if", 1, "0.0"},
+		{"0.005", 2, "0.00"},
+		{"-12.5", 0, "-12"},
+	} {
+		t.Run(testCase.expression, func(t *testing.T) {
+			require.Equal(t, testCase.expected, evalDecimal(t, testCase.expression, testCase.scale, rpn.RoundHalfEven))
+		})
+	}
+}
+
+func TestExpr_rounding(t *testing.T) {
+	for _, testCase := range []struct {
+		expression string
+		expected   map[rpn.Rounding]string
+	}{
+		{"0.125 1 *", map[rpn.Rounding]string{
+			rpn.RoundHalfEven: "0.12",
+			rpn.RoundHalfUp:   "0.13",
+			rpn.RoundTruncate: "0.12",
+		}},
+		{"-0.125 1 *", map[rpn.Rounding]string{
+			rpn.RoundHalfEven: "-0.12",
+			rpn.RoundHalfUp:   "-0.13",
+			rpn.RoundTruncate: "-0.12",
+		}},
+		{"0.135", map[rpn.Rounding]string{
+			rpn.RoundHalfEven: "0.14",
+			rpn.RoundHalfUp:   "0.14",
+			rpn.RoundTruncate: "0.13",
+		}},
+		{"2 3 /", map[rpn.Rounding]string{
+			rpn.RoundHalfEven: "0.67",
+			rpn.RoundHalfUp:   "0.67",
+			rpn.RoundTruncate: "0.66",
+		}},
+		{"-1 8 /", map[rpn.Rounding]string{
+			rpn.RoundHalfEven: "-0.12",
+			rpn.RoundHalfUp:   "-0.13",
+			rpn.RoundTruncate: "-0.12",
+		}},
+		{"1 3 / 3 *", map[rpn.Rounding]string{
+			rpn.RoundHalfEven: "0.99",
+			rpn.RoundHalfUp:   "0.99",
+			rpn.RoundTruncate: "0.99",
+		}},
+	} {
+		for rounding, expected := range testCase.expected {
+			t.Run(testCase.expression+"/"+rounding.String(), func(t *testing.T) {
+				require.Equal(t, expected, evalDecimal(t, testCase.expression, 2, rounding))
+			})
+		}
+	}
+}
+
+func TestExpr_errors(t *testing.T) {
+	for _, expression := range []string{"1 0 /", "1 0.01 0.01 - /", "2 0.5 ^", "0 -1 ^", "10 1e6 ^", "bad 1 +"} {
+		t.Run(expression, func(t *testing.T) {
+			expr, err := rpn.Parse(expression, staticResolver{"bad": math.NaN()})
+			require.NoError(t, err)
+			_, err = expr.EvalDecimal()
+			require.Error(t, err)
+			require.True(t, math.IsNaN(expr.Eval()))
+		})
+	}
+
+	_, err := rpn.Parse("Inf 1 +", nil)
+	require.Error(t, err)
+
+	// the exponent is checked before it is rounded to 0
+	irExpr, err := ir.Parse("2 0.5 ^", nil)
+	require.NoError(t, err)
+	expr, err := rpn.FromIRWithScale(irExpr, 0, rpn.RoundHalfEven)
+	require.NoError(t, err)
+	_, err = expr.EvalDecimal()
+	require.Error(t, err)
+}
+
+func TestExpr_Eval(t *testing.T) {
+	expr, err := rpn.Parse("0.1 0.2 +", nil)
+	require.NoError(t, err)
+	require.Equal(t, 0.3, expr.Eval())
+	require.Equal(t, uint(rpn.DefaultScale), expr.Scale)
+	require.Equal(t, rpn.DefaultRounding, expr.Rounding)
+}
+
+func TestExpr_specialize(t *testing.T) {
+	irExpr, err := ir.Parse("x0 3 /", tests.DummyResolver{T: t})
+	require.NoError(t, err)
+	expr, err := rpn.FromIRWithScale(irExpr, 3, rpn.RoundTruncate)
+	require.NoError(t, err)
+	specialized, err := expr.Specialize(map[string]float64{"x0": 2})
+	require.NoError(t, err)
+	require.Equal(t, 0.666, specialized.Eval())
+}
+
+func TestExpr_specializeExact(t *testing.T) {
+	irExpr, err := ir.Parse("x 0.1 0.2 + *", staticResolver{"x": 1})
+	require.NoError(t, err)
+	expr, err := rpn.FromIRWithScale(irExpr, 20, rpn.RoundHalfEven)
+	require.NoError(t, err)
+	for _, values := range []map[string]float64{nil, {"y": 1}, {"x": 1}} {
+		specialized, err := expr.Specialize(values)
+		require.NoError(t, err)
+		result, err := specialized.(*rpn.Expr).EvalDecimal()
+		require.NoError(t, err)
+		require.Equal(t, "0.30000000000000000000", result.String(), values)
+	}
+}
+
+func TestExpr_foldedConstants(t *testing.T) {
+	_, err := rpn.Parse("x 0.1 0.2 + *", staticResolver{}, ir.O1)
+	require.Error(t, err)
+
+	// identities are removed exactly
+	expr, err := rpn.Parse("x 0 ^ y *", staticResolver{"y": 0.1}, ir.O1)
+	require.NoError(t, err)
+	require.Equal(t, 0.1, expr.Eval())
+
+	// O2 negates the subtracted literals
+	irExpr, err := ir.Parse("x 0.1 -", staticResolver{"x": 1}, ir.O2)
+	require.NoError(t, err)
+	expr, err = rpn.FromIRWithScale(irExpr, 20, rpn.RoundHalfEven)
+	require.NoError(t, err)
+	result, err := expr.EvalDecimal()
+	require.NoError(t, err)
+	require.Equal(t, "0.90000000000000000000", result.String())
+
+	_, err = rpn.Parse("x 0.10000000000000000001 -", staticResolver{}, ir.O2)
+	require.Error(t, err)
+}
+
+func TestDecimal_String(t *testing.T) {
+	for _, testCase := range []struct {
+		expression string
+		scale      uint
+		expected   string
+	}{
+		{"0", 3, "0.000"},
+		{"-0.001", 3, "-0.001"},
+		{"123.4", 1, "123.4"},
+		{"-123", 0, "-123"},
+	} {
+		require.Equal(t, testCase.expected, evalDecimal(t, testCase.expression, testCase.scale, rpn.RoundHalfEven))
+	}
+}
@@ -0,0 +1,188 @@
+package rpn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// Derive returns the derivative of the expression with respect to
+// symbol `symbol` as an expression in Reverse Polish Notation (which could
+// be parsed by any implementation).
+//
+// The derivative is simplified: constant sub-expressions are folded and
+// the trivial terms ("0 x *", "x 1 *", "x 0 +", ...) are removed (so
+// the derivative may be not NaN where the straightforward formula is).
+//
+// "if" is derived piecewise: "c u if" -> "c du if" (the derivative at
+// the points where the condition changes its sign is not defined).
+// The exponent of "^" should not depend on the symbol (because there is
+// no logarithm operation to express the derivative).
+func Derive(expr *Expr, symbol string) (string, error) {
+	r, err := derive(expr, symbol)
+	if err != nil {
+		return "", err
+	}
+	return r.RPN, nil
+}
+
+// term is an expression in Reverse Polish Notation, which value
+// is known if it is a constant.
+type term struct {
+	RPN   string
+	Const types.NullFloat64
+}
+
+func constTerm(v float64) term {
+	return term{
+		RPN: strconv.FormatFloat(v, 'g', -1, 64),
+		Const: types.NullFloat64{
+			Float64: v,
+			Valid:   true,
+		},
+	}
+}
+
+func (t term) isConstEqual(v float64) bool {
+	return t.Const.Valid && t.Const.Float64 == v
+}
+
+// opTerm returns "lhs rhs op" with the trivial cases simplified.
+func opTerm(op types.Op, lhs, rhs term) term {
+	if lhs.Const.Valid && rhs.Const.Valid {
+		return constTerm(op.Eval(lhs.Const.Float64, rhs.Const.Float64))
+	}
+	switch op {
+	case types.OpPlus:
+		if lhs.isConstEqual(0) {
+			return rhs
+		}
+		if rhs.isConstEqual(0) {
+			return lhs
+		}
+	case types.OpMinus:
+		if rhs.isConstEqual(0) {
+			return lhs
+		}
+	case types.OpMultiply:
+		if lhs.isConstEqual(0) || rhs.isConstEqual(0) {
+			return constTerm(0)
+		}
+		if lhs.isConstEqual(1) {
+			return rhs
+		}
+		if rhs.isConstEqual(1) {
+			return lhs
+		}
+	case types.OpDivide:
+		if lhs.isConstEqual(0) {
+			return constTerm(0)
+		}
+		if rhs.isConstEqual(1) {
+			return lhs
+		}
+	case types.OpPower:
+		if rhs.isConstEqual(1) {
+			return lhs
+		}
+		if rhs.isConstEqual(0) {
+			return constTerm(1)
+		}
+	case types.OpIf:
+		if rhs.isConstEqual(0) {
+			return rhs
+		}
+	}
+	return term{RPN: lhs.RPN + " " + rhs.RPN + " " + op.String()}
+}
+
+// toTerm returns the expression as a term.
+func toTerm(expr *Expr) term {
+	if expr.Op == types.OpFetch {
+		// symbols are kept even if they are constant (types.StaticValue),
+		// to keep the derivative applicable to other values of them
+		if expr.ConstValue.Valid && !expr.IsSymbol {
+			return constTerm(expr.ConstValue.Float64)
+		}
+		return term{RPN: expr.Symbol}
+	}
+	parts := []string{toTerm(expr.LHS).RPN, toTerm(expr.RHS).RPN}
+	if expr.Addend != nil {
+		parts = append(parts, toTerm(expr.Addend).RPN)
+	}
+	return term{RPN: strings.Join(append(parts, expr.Op.String()), " ")}
+}
+
+func derive(expr *Expr, symbol string) (term, error) {
+	if expr.Op == types.OpFetch {
+		switch {
+		case expr.IsSymbol && expr.Symbol == symbol:
+			return constTerm(1), nil
+		case expr.IsSymbol || expr.ConstValue.Valid:
+			return constTerm(0), nil
+		default:
+			return term{}, fmt.Errorf("unable to derive value '%s': it is neither a constant nor a symbol", expr.Symbol)
+		}
+	}
+
+	lhs, rhs := toTerm(expr.LHS), toTerm(expr.RHS)
+	dLHS, err := derive(expr.LHS, symbol)
+	if err != nil {
+		return term{}, err
+	}
+	dRHS, err := derive(expr.RHS, symbol)
+	if err != nil {
+		return term{}, err
+	}
+
+	switch expr.Op {
+	case types.OpPlus, types.OpMinus:
+		return opTerm(expr.Op, dLHS, dRHS), nil
+	case types.OpMultiply:
+		// (u*v)' = u'*v + u*v'
+		return opTerm(types.OpPlus,
+			opTerm(types.OpMultiply, dLHS, rhs),
+			opTerm(types.OpMultiply, lhs, dRHS),
+		), nil
+	case types.OpDivide:
+		// (u/v)' = (u'*v - u*v') / (v*v)
+		return opTerm(types.OpDivide,
+			opTerm(types.OpMinus,
+				opTerm(types.OpMultiply, dLHS, rhs),
+				opTerm(types.OpMultiply, lhs, dRHS),
+			),
+			opTerm(types.OpMultiply, rhs, rhs),
+		), nil
+	case types.OpPower:
+		if !dRHS.isConstEqual(0) {
+			return term{}, fmt.Errorf("unable to derive '%s': the exponent depends on '%s'", toTerm(expr).RPN, symbol)
+		}
+		// (u^c)' = c * u^(c-1) * u'
+		return opTerm(types.OpMultiply,
+			opTerm(types.OpMultiply,
+				rhs,
+				opTerm(types.OpPower, lhs, opTerm(types.OpMinus, rhs, constTerm(1))),
+			),
+			dLHS,
+		), nil
+	case types.OpIf:
+		return opTerm(types.OpIf, lhs, dRHS), nil
+	case types.OpFMA:
+		dAddend, err := derive(expr.Addend, symbol)
+		if err != nil {
+			return term{}, err
+		}
+		// (u*v + w)' = u'*v + u*v' + w'
+		return opTerm(types.OpPlus,
+			opTerm(types.OpPlus,
+				opTerm(types.OpMultiply, dLHS, rhs),
+				opTerm(types.OpMultiply, lhs, dRHS),
+			),
+			dAddend,
+		), nil
+	default:
+		return term{}, fmt.Errorf("unable to derive operation '%s'", expr.Op)
+	}
+}
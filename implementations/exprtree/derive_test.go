@@ -0,0 +1,127 @@
+package rpn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+type pointResolver struct {
+	X, Y float64
+}
+
+func (r *pointResolver) Resolve(sym string) (types.ValueLoader, error) {
+	switch sym {
+	case "x":
+		return types.FuncValue(func() float64 {
+			return r.X
+		}), nil
+	case "y":
+		return types.FuncValue(func() float64 {
+			return r.Y
+		}), nil
+	case "c":
+		return types.StaticValue(3), nil
+	}
+	return nil, fmt.Errorf("unknown symbol '%s'", sym)
+}
+
+func TestDerive(t *testing.T) {
+	for expression, expected := range map[string]string{
+		"x":             "1",
+		"y":             "0",
+		"2":             "0",
+		"x y +":         "1",
+		"y x -":         "-1",
+		"x x *":         "x x +",
+		"x y *":         "y",
+		"x 3 *":         "3",
+		"x 2 ^":         "2 x *",
+		"x 3 ^":         "3 x 2 ^ *",
+		"x c ^":         "c x c 1 - ^ *",
+		"1 x /":         "-1 x x * /",
+		"x y /":         "y y y * /",
+		"y x if":        "y 1 if",
+		"x 1 if":        "0",
+		"x y 2 fma":     "y",
+		"x x * 0x10 +":  "x x +",
+		"x 2 ^ 3 ^":     "3 x 2 ^ 2 ^ * 2 x * *",
+		"x y + x y - *": "x y - x y + +",
+	} {
+		expr, err := Parse(expression, &pointResolver{})
+		require.NoError(t, err)
+		derivative, err := Derive(expr, "x")
+		require.NoError(t, err, expression)
+		require.Equal(t, expected, derivative, expression)
+	}
+
+	for _, expression := range []string{"2 x ^", "x x ^"} {
+		expr, err := Parse(expression, &pointResolver{})
+		require.NoError(t, err)
+		_, err = Derive(expr, "x")
+		require.Error(t, err, expression)
+	}
+}
+
+func randDifferentiableExpression(randGen *rand.Rand, depth int) string {
+	if depth == 0 || randGen.Intn(4) == 0 {
+		return []string{"x", "x", "y", "c", "2", "0.5"}[randGen.Intn(6)]
+	}
+	lhs := randDifferentiableExpression(randGen, depth-1)
+	switch op := []string{"+", "-", "*", "/", "^", "if", "fma"}[randGen.Intn(7)]; op {
+	case "^":
+		return lhs + " " + []string{"2", "3", "0.5", "-1", "c"}[randGen.Intn(5)] + " ^"
+	case "fma":
+		return lhs + " " + randDifferentiableExpression(randGen, depth-1) + " " + randDifferentiableExpression(randGen, depth-1) + " fma"
+	default:
+		return lhs + " " + randDifferentiableExpression(randGen, depth-1) + " " + op
+	}
+}
+
+func TestDerive_finiteDifferences(t *testing.T) {
+	randGen := rand.New(rand.NewSource(0))
+	vars := &pointResolver{}
+	checked := 0
+	for i := 0; i < 1000; i++ {
+		expression := randDifferentiableExpression(randGen, 4)
+		expr, err := Parse(expression, vars)
+		require.NoError(t, err)
+		derivativeString, err := Derive(expr, "x")
+		require.NoError(t, err, expression)
+		derivative, err := Parse(derivativeString, vars)
+		require.NoError(t, err, derivativeString)
+
+		for j := 0; j < 5; j++ {
+			x := randGen.Float64()*4 - 2
+			vars.Y = randGen.Float64()*4 - 2
+			at := func(x float64) float64 {
+				vars.X = x
+				return expr.Eval()
+			}
+			// central differences with two steps: if they disagree, then
+			// the function is not smooth enough around the point
+			// (a discontinuity of "if", a pole or a rounding error).
+			h := 1e-4 * math.Max(1, math.Abs(x))
+			d1 := (at(x+h) - at(x-h)) / (2 * h)
+			d2 := (at(x+h/2) - at(x-h/2)) / h
+			if math.IsNaN(d1) || math.IsInf(d1, 0) || math.IsNaN(d2) || math.IsInf(d2, 0) ||
+				math.Abs(d1-d2) > 1e-4*math.Max(1, math.Abs(d1)) || math.Abs(d1) > 1e6 {
+				continue
+			}
+			vars.X = x
+			actual := derivative.Eval()
+			if math.IsNaN(actual) && d1 == 0 && d2 == 0 {
+				// the function is constant around the point, but the
+				// derivative formula has "0 -0.5 ^" (like for "0 x if 0.5 ^").
+				continue
+			}
+			require.InDelta(t, d2, actual, 1e-3*math.Max(1, math.Abs(d2)), "'%s' -> '%s' at x=%v y=%v", expression, derivativeString, x, vars.Y)
+			checked++
+		}
+	}
+	require.Greater(t, checked, 2000)
+}
@@ -3,14 +3,15 @@ package rpn
 import (
 	"fmt"
 	"math"
-	"strings"
 
 	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
 	"github.com/xaionaro-go/rpn/types"
 )
 
 var (
-	_ types.Expr = &Expr{}
+	_ types.Expr              = &Expr{}
+	_ types.SpecializableExpr = &Expr{}
 )
 
 // Expr is an implementation of types.Expr which works are a tree of
@@ -20,10 +21,25 @@ type Expr struct {
 	internal.ParsedValue
 	LHS           *Expr
 	RHS           *Expr
+	Addend        *Expr
 	Symbol        string
 	ResultCache   types.NullFloat64
 	IsUpdateCache bool
 	Op            types.Op
+
+	// SumTree is the tree of ordinary additions and subtractions of
+	// a compensated sum (see ir.CompensatedSummation), it is set only
+	// for such sums (their values are loaded by FuncValue). It is used
+	// by EvalInterval.
+	SumTree *Expr
+
+	// Snapshot is the snapshot of the values of the symbols, it is set
+	// only for the root of the tree (see ir.SnapshotSymbols).
+	Snapshot *ir.Snapshot
+
+	// IR is the parsed expression the tree is built from, it is set
+	// only for the root of the tree (see Specialize).
+	IR *ir.Expr
 }
 
 // Eval implements types.Expr
@@ -31,6 +47,9 @@ func (expr *Expr) Eval() float64 {
 	if expr.ResultCache.Valid {
 		return expr.ResultCache.Float64
 	}
+	if expr.Snapshot != nil {
+		expr.Snapshot.Load()
+	}
 	var r float64
 	if expr.Op == types.OpFetch {
 		if expr.ConstValue.Valid {
@@ -56,6 +75,8 @@ func (expr *Expr) Eval() float64 {
 			if lhs > 0 {
 				r = rhs
 			}
+		case types.OpFMA:
+			r = math.FMA(lhs, rhs, expr.Addend.Eval())
 		default:
 		}
 	}
@@ -68,69 +89,54 @@ func (expr *Expr) Eval() float64 {
 	return r
 }
 
-type stack []*Expr
-
-func (s *stack) Push(expr Expr) *Expr {
-	*s = append(*s, &expr)
-	return s.First()
-}
-
-func (s *stack) First() *Expr {
-	return (*s)[len(*s)-1]
-}
-
-func (s *stack) Pop() *Expr {
-	r := s.First()
-	*s = (*s)[:len(*s)-1]
-	return r
-}
-
 // Parse converts Reverse Polish Notation expression "expression" to
 // a Eval()-uatable implementation Expr.
 //
 // input example: "z x y + *"
 // calculation interpretation: z * (x + y)
 // tree: *(z,+(x,y))
-func Parse(expression string, symResolver types.SymbolResolver) (*Expr, error) {
+func Parse(expression string, symResolver types.SymbolResolver, opts ...ir.Option) (*Expr, error) {
+	irExpr, err := ir.Parse(expression, symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return FromIR(irExpr)
+}
 
-	parts := strings.Split(expression, " ")
-	stack := stack{}
-	for partIdx, part := range parts {
-		if part == "" {
-			continue
-		}
-		op := types.ParseOp(part)
+// FromIR builds an Expr from an already parsed expression.
+func FromIR(irExpr *ir.Expr) (*Expr, error) {
+	expr := fromNode(irExpr.Root)
+	expr.Snapshot = irExpr.Snapshot
+	expr.IR = irExpr
+	return expr, nil
+}
 
-		if op != types.OpUndefined {
-			if len(stack) < 2 {
-				return nil, fmt.Errorf("invalid expression '%s' at part index %d: expected at least two entries in the stack", expression, partIdx)
-			}
-			rhs := stack.Pop()
-			lhs := stack.Pop()
-			stack.Push(Expr{
-				Symbol: part,
-				LHS:    lhs,
-				RHS:    rhs,
-				Op:     op,
-			})
-			continue
+func fromNode(node *ir.Node) *Expr {
+	if node.Op == types.OpFetch {
+		expr := &Expr{
+			Symbol: node.Token,
+			ParsedValue: internal.ParsedValue{
+				ConstValue: node.ConstValue,
+				FuncValue:  node.FuncValue,
+				IsSymbol:   node.IsSymbol,
+			},
+			Op: types.OpFetch,
 		}
-
-		parsedValue, err := internal.ParseValue(part, symResolver)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse value '%s': %w", part, err)
+		if node.Sum != nil {
+			expr.SumTree = fromNode((&ir.Expr{Root: node}).ExpandSums().Root)
 		}
-
-		stack.Push(Expr{
-			Symbol:      part,
-			ParsedValue: parsedValue,
-			Op:          types.OpFetch,
-		})
+		return expr
+	}
+	expr := &Expr{
+		Symbol: node.Token,
+		LHS:    fromNode(node.LHS),
+		RHS:    fromNode(node.RHS),
+		Op:     node.Op,
 	}
-	if len(stack) == 0 {
-		return nil, fmt.Errorf("empty expression: '%s'", expression)
+	if node.Addend != nil {
+		expr.Addend = fromNode(node.Addend)
 	}
-	return stack[0], nil
+	return expr
 }
 
 // String implements types.Expr
@@ -140,6 +146,8 @@ func (expr Expr) String() string {
 		return expr.Symbol
 	case types.OpIf:
 		return fmt.Sprintf("(if %s>0 then %s)", expr.LHS, expr.RHS)
+	case types.OpFMA:
+		return fmt.Sprintf("fma(%s, %s, %s)", expr.LHS, expr.RHS, expr.Addend)
 	default:
 		return fmt.Sprintf("(%s %s %s)", expr.LHS, expr.Op.String(), expr.RHS)
 	}
@@ -153,6 +161,7 @@ func (expr *Expr) ClearCache() {
 	expr.ResultCache.Valid = false
 	expr.LHS.ClearCache()
 	expr.RHS.ClearCache()
+	expr.Addend.ClearCache()
 }
 
 // EnableUpdateCache defines if the cache should be set (when it is absent).
@@ -163,6 +172,7 @@ func (expr *Expr) EnableUpdateCache(newValue bool) {
 	expr.IsUpdateCache = newValue
 	expr.LHS.EnableUpdateCache(newValue)
 	expr.RHS.EnableUpdateCache(newValue)
+	expr.Addend.EnableUpdateCache(newValue)
 }
 
 // EnableMemoization implements types.Expr
@@ -174,3 +184,13 @@ func (expr *Expr) EnableMemoization(newValue bool) (oldValue bool) {
 	}
 	return
 }
+
+// Specialize implements types.SpecializableExpr. It should be called for
+// the root of the tree only.
+func (expr *Expr) Specialize(values map[string]float64) (types.Expr, error) {
+	specialized, err := FromIR(expr.IR.Specialize(values))
+	if err != nil {
+		return nil, err
+	}
+	return specialized, nil
+}
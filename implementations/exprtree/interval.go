@@ -0,0 +1,62 @@
+package rpn
+
+import (
+	"math"
+
+	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// EvalInterval returns bounds [lo, hi] of the values of the expression
+// for all values of the symbols from the given ranges ("ranges[sym][0]"
+// is the lower bound of the symbol and "ranges[sym][1]" is the upper
+// bound). It uses interval arithmetic with outward rounding, so the value
+// of the expression is guaranteed to be within the bounds, but the bounds
+// may be wider than the actual range of the expression (for example, if
+// a symbol is used more than once, like in "x x -").
+//
+// The symbols absent in `ranges` are taken with their current values.
+// If the condition of "if" could be both positive and not, then both
+// branches are taken into account. If the expression could be NaN (for
+// example, if a divisor could be zero), then [-Inf, +Inf] is returned.
+func (expr *Expr) EvalInterval(ranges map[string][2]float64) (lo, hi float64) {
+	if expr.Snapshot != nil {
+		expr.Snapshot.Load()
+	}
+	r := expr.evalInterval(ranges)
+	if r.MaybeNaN {
+		return math.Inf(-1), math.Inf(1)
+	}
+	return r.Lo, r.Hi
+}
+
+func (expr *Expr) evalInterval(ranges map[string][2]float64) internal.Interval {
+	switch expr.Op {
+	case types.OpFetch:
+		return expr.evalIntervalValue(ranges)
+	case types.OpFMA:
+		return internal.EvalIntervalFMA(
+			expr.LHS.evalInterval(ranges),
+			expr.RHS.evalInterval(ranges),
+			expr.Addend.evalInterval(ranges),
+		)
+	default:
+		return internal.EvalInterval(expr.Op, expr.LHS.evalInterval(ranges), expr.RHS.evalInterval(ranges))
+	}
+}
+
+func (expr *Expr) evalIntervalValue(ranges map[string][2]float64) internal.Interval {
+	if expr.SumTree != nil {
+		// a compensated sum is bounded by its terms
+		return expr.SumTree.evalInterval(ranges)
+	}
+	if expr.IsSymbol {
+		if r, ok := ranges[expr.Symbol]; ok {
+			return internal.Interval{Lo: r[0], Hi: r[1]}
+		}
+	}
+	if expr.ConstValue.Valid {
+		return internal.PointInterval(expr.ConstValue.Float64)
+	}
+	return internal.PointInterval(expr.FuncValue())
+}
@@ -0,0 +1,118 @@
+package rpn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+func TestExpr_EvalInterval(t *testing.T) {
+	inf := math.Inf(1)
+	ranges := map[string][2]float64{
+		"x": {-1, 2},
+		"y": {3, 4},
+	}
+	for expression, expected := range map[string][2]float64{
+		"x":          {-1, 2},
+		"c":          {3, 3},
+		"x y +":      {2, 6},
+		"x y -":      {-5, -1},
+		"x y *":      {-4, 8},
+		"x x *":      {-2, 4},
+		"x 2 ^":      {0, 4},
+		"x 3 ^":      {-1, 8},
+		"y 0.5 ^":    {math.Sqrt(3), 2},
+		"y -1 ^":     {0.25, 1 / 3.},
+		"x y /":      {-1 / 3., 2 / 3.},
+		"y x /":      {-inf, inf},
+		"x 0.5 ^":    {-inf, inf},
+		"x y if":     {0, 4},
+		"y x if":     {-1, 2},
+		"x y - y if": {0, 0},
+		"x y 1 fma":  {-3, 9},
+		"2 y 1 + ^":  {16, 32},
+		"x x -":      {-3, 3},
+	} {
+		expr, err := Parse(expression, &pointResolver{})
+		require.NoError(t, err, expression)
+		lo, hi := expr.EvalInterval(ranges)
+		require.LessOrEqual(t, lo, expected[0], expression)
+		require.GreaterOrEqual(t, hi, expected[1], expression)
+		if !math.IsInf(lo, 0) {
+			require.InDelta(t, expected[0], lo, 1e-12, expression)
+		}
+		if !math.IsInf(hi, 0) {
+			require.InDelta(t, expected[1], hi, 1e-12, expression)
+		}
+	}
+
+	t.Run("unbound_symbol", func(t *testing.T) {
+		expr, err := Parse("x y *", &pointResolver{Y: 2})
+		require.NoError(t, err)
+		lo, hi := expr.EvalInterval(map[string][2]float64{"x": {1, 3}})
+		require.InDelta(t, 2, lo, 1e-12)
+		require.InDelta(t, 6, hi, 1e-12)
+	})
+
+	t.Run("zero_times_infinity", func(t *testing.T) {
+		for _, expression := range []string{"x 0 *", "0 x *", "x y *", "x y 1 fma"} {
+			expr, err := Parse(expression, &pointResolver{})
+			require.NoError(t, err)
+			lo, hi := expr.EvalInterval(map[string][2]float64{"x": {1, inf}, "y": {-1, 0}})
+			require.True(t, math.IsInf(lo, -1) && math.IsInf(hi, 1), "%s: [%g, %g]", expression, lo, hi)
+		}
+	})
+
+	t.Run("compensated_summation", func(t *testing.T) {
+		expr, err := Parse("x y + c +", &pointResolver{Y: 2}, ir.CompensatedSummation)
+		require.NoError(t, err)
+		lo, hi := expr.EvalInterval(map[string][2]float64{"x": {0, 10}})
+		require.Equal(t, float64(5), lo)
+		require.Equal(t, float64(15), hi)
+	})
+
+	t.Run("sub_tree", func(t *testing.T) {
+		expr, err := Parse("x y * 1 +", &pointResolver{}, ir.CompensatedSummation)
+		require.NoError(t, err)
+		lo, hi := expr.LHS.EvalInterval(ranges)
+		require.Equal(t, float64(-4), lo)
+		require.Equal(t, float64(8), hi)
+	})
+}
+
+func TestExpr_EvalInterval_random(t *testing.T) {
+	randGen := rand.New(rand.NewSource(0))
+	vars := &pointResolver{}
+	randRange := func() [2]float64 {
+		a, b := randGen.Float64()*6-3, randGen.Float64()*6-3
+		return [2]float64{math.Min(a, b), math.Max(a, b)}
+	}
+	for i := 0; i < 1000; i++ {
+		expression := randDifferentiableExpression(randGen, 4)
+		expr, err := Parse(expression, vars)
+		require.NoError(t, err)
+		ranges := map[string][2]float64{
+			"x": randRange(),
+			"y": randRange(),
+		}
+		lo, hi := expr.EvalInterval(ranges)
+		require.LessOrEqual(t, lo, hi, expression)
+
+		for j := 0; j < 100; j++ {
+			vars.X = ranges["x"][0] + randGen.Float64()*(ranges["x"][1]-ranges["x"][0])
+			vars.Y = ranges["y"][0] + randGen.Float64()*(ranges["y"][1]-ranges["y"][0])
+			if j == 0 {
+				vars.X, vars.Y = ranges["x"][0], ranges["y"][1]
+			}
+			v := expr.Eval()
+			if math.IsNaN(v) {
+				require.True(t, math.IsInf(lo, -1) && math.IsInf(hi, 1), "'%s' at x=%v y=%v: [%v, %v]", expression, vars.X, vars.Y, lo, hi)
+				continue
+			}
+			require.True(t, lo <= v && v <= hi, "'%s' at x=%v y=%v: %v is not in [%v, %v]", expression, vars.X, vars.Y, v, lo, hi)
+		}
+	}
+}
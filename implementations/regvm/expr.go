@@ -0,0 +1,476 @@
+package rpn
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+var (
+	_ types.Expr              = &Expr{}
+	_ types.SpecializableExpr = &Expr{}
+)
+
+// Expr is an implementation of types.Expr which compiles the expression
+// to a three-address register bytecode and evaluates it in a single
+// dispatch loop (in comparison to the "tokenslice" implementation it
+// does not push/pop values to a stack and does not call Op.Eval).
+type Expr struct {
+	Description          string
+	Syms                 []Symbol
+	Consts               []float64
+	ResultCache          types.NullFloat64
+	IsMemoizationEnabled bool
+	Snapshot             *ir.Snapshot
+	code                 []instruction
+	registers            []float64
+
+	// IR is the parsed expression the Expr is built from (see Specialize).
+	IR *ir.Expr
+}
+
+// Symbol provides information how to extract the value and what name
+// the symbol (of the expression) has. Symbol -- is anything except for
+// operations signs.
+type Symbol struct {
+	internal.ParsedValue
+	Name string
+}
+
+type opcode uint8
+
+// The opcode of a binary operation consists of the operation and of
+// the kinds of the operands: R -- a register, K -- a constant,
+// L -- a symbol to be loaded.
+const (
+	opcodePlusRR = opcode(iota)
+	opcodePlusRK
+	opcodePlusKR
+	opcodePlusRL
+	opcodePlusLR
+	opcodePlusLL
+	opcodePlusLK
+	opcodePlusKL
+	opcodeMinusRR
+	opcodeMinusRK
+	opcodeMinusKR
+	opcodeMinusRL
+	opcodeMinusLR
+	opcodeMinusLL
+	opcodeMinusLK
+	opcodeMinusKL
+	opcodeMultiplyRR
+	opcodeMultiplyRK
+	opcodeMultiplyKR
+	opcodeMultiplyRL
+	opcodeMultiplyLR
+	opcodeMultiplyLL
+	opcodeMultiplyLK
+	opcodeMultiplyKL
+	opcodeDivideRR
+	opcodeDivideRK
+	opcodeDivideKR
+	opcodeDivideRL
+	opcodeDivideLR
+	opcodeDivideLL
+	opcodeDivideLK
+	opcodeDivideKL
+	opcodePowerRR
+	opcodePowerRK
+	opcodePowerKR
+	opcodePowerRL
+	opcodePowerLR
+	opcodePowerLL
+	opcodePowerLK
+	opcodePowerKL
+	opcodeIfRR
+	opcodeIfRK
+	opcodeIfKR
+	opcodeIfRL
+	opcodeIfLR
+	opcodeIfLL
+	opcodeIfLK
+	opcodeIfKL
+	opcodeLoadConst
+	opcodeLoadSym
+
+	// opcodeFMA is "registers[Dst] = FMA(registers[Dst],
+	// registers[Dst+1], registers[Dst+2])", the operands are loaded to
+	// the registers beforehand.
+	opcodeFMA
+)
+
+const operandKindCombinations = opcodeMinusRR - opcodePlusRR
+
+type operandKind uint8
+
+const (
+	operandKindRegister = operandKind(iota)
+	operandKindConst
+	operandKindSym
+)
+
+// opcodeFor returns the opcode of binary operation `op`
+// with operands of kinds `lhs` and `rhs`.
+func opcodeFor(op types.Op, lhs, rhs operandKind) opcode {
+	var combination opcode
+	switch {
+	case lhs == operandKindRegister && rhs == operandKindRegister:
+		combination = opcodePlusRR
+	case lhs == operandKindRegister && rhs == operandKindConst:
+		combination = opcodePlusRK
+	case lhs == operandKindConst && rhs == operandKindRegister:
+		combination = opcodePlusKR
+	case lhs == operandKindRegister && rhs == operandKindSym:
+		combination = opcodePlusRL
+	case lhs == operandKindSym && rhs == operandKindRegister:
+		combination = opcodePlusLR
+	case lhs == operandKindSym && rhs == operandKindSym:
+		combination = opcodePlusLL
+	case lhs == operandKindSym && rhs == operandKindConst:
+		combination = opcodePlusLK
+	case lhs == operandKindConst && rhs == operandKindSym:
+		combination = opcodePlusKL
+	default:
+		panic(fmt.Sprintf("unexpected combination of operands: %d, %d", lhs, rhs))
+	}
+	return opcode(op-types.OpPlus)*operandKindCombinations + combination
+}
+
+// instruction is a single three-address instruction:
+//
+//	registers[Dst] = <LHS> <op> <RHS>
+//
+// where LHS and RHS are indexes of registers, constants or symbols
+// (depending on the opcode).
+type instruction struct {
+	Opcode opcode
+	Dst    uint32
+	LHS    uint32
+	RHS    uint32
+}
+
+// Eval implements types.Expr
+func (expr *Expr) Eval() float64 {
+	if !expr.IsMemoizationEnabled {
+		return expr.eval()
+	}
+
+	if expr.ResultCache.Valid {
+		return expr.ResultCache.Float64
+	}
+
+	r := expr.eval()
+	expr.ResultCache.Float64 = r
+	expr.ResultCache.Valid = true
+
+	return r
+}
+
+func (expr *Expr) eval() float64 {
+	if expr.Snapshot != nil {
+		expr.Snapshot.Load()
+	}
+	r := expr.registers
+	k := expr.Consts
+	syms := expr.Syms
+	for _, in := range expr.code {
+		switch in.Opcode {
+		case opcodePlusRR:
+			r[in.Dst] = r[in.LHS] + r[in.RHS]
+		case opcodePlusRK:
+			r[in.Dst] = r[in.LHS] + k[in.RHS]
+		case opcodePlusKR:
+			r[in.Dst] = k[in.LHS] + r[in.RHS]
+		case opcodePlusRL:
+			r[in.Dst] = r[in.LHS] + syms[in.RHS].FuncValue()
+		case opcodePlusLR:
+			r[in.Dst] = syms[in.LHS].FuncValue() + r[in.RHS]
+		case opcodePlusLL:
+			r[in.Dst] = syms[in.LHS].FuncValue() + syms[in.RHS].FuncValue()
+		case opcodePlusLK:
+			r[in.Dst] = syms[in.LHS].FuncValue() + k[in.RHS]
+		case opcodePlusKL:
+			r[in.Dst] = k[in.LHS] + syms[in.RHS].FuncValue()
+		case opcodeMinusRR:
+			r[in.Dst] = r[in.LHS] - r[in.RHS]
+		case opcodeMinusRK:
+			r[in.Dst] = r[in.LHS] - k[in.RHS]
+		case opcodeMinusKR:
+			r[in.Dst] = k[in.LHS] - r[in.RHS]
+		case opcodeMinusRL:
+			r[in.Dst] = r[in.LHS] - syms[in.RHS].FuncValue()
+		case opcodeMinusLR:
+			r[in.Dst] = syms[in.LHS].FuncValue() - r[in.RHS]
+		case opcodeMinusLL:
+			r[in.Dst] = syms[in.LHS].FuncValue() - syms[in.RHS].FuncValue()
+		case opcodeMinusLK:
+			r[in.Dst] = syms[in.LHS].FuncValue() - k[in.RHS]
+		case opcodeMinusKL:
+			r[in.Dst] = k[in.LHS] - syms[in.RHS].FuncValue()
+		case opcodeMultiplyRR:
+			r[in.Dst] = r[in.LHS] * r[in.RHS]
+		case opcodeMultiplyRK:
+			r[in.Dst] = r[in.LHS] * k[in.RHS]
+		case opcodeMultiplyKR:
+			r[in.Dst] = k[in.LHS] * r[in.RHS]
+		case opcodeMultiplyRL:
+			r[in.Dst] = r[in.LHS] * syms[in.RHS].FuncValue()
+		case opcodeMultiplyLR:
+			r[in.Dst] = syms[in.LHS].FuncValue() * r[in.RHS]
+		case opcodeMultiplyLL:
+			r[in.Dst] = syms[in.LHS].FuncValue() * syms[in.RHS].FuncValue()
+		case opcodeMultiplyLK:
+			r[in.Dst] = syms[in.LHS].FuncValue() * k[in.RHS]
+		case opcodeMultiplyKL:
+			r[in.Dst] = k[in.LHS] * syms[in.RHS].FuncValue()
+		case opcodeDivideRR:
+			r[in.Dst] = r[in.LHS] / r[in.RHS]
+		case opcodeDivideRK:
+			r[in.Dst] = r[in.LHS] / k[in.RHS]
+		case opcodeDivideKR:
+			r[in.Dst] = k[in.LHS] / r[in.RHS]
+		case opcodeDivideRL:
+			r[in.Dst] = r[in.LHS] / syms[in.RHS].FuncValue()
+		case opcodeDivideLR:
+			r[in.Dst] = syms[in.LHS].FuncValue() / r[in.RHS]
+		case opcodeDivideLL:
+			r[in.Dst] = syms[in.LHS].FuncValue() / syms[in.RHS].FuncValue()
+		case opcodeDivideLK:
+			r[in.Dst] = syms[in.LHS].FuncValue() / k[in.RHS]
+		case opcodeDivideKL:
+			r[in.Dst] = k[in.LHS] / syms[in.RHS].FuncValue()
+		case opcodePowerRR:
+			r[in.Dst] = math.Pow(r[in.LHS], r[in.RHS])
+		case opcodePowerRK:
+			r[in.Dst] = math.Pow(r[in.LHS], k[in.RHS])
+		case opcodePowerKR:
+			r[in.Dst] = math.Pow(k[in.LHS], r[in.RHS])
+		case opcodePowerRL:
+			r[in.Dst] = math.Pow(r[in.LHS], syms[in.RHS].FuncValue())
+		case opcodePowerLR:
+			r[in.Dst] = math.Pow(syms[in.LHS].FuncValue(), r[in.RHS])
+		case opcodePowerLL:
+			r[in.Dst] = math.Pow(syms[in.LHS].FuncValue(), syms[in.RHS].FuncValue())
+		case opcodePowerLK:
+			r[in.Dst] = math.Pow(syms[in.LHS].FuncValue(), k[in.RHS])
+		case opcodePowerKL:
+			r[in.Dst] = math.Pow(k[in.LHS], syms[in.RHS].FuncValue())
+		case opcodeIfRR:
+			if r[in.LHS] > 0 {
+				r[in.Dst] = r[in.RHS]
+			} else {
+				r[in.Dst] = 0
+			}
+		case opcodeIfRK:
+			if r[in.LHS] > 0 {
+				r[in.Dst] = k[in.RHS]
+			} else {
+				r[in.Dst] = 0
+			}
+		case opcodeIfKR:
+			if k[in.LHS] > 0 {
+				r[in.Dst] = r[in.RHS]
+			} else {
+				r[in.Dst] = 0
+			}
+		case opcodeIfRL:
+			if r[in.LHS] > 0 {
+				r[in.Dst] = syms[in.RHS].FuncValue()
+			} else {
+				r[in.Dst] = 0
+			}
+		case opcodeIfLR:
+			if syms[in.LHS].FuncValue() > 0 {
+				r[in.Dst] = r[in.RHS]
+			} else {
+				r[in.Dst] = 0
+			}
+		case opcodeIfLL:
+			if syms[in.LHS].FuncValue() > 0 {
+				r[in.Dst] = syms[in.RHS].FuncValue()
+			} else {
+				r[in.Dst] = 0
+			}
+		case opcodeIfLK:
+			if syms[in.LHS].FuncValue() > 0 {
+				r[in.Dst] = k[in.RHS]
+			} else {
+				r[in.Dst] = 0
+			}
+		case opcodeIfKL:
+			if k[in.LHS] > 0 {
+				r[in.Dst] = syms[in.RHS].FuncValue()
+			} else {
+				r[in.Dst] = 0
+			}
+		case opcodeLoadConst:
+			r[in.Dst] = k[in.LHS]
+		case opcodeLoadSym:
+			r[in.Dst] = syms[in.LHS].FuncValue()
+		case opcodeFMA:
+			r[in.Dst] = math.FMA(r[in.Dst], r[in.Dst+1], r[in.Dst+2])
+		}
+	}
+	return r[0]
+}
+
+type operand struct {
+	Kind  operandKind
+	Index uint32
+}
+
+// Parse converts Reverse Polish Notation expression "expression" to
+// a Eval()-uatable implementation Expr.
+//
+// input example: "z x y + *"
+// calculation interpretation: z * (x + y)
+func Parse(expression string, symResolver types.SymbolResolver, opts ...ir.Option) (*Expr, error) {
+	irExpr, err := ir.Parse(expression, symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return FromIR(irExpr)
+}
+
+// FromIR builds an Expr from an already parsed expression.
+func FromIR(irExpr *ir.Expr) (*Expr, error) {
+	expr := &Expr{
+		Description: irExpr.Source,
+		Snapshot:    irExpr.Snapshot,
+		IR:          irExpr,
+	}
+	operands := make([]operand, 0, 2)
+	registersCount := 1
+	irExpr.Root.Walk(func(node *ir.Node) {
+		op := node.Op
+		if op == types.OpFetch {
+			if node.ConstValue.Valid {
+				operands = append(operands, expr.constOperand(node.ConstValue.Float64))
+				return
+			}
+			operands = append(operands, operand{Kind: operandKindSym, Index: uint32(len(expr.Syms))})
+			expr.Syms = append(expr.Syms, Symbol{
+				ParsedValue: internal.ParsedValue{
+					FuncValue: node.FuncValue,
+				},
+				Name: node.Token,
+			})
+			return
+		}
+
+		if op == types.OpFMA {
+			operands = expr.appendFMA(operands, &registersCount)
+			return
+		}
+
+		lhs := operands[len(operands)-2]
+		rhs := operands[len(operands)-1]
+		operands = operands[:len(operands)-2]
+
+		switch {
+		case lhs.Kind == operandKindConst && rhs.Kind == operandKindConst:
+			operands = append(operands, expr.constOperand(op.Eval(expr.Consts[lhs.Index], expr.Consts[rhs.Index])))
+			return
+		case op == types.OpIf && lhs.Kind == operandKindConst && !(expr.Consts[lhs.Index] > 0):
+			operands = append(operands, expr.constOperand(0))
+			return
+		case op == types.OpIf && lhs.Kind == operandKindConst && rhs.Kind != operandKindRegister:
+			// registers are bound to the positions in the stack, so
+			// a register operand cannot be just moved down the stack
+			operands = append(operands, rhs)
+			return
+		}
+
+		// the result is stored to the register of the position in the
+		// stack, so the amount of registers is the depth of the stack
+		dst := uint32(len(operands))
+		if int(dst) >= registersCount {
+			registersCount = int(dst) + 1
+		}
+		expr.code = append(expr.code, instruction{
+			Opcode: opcodeFor(op, lhs.Kind, rhs.Kind),
+			Dst:    dst,
+			LHS:    lhs.Index,
+			RHS:    rhs.Index,
+		})
+		operands = append(operands, operand{Kind: operandKindRegister, Index: dst})
+	})
+
+	// the result should be stored in register 0.
+	switch result := operands[0]; result.Kind {
+	case operandKindConst:
+		expr.code = append(expr.code, instruction{Opcode: opcodeLoadConst, LHS: result.Index})
+	case operandKindSym:
+		expr.code = append(expr.code, instruction{Opcode: opcodeLoadSym, LHS: result.Index})
+	}
+	expr.registers = make([]float64, registersCount)
+	return expr, nil
+}
+
+// appendFMA compiles the fma operation of the last three operands and
+// returns the operands with the result instead of them.
+func (expr *Expr) appendFMA(operands []operand, registersCount *int) []operand {
+	fmaOperands := operands[len(operands)-3:]
+	dst := uint32(len(operands) - 3)
+	operands = operands[:dst]
+
+	isConst := true
+	for _, operand := range fmaOperands {
+		isConst = isConst && operand.Kind == operandKindConst
+	}
+	if isConst {
+		k := expr.Consts
+		return append(operands, expr.constOperand(types.OpFMA.Eval3(
+			k[fmaOperands[0].Index],
+			k[fmaOperands[1].Index],
+			k[fmaOperands[2].Index],
+		)))
+	}
+
+	if int(dst)+3 > *registersCount {
+		*registersCount = int(dst) + 3
+	}
+	// a register operand is already in the register of its position
+	// in the stack, the rest are loaded
+	for idx, operand := range fmaOperands {
+		reg := dst + uint32(idx)
+		switch operand.Kind {
+		case operandKindConst:
+			expr.code = append(expr.code, instruction{Opcode: opcodeLoadConst, Dst: reg, LHS: operand.Index})
+		case operandKindSym:
+			expr.code = append(expr.code, instruction{Opcode: opcodeLoadSym, Dst: reg, LHS: operand.Index})
+		}
+	}
+	expr.code = append(expr.code, instruction{Opcode: opcodeFMA, Dst: dst})
+	return append(operands, operand{Kind: operandKindRegister, Index: dst})
+}
+
+func (expr *Expr) constOperand(v float64) operand {
+	expr.Consts = append(expr.Consts, v)
+	return operand{Kind: operandKindConst, Index: uint32(len(expr.Consts) - 1)}
+}
+
+// String implements types.Expr
+func (expr *Expr) String() string {
+	return expr.Description
+}
+
+// EnableMemoization implements types.Expr
+func (expr *Expr) EnableMemoization(newValue bool) (oldValue bool) {
+	oldValue = expr.IsMemoizationEnabled
+	expr.IsMemoizationEnabled = newValue
+	return
+}
+
+// Specialize implements types.SpecializableExpr
+func (expr *Expr) Specialize(values map[string]float64) (types.Expr, error) {
+	specialized, err := FromIR(expr.IR.Specialize(values))
+	if err != nil {
+		return nil, err
+	}
+	return specialized, nil
+}
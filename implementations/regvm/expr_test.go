@@ -0,0 +1,22 @@
+package rpn_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	rpn "github.com/xaionaro-go/rpn/implementations/regvm"
+	"github.com/xaionaro-go/rpn/tests"
+)
+
+func TestIfWithConstCondition(t *testing.T) {
+	// the result of "x0 x1 +" should not be overwritten by "z x0 +"
+	exprString := "1 x0 x1 + if z x0 + *"
+	expr, err := rpn.Parse(exprString, tests.DummyResolver{T: t})
+	require.NoError(t, err)
+	require.Equal(t, float64(15), expr.Eval(), exprString)
+
+	exprString = "-1 -0.5 ^ x0 if 1 +"
+	expr, err = rpn.Parse(exprString, tests.DummyResolver{T: t})
+	require.NoError(t, err)
+	require.Equal(t, float64(1), expr.Eval(), exprString)
+}
@@ -2,14 +2,16 @@ package rpn
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
 	"github.com/xaionaro-go/rpn/types"
 )
 
 var (
-	_ types.Expr = &Expr{}
+	_ types.Expr              = &Expr{}
+	_ types.GradExpr          = &Expr{}
+	_ types.SpecializableExpr = &Expr{}
 )
 
 func init() {
@@ -25,7 +27,14 @@ type Expr struct {
 	Syms                 []Symbol
 	ResultCache          types.NullFloat64
 	IsMemoizationEnabled bool
+	Snapshot             *ir.Snapshot
 	evalStack            []float64
+
+	// gradEvaluator is built on the first EvalGrad.
+	gradEvaluator *gradEvaluator
+
+	// IR is the parsed expression the Expr is built from (see Specialize).
+	IR *ir.Expr
 }
 
 // Symbol provides information how to extract the value and what name
@@ -56,6 +65,9 @@ func (expr *Expr) Eval() float64 {
 }
 
 func (expr *Expr) eval() float64 {
+	if expr.Snapshot != nil {
+		expr.Snapshot.Load()
+	}
 	symIdx := 0
 	stackLen := 0
 	syms := expr.Syms
@@ -69,12 +81,18 @@ func (expr *Expr) eval() float64 {
 			continue
 		}
 
-		stackLen--
-		rhs := stack[stackLen]
-		stackLen--
-		lhs := stack[stackLen]
-
-		r := op.Eval(lhs, rhs)
+		var r float64
+		if op == types.OpFMA {
+			stackLen -= 3
+			r = op.Eval3(stack[stackLen], stack[stackLen+1], stack[stackLen+2])
+		} else {
+			stackLen--
+			rhs := stack[stackLen]
+			stackLen--
+			lhs := stack[stackLen]
+
+			r = op.Eval(lhs, rhs)
+		}
 
 		if symIdx < 0 {
 			return r
@@ -95,37 +113,32 @@ func (expr *Expr) eval() float64 {
 //
 // input example: "z x y + *"
 // calculation interpretation: z * (x + y)
-func Parse(expression string, symResolver types.SymbolResolver) (*Expr, error) {
-	expr := &Expr{}
-	parts := strings.Split(expression, " ")
-	for partIdx, part := range parts {
-		if part == "" {
-			continue
-		}
-		op := types.ParseOp(part)
-
-		if op == types.OpUndefined {
-			parsedValue, err := internal.ParseValue(part, symResolver)
-			if err != nil {
-				return nil, fmt.Errorf("unable to parse value '%s': %w", part, err)
-			}
-
-			sym := Symbol{
-				Name:        part,
-				ParsedValue: parsedValue,
-			}
-			expr.Syms = append(expr.Syms, sym)
-			expr.Ops = append(expr.Ops, types.OpFetch)
-			continue
-		}
-
-		unusedSymsCount := len(expr.Syms)*2 - len(expr.Ops)
-		if unusedSymsCount < 2 {
-			return nil, fmt.Errorf("expected at least 2 values in stack, but found only %d (partIdx: %d; expression: '%s')", unusedSymsCount, partIdx, expression)
-		}
+func Parse(expression string, symResolver types.SymbolResolver, opts ...ir.Option) (*Expr, error) {
+	irExpr, err := ir.Parse(expression, symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return FromIR(irExpr)
+}
 
-		expr.Ops = append(expr.Ops, op)
+// FromIR builds an Expr from an already parsed expression.
+func FromIR(irExpr *ir.Expr) (*Expr, error) {
+	expr := &Expr{
+		Snapshot: irExpr.Snapshot,
+		IR:       irExpr,
 	}
+	irExpr.Root.Walk(func(node *ir.Node) {
+		if node.Op == types.OpFetch {
+			expr.Syms = append(expr.Syms, Symbol{
+				Name: node.Token,
+				ParsedValue: internal.ParsedValue{
+					ConstValue: node.ConstValue,
+					FuncValue:  node.FuncValue,
+				},
+			})
+		}
+		expr.Ops = append(expr.Ops, node.Op)
+	})
 	expr.evalStack = make([]float64, len(expr.Syms))
 	return expr, nil
 }
@@ -141,3 +154,12 @@ func (expr *Expr) EnableMemoization(newValue bool) (oldValue bool) {
 	expr.IsMemoizationEnabled = newValue
 	return
 }
+
+// Specialize implements types.SpecializableExpr
+func (expr *Expr) Specialize(values map[string]float64) (types.Expr, error) {
+	specialized, err := FromIR(expr.IR.Specialize(values))
+	if err != nil {
+		return nil, err
+	}
+	return specialized, nil
+}
@@ -0,0 +1,141 @@
+package rpn
+
+import (
+	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// gradEvaluator evaluates the expression on a stack of dual numbers.
+type gradEvaluator struct {
+	Ops      []types.Op
+	Syms     []Symbol
+	Snapshot *ir.Snapshot
+
+	// Symbols are the names of the symbols to differentiate by.
+	Symbols []string
+
+	// Uncertainty propagates the standard deviations of Symbols (see
+	// ir.Expr.StdDevFuncs).
+	Uncertainty *internal.Uncertainty
+
+	// symbolIndices are the indices in Symbols of Syms (-1 for
+	// constants).
+	symbolIndices []int
+
+	stack     []float64
+	gradStack [][]float64
+}
+
+// EvalGrad implements types.GradExpr
+func (expr *Expr) EvalGrad() (value float64, grad map[string]float64) {
+	return expr.getGradEvaluator().Eval()
+}
+
+// EvalUncertainty implements types.GradExpr
+func (expr *Expr) EvalUncertainty(correlations map[[2]string]float64) (value, stdDev float64) {
+	return expr.getGradEvaluator().EvalUncertainty(correlations)
+}
+
+func (expr *Expr) getGradEvaluator() *gradEvaluator {
+	if expr.gradEvaluator == nil {
+		expr.gradEvaluator = newGradEvaluator(expr.IR.ExpandSums())
+	}
+	return expr.gradEvaluator
+}
+
+func newGradEvaluator(irExpr *ir.Expr) *gradEvaluator {
+	evaluator := &gradEvaluator{
+		Snapshot: irExpr.Snapshot,
+		Symbols:  irExpr.Symbols(),
+	}
+	evaluator.Uncertainty = internal.NewUncertainty(evaluator.Symbols, irExpr.StdDevFuncs(evaluator.Symbols))
+	symbolIndices := make(map[string]int, len(evaluator.Symbols))
+	for idx, name := range evaluator.Symbols {
+		symbolIndices[name] = idx
+	}
+
+	depth, maxDepth := 0, 0
+	irExpr.Root.Walk(func(node *ir.Node) {
+		evaluator.Ops = append(evaluator.Ops, node.Op)
+		if node.Op != types.OpFetch {
+			depth -= node.Op.Arity() - 1
+			return
+		}
+		evaluator.Syms = append(evaluator.Syms, Symbol{
+			Name: node.Token,
+			ParsedValue: internal.ParsedValue{
+				ConstValue: node.ConstValue,
+				FuncValue:  node.FuncValue,
+				IsSymbol:   node.IsSymbol,
+			},
+		})
+		symbolIdx := -1
+		if node.IsSymbol {
+			symbolIdx = symbolIndices[node.Token]
+		}
+		evaluator.symbolIndices = append(evaluator.symbolIndices, symbolIdx)
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	})
+
+	evaluator.stack = make([]float64, maxDepth)
+	evaluator.gradStack = make([][]float64, maxDepth)
+	for idx := range evaluator.gradStack {
+		evaluator.gradStack[idx] = make([]float64, len(evaluator.Symbols))
+	}
+	return evaluator
+}
+
+// Eval evaluates the value and the gradient.
+func (evaluator *gradEvaluator) Eval() (value float64, grad map[string]float64) {
+	value, gradSlice := evaluator.evalDual()
+	grad = make(map[string]float64, len(evaluator.Symbols))
+	for idx, name := range evaluator.Symbols {
+		grad[name] = gradSlice[idx]
+	}
+	return
+}
+
+// EvalUncertainty evaluates the value and its standard deviation.
+func (evaluator *gradEvaluator) EvalUncertainty(correlations map[[2]string]float64) (value, stdDev float64) {
+	value, grad := evaluator.evalDual()
+	return value, evaluator.Uncertainty.StdDev(grad, correlations)
+}
+
+// evalDual returns the value and the gradient (indexed as Symbols).
+func (evaluator *gradEvaluator) evalDual() (float64, []float64) {
+	if evaluator.Snapshot != nil {
+		evaluator.Snapshot.Load()
+	}
+	stack, gradStack := evaluator.stack, evaluator.gradStack
+	symIdx := 0
+	stackLen := 0
+	for _, op := range evaluator.Ops {
+		switch op {
+		case types.OpFetch:
+			stack[stackLen] = evaluator.Syms[symIdx].Load()
+			g := gradStack[stackLen]
+			for idx := range g {
+				g[idx] = 0
+			}
+			if symbolIdx := evaluator.symbolIndices[symIdx]; symbolIdx >= 0 {
+				g[symbolIdx] = 1
+			}
+			symIdx++
+			stackLen++
+		case types.OpFMA:
+			stackLen -= 2
+			a, b, c := stackLen-1, stackLen, stackLen+1
+			stack[a] = internal.EvalDualFMA(stack[a], gradStack[a], stack[b], gradStack[b], stack[c], gradStack[c], gradStack[a])
+		default:
+			stackLen--
+			lhs, rhs := stackLen-1, stackLen
+			stack[lhs] = internal.EvalDual(op, stack[lhs], gradStack[lhs], stack[rhs], gradStack[rhs], gradStack[lhs])
+		}
+	}
+
+	return stack[0], gradStack[0]
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"math"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// EvalDual evaluates binary operation `op` on dual numbers (a value and
+// its gradient): it returns the value of the result and stores its
+// gradient to `grad`. `grad` may be the same slice as `lhsGrad`
+// or `rhsGrad`.
+func EvalDual(op types.Op, lhs float64, lhsGrad []float64, rhs float64, rhsGrad []float64, grad []float64) float64 {
+	switch op {
+	case types.OpPlus:
+		for idx := range grad {
+			grad[idx] = lhsGrad[idx] + rhsGrad[idx]
+		}
+		return lhs + rhs
+	case types.OpMinus:
+		for idx := range grad {
+			grad[idx] = lhsGrad[idx] - rhsGrad[idx]
+		}
+		return lhs - rhs
+	case types.OpMultiply:
+		for idx := range grad {
+			grad[idx] = lhsGrad[idx]*rhs + lhs*rhsGrad[idx]
+		}
+		return lhs * rhs
+	case types.OpDivide:
+		r := lhs / rhs
+		for idx := range grad {
+			grad[idx] = (lhsGrad[idx] - r*rhsGrad[idx]) / rhs
+		}
+		return r
+	case types.OpPower:
+		r := math.Pow(lhs, rhs)
+		// the terms with zero derivatives are skipped to not get NaN
+		// from "0 * Inf" or from the logarithm of a negative base
+		dBase := rhs * math.Pow(lhs, rhs-1)
+		dExponent := r * math.Log(lhs)
+		for idx := range grad {
+			g := float64(0)
+			if lhsGrad[idx] != 0 {
+				g += dBase * lhsGrad[idx]
+			}
+			if rhsGrad[idx] != 0 {
+				g += dExponent * rhsGrad[idx]
+			}
+			grad[idx] = g
+		}
+		return r
+	case types.OpIf:
+		if lhs > 0 {
+			copy(grad, rhsGrad)
+			return rhs
+		}
+		for idx := range grad {
+			grad[idx] = 0
+		}
+		return 0
+	default:
+		panic("do not know how to evaluate op: " + op.String())
+	}
+}
+
+// EvalDualFMA evaluates "a*b+c" (see types.OpFMA) on dual numbers
+// like EvalDual does. `grad` may be the same slice as any of the
+// gradients of the operands.
+func EvalDualFMA(a float64, aGrad []float64, b float64, bGrad []float64, c float64, cGrad []float64, grad []float64) float64 {
+	for idx := range grad {
+		grad[idx] = math.FMA(aGrad[idx], b, math.FMA(a, bGrad[idx], cGrad[idx]))
+	}
+	return math.FMA(a, b, c)
+}
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"math"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// powRoundingULPs is the amount of ULPs the bounds of "^" are rounded
+// outward by, because math.Pow is not correctly rounded.
+const powRoundingULPs = 4
+
+// Interval is a closed range of float64 values [Lo, Hi], which may also
+// contain NaN.
+type Interval struct {
+	Lo float64
+	Hi float64
+
+	// MaybeNaN is true if the value could also be NaN
+	MaybeNaN bool
+}
+
+// PointInterval returns the interval [v, v].
+func PointInterval(v float64) Interval {
+	if math.IsNaN(v) {
+		return nanInterval
+	}
+	return Interval{Lo: v, Hi: v}
+}
+
+// nanInterval is the result if the value could be NaN and nothing
+// else is known about it.
+var nanInterval = Interval{Lo: math.Inf(-1), Hi: math.Inf(1), MaybeNaN: true}
+
+// Union returns the smallest interval which contains both intervals.
+func (i Interval) Union(other Interval) Interval {
+	return Interval{
+		Lo:       math.Min(i.Lo, other.Lo),
+		Hi:       math.Max(i.Hi, other.Hi),
+		MaybeNaN: i.MaybeNaN || other.MaybeNaN,
+	}
+}
+
+// Contains returns true if the value belongs to the interval.
+func (i Interval) Contains(v float64) bool {
+	return i.Lo <= v && v <= i.Hi
+}
+
+// isUnbounded returns true if any of the bounds is infinite.
+func (i Interval) isUnbounded() bool {
+	return math.IsInf(i.Lo, 0) || math.IsInf(i.Hi, 0)
+}
+
+// roundOutward widens the interval by `ulps` ULPs in each direction.
+func (i Interval) roundOutward(ulps int) Interval {
+	for ; ulps > 0; ulps-- {
+		i.Lo = math.Nextafter(i.Lo, math.Inf(-1))
+		i.Hi = math.Nextafter(i.Hi, math.Inf(1))
+	}
+	return i
+}
+
+// hull returns the smallest interval which contains all the values. If
+// any of the values is NaN, then nanInterval is returned.
+func hull(values ...float64) Interval {
+	r := Interval{Lo: math.Inf(1), Hi: math.Inf(-1)}
+	for _, v := range values {
+		if math.IsNaN(v) {
+			return nanInterval
+		}
+		r.Lo = math.Min(r.Lo, v)
+		r.Hi = math.Max(r.Hi, v)
+	}
+	return r
+}
+
+// roundedOp is an arithmetic operation, which returns its exact result
+// rounded down and rounded up.
+type roundedOp func(a, b float64) (down, up float64)
+
+// corners returns the smallest interval which contains the results of
+// the operation on the pairs of values. If any of the results is NaN,
+// then nanInterval is returned.
+func corners(op roundedOp, pairs ...[2]float64) Interval {
+	r := Interval{Lo: math.Inf(1), Hi: math.Inf(-1)}
+	for _, pair := range pairs {
+		down, up := op(pair[0], pair[1])
+		if math.IsNaN(down) || math.IsNaN(up) {
+			return nanInterval
+		}
+		r.Lo = math.Min(r.Lo, down)
+		r.Hi = math.Max(r.Hi, up)
+	}
+	return r
+}
+
+// directedRounding returns the bounds of the exact value "r + err",
+// where `r` is the rounded result of an operation on `a` and `b`,
+// and `err` is its rounding error.
+func directedRounding(r, err, a, b float64) (down, up float64) {
+	switch {
+	case math.IsInf(r, 0) && !math.IsInf(a, 0) && !math.IsInf(b, 0):
+		// an overflow: the exact value is finite
+		if r > 0 {
+			return math.MaxFloat64, r
+		}
+		return r, -math.MaxFloat64
+	case math.IsInf(r, 0):
+		return r, r
+	case math.IsNaN(err):
+		return math.Nextafter(r, math.Inf(-1)), math.Nextafter(r, math.Inf(1))
+	case err > 0:
+		return r, math.Nextafter(r, math.Inf(1))
+	case err < 0:
+		return math.Nextafter(r, math.Inf(-1)), r
+	default:
+		return r, r
+	}
+}
+
+func addRounded(a, b float64) (down, up float64) {
+	// see the TwoSum algorithm
+	s := a + b
+	bb := s - a
+	err := (a - (s - bb)) + (b - bb)
+	return directedRounding(s, err, a, b)
+}
+
+func subRounded(a, b float64) (down, up float64) {
+	return addRounded(a, -b)
+}
+
+func mulRounded(a, b float64) (down, up float64) {
+	if a == 0 || b == 0 {
+		// the bound is zero also for zero multiplied by an infinity (the
+		// possible NaN is reported by EvalInterval)
+		return 0, 0
+	}
+	p := a * b
+	if math.Abs(p) < minNormalFloat64 {
+		// an underflow: the rounding error may be not representable
+		return math.Nextafter(p, math.Inf(-1)), math.Nextafter(p, math.Inf(1))
+	}
+	return directedRounding(p, math.FMA(a, b, -p), a, b)
+}
+
+func divRounded(a, b float64) (down, up float64) {
+	q := a / b
+	if a != 0 && math.Abs(q) < minNormalFloat64 {
+		// an underflow: the remainder may be not representable
+		return math.Nextafter(q, math.Inf(-1)), math.Nextafter(q, math.Inf(1))
+	}
+	// the exact quotient is "q + rem/b"
+	rem := math.FMA(-q, b, a)
+	if b < 0 {
+		rem = -rem
+	}
+	return directedRounding(q, rem, a, b)
+}
+
+// minNormalFloat64 is the smallest positive normal float64 value.
+const minNormalFloat64 = 0x1p-1022
+
+// EvalInterval evaluates binary operation `op` on intervals: it returns
+// an interval which contains the results of the operation for all values
+// of the operands from `lhs` and `rhs`. The bounds are rounded outward
+// (only if the result of the operation is inexact), so the result is
+// guaranteed despite rounding errors.
+//
+// If the result could be NaN (like for "0 0 /" or for a negative base
+// of "^" with a non-integer exponent), then MaybeNaN is set.
+func EvalInterval(op types.Op, lhs, rhs Interval) Interval {
+	if op == types.OpIf {
+		switch {
+		case lhs.Lo > 0 && !lhs.MaybeNaN:
+			return rhs
+		case !(lhs.Hi > 0):
+			return PointInterval(0)
+		default:
+			// the condition is uncertain, so the result is any of the both
+			// (NaN is not greater than zero)
+			return rhs.Union(PointInterval(0))
+		}
+	}
+
+	r := evalIntervalArithmetic(op, lhs, rhs)
+	r.MaybeNaN = r.MaybeNaN || lhs.MaybeNaN || rhs.MaybeNaN
+	return r
+}
+
+func evalIntervalArithmetic(op types.Op, lhs, rhs Interval) Interval {
+	switch op {
+	case types.OpPlus:
+		return corners(addRounded, [2]float64{lhs.Lo, rhs.Lo}, [2]float64{lhs.Hi, rhs.Hi})
+	case types.OpMinus:
+		return corners(subRounded, [2]float64{lhs.Lo, rhs.Hi}, [2]float64{lhs.Hi, rhs.Lo})
+	case types.OpMultiply:
+		r := corners(mulRounded,
+			[2]float64{lhs.Lo, rhs.Lo}, [2]float64{lhs.Lo, rhs.Hi},
+			[2]float64{lhs.Hi, rhs.Lo}, [2]float64{lhs.Hi, rhs.Hi},
+		)
+		// an infinite bound is also a possible value, and zero multiplied
+		// by an infinity is NaN
+		r.MaybeNaN = r.MaybeNaN || (lhs.Contains(0) && rhs.isUnbounded()) || (rhs.Contains(0) && lhs.isUnbounded())
+		return r
+	case types.OpDivide:
+		if rhs.Contains(0) {
+			return nanInterval
+		}
+		return corners(divRounded,
+			[2]float64{lhs.Lo, rhs.Lo}, [2]float64{lhs.Lo, rhs.Hi},
+			[2]float64{lhs.Hi, rhs.Lo}, [2]float64{lhs.Hi, rhs.Hi},
+		)
+	case types.OpPower:
+		return evalIntervalPower(lhs, rhs)
+	default:
+		panic("do not know how to evaluate op: " + op.String())
+	}
+}
+
+// EvalIntervalFMA evaluates "a*b+c" (see types.OpFMA) on intervals
+// like EvalInterval does.
+func EvalIntervalFMA(a, b, c Interval) Interval {
+	// the bounds of "a*b" are rounded outward, so they contain the exact
+	// product, and so the sum contains the fused value
+	return EvalInterval(types.OpPlus, EvalInterval(types.OpMultiply, a, b), c)
+}
+
+func evalIntervalPower(base, exponent Interval) Interval {
+	if base.Lo >= 0 {
+		// x^y is monotonic by each argument for a non-negative base,
+		// so the extremes are in the corners
+		return hull(
+			math.Pow(base.Lo, exponent.Lo), math.Pow(base.Lo, exponent.Hi),
+			math.Pow(base.Hi, exponent.Lo), math.Pow(base.Hi, exponent.Hi),
+		).roundOutward(powRoundingULPs)
+	}
+
+	n := exponent.Lo
+	if n != exponent.Hi || n != math.Trunc(n) || math.IsInf(n, 0) {
+		// a negative base with a non-integer exponent gives NaN
+		return nanInterval
+	}
+
+	lo, hi := math.Pow(base.Lo, n), math.Pow(base.Hi, n)
+	switch {
+	case n == 0:
+		return PointInterval(1)
+	case n > 0 && math.Mod(n, 2) == 1:
+		// odd powers are monotonically increasing
+		return hull(lo, hi).roundOutward(powRoundingULPs)
+	case n > 0:
+		// even powers decrease until zero and increase after it
+		if base.Hi <= 0 {
+			return hull(lo, hi).roundOutward(powRoundingULPs)
+		}
+		return hull(0, lo, hi).roundOutward(powRoundingULPs)
+	default:
+		// a negative exponent: x^n = 1 / x^(-n)
+		return EvalInterval(
+			types.OpDivide,
+			PointInterval(1),
+			evalIntervalPower(base, PointInterval(-n)),
+		).roundOutward(powRoundingULPs)
+	}
+}
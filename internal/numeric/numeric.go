@@ -0,0 +1,59 @@
+// Package numeric contains the parts shared by the numerical methods
+// (packages montecarlo, solver and optimizer): a symbol resolver which
+// drives the values of variables, finite differences and the status of
+// an iterative method.
+package numeric
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// Status is the result of an iterative method.
+type Status uint8
+
+const (
+	// StatusConverged means the result is found within the tolerance.
+	StatusConverged = Status(iota)
+
+	// StatusMaxIterations means the result is not found within the maximal
+	// amount of iterations (the best approximation is returned).
+	StatusMaxIterations
+)
+
+// String implements fmt.Stringer
+func (status Status) String() string {
+	switch status {
+	case StatusConverged:
+		return "converged"
+	case StatusMaxIterations:
+		return "max_iterations"
+	default:
+		return fmt.Sprintf("unknown_status_%d", status)
+	}
+}
+
+// DisableMemoization disables memoization of the expression (memoized
+// results would not be updated with new values of the variables) and
+// returns the function which restores it.
+func DisableMemoization(expr types.Expr) (restore func()) {
+	old := expr.EnableMemoization(false)
+	return func() {
+		expr.EnableMemoization(old)
+	}
+}
+
+// DifferenceStep returns the step of central finite differences at `x`.
+func DifferenceStep(x float64) float64 {
+	// the optimal step for central differences is about cbrt(epsilon)
+	return 6e-6 * math.Max(1, math.Abs(x))
+}
+
+// Derivative returns the derivative of `f` at `x` by central finite
+// differences with a step not greater than `maxStep`.
+func Derivative(f func(float64) float64, x, maxStep float64) float64 {
+	h := math.Min(DifferenceStep(x), maxStep)
+	return (f(x+h) - f(x-h)) / (2 * h)
+}
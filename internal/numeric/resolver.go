@@ -0,0 +1,111 @@
+package numeric
+
+import (
+	"fmt"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+var (
+	_ types.SymbolResolver = &Resolver{}
+)
+
+// Resolver is a types.SymbolResolver which resolves the variables to
+// their values, which could be changed after an expression is parsed
+// (so a numerical method evaluates the expression with different values
+// of the variables without parsing it again). The other symbols are
+// resolved by a fallback resolver.
+type Resolver struct {
+	fallback types.SymbolResolver
+	values   map[string]*float64
+
+	// resolved are the symbols resolved by Resolve (the value is true
+	// if the symbol is resolved as a variable).
+	resolved map[string]bool
+
+	// kind is the description of a variable used in errors (for example,
+	// "an unknown").
+	kind string
+}
+
+// NewResolver returns a new Resolver without variables. The other
+// symbols are resolved by `fallback` (which could be nil if there are
+// no other symbols). `kind` describes a variable in errors (for example,
+// "an unknown").
+func NewResolver(fallback types.SymbolResolver, kind string) *Resolver {
+	return &Resolver{
+		fallback: fallback,
+		values:   map[string]*float64{},
+		resolved: map[string]bool{},
+		kind:     kind,
+	}
+}
+
+// Add adds variable `symbol` with value `value` (if it is not added yet).
+func (r *Resolver) Add(symbol string, value float64) {
+	if _, ok := r.values[symbol]; !ok {
+		r.values[symbol] = &value
+	}
+}
+
+// Resolve implements types.SymbolResolver
+func (r *Resolver) Resolve(symbol string) (types.ValueLoader, error) {
+	if value, ok := r.values[symbol]; ok {
+		r.resolved[symbol] = true
+		return types.FuncValue(func() float64 {
+			return *value
+		}), nil
+	}
+	if r.fallback == nil {
+		return nil, r.notVariableError(symbol)
+	}
+	loader, err := r.fallback.Resolve(symbol)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := r.resolved[symbol]; !ok {
+		r.resolved[symbol] = false
+	}
+	return loader, nil
+}
+
+// IsResolved returns true if variable `symbol` is resolved by Resolve,
+// so the expressions parsed with the Resolver could depend on its value.
+// If it is false, then changing the value has no effect on any
+// expression (for example, the expression is parsed with another
+// resolver).
+func (r *Resolver) IsResolved(symbol string) bool {
+	return r.resolved[symbol]
+}
+
+// IsResolvedByFallback returns true if symbol `symbol` is resolved by
+// Resolve through the fallback resolver only (for example, it was
+// resolved before it is added as a variable).
+func (r *Resolver) IsResolvedByFallback(symbol string) bool {
+	isVariable, ok := r.resolved[symbol]
+	return ok && !isVariable
+}
+
+// Value returns the pointer to the value of variable `symbol`, the value
+// could be changed through it.
+func (r *Resolver) Value(symbol string) (*float64, error) {
+	value, ok := r.values[symbol]
+	if !ok {
+		return nil, r.notVariableError(symbol)
+	}
+	return value, nil
+}
+
+// Set sets the value of variable `symbol`.
+func (r *Resolver) Set(symbol string, value float64) error {
+	v, err := r.Value(symbol)
+	if err != nil {
+		return err
+	}
+	*v = value
+	return nil
+}
+
+func (r *Resolver) notVariableError(symbol string) error {
+	return fmt.Errorf("symbol '%s' is not %s", symbol, r.kind)
+}
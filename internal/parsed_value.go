@@ -2,6 +2,7 @@ package internal
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
 
@@ -12,6 +13,17 @@ import (
 type ParsedValue struct {
 	ConstValue types.NullFloat64
 	FuncValue  types.FuncValue
+
+	// IsSymbol defines if the value was resolved by a types.SymbolResolver.
+	IsSymbol bool
+
+	// StdDevFunc is the loader of the standard deviation of the value if
+	// it is resolved to a types.UncertainValueLoader (otherwise nil).
+	StdDevFunc func() float64
+
+	// BigFunc is the loader of the value with arbitrary precision if
+	// it is resolved to a types.BigValueLoader (otherwise nil).
+	BigFunc func() *big.Float
 }
 
 // Load implements ValueLoader
@@ -62,7 +74,7 @@ func ParseValue(value string, symResolver types.SymbolResolver) (ParsedValue, er
 		return ParsedValue{}, fmt.Errorf("unable to valueLoader symbol '%s': %w", value, err)
 	}
 
-	r := ParsedValue{}
+	r := ParsedValue{IsSymbol: true}
 	switch valueLoader := valueLoader.(type) {
 	case types.StaticValue:
 		r.ConstValue = types.NullFloat64{
@@ -71,8 +83,74 @@ func ParseValue(value string, symResolver types.SymbolResolver) (ParsedValue, er
 		}
 	case types.FuncValue:
 		r.FuncValue = valueLoader
+	case types.UncertainValueLoader:
+		r.FuncValue = valueLoader.Load
+		r.StdDevFunc = valueLoader.StdDev
+	case types.BigValueLoader:
+		r.FuncValue = valueLoader.Load
+		r.BigFunc = valueLoader.LoadBig
 	default:
 		r.FuncValue = valueLoader.Load
 	}
 	return r, nil
 }
+
+// ParseBigLiteral parses a literal (in any form supported by ParseValue)
+// with precision `prec` (so for example "0.1" is rounded to `prec` bits
+// instead of 53 bits and large integers like "0x7fffffffffffffff" are
+// exact). It returns an error if `value` is not a literal or it is NaN
+// (which cannot be represented by big.Float).
+func ParseBigLiteral(value string, prec uint) (*big.Float, error) {
+	if base, digits := splitIntLiteral(value); base != 0 {
+		i, ok := new(big.Int).SetString(digits, base)
+		if !ok {
+			return nil, fmt.Errorf("unable to parse integer '%s'", value)
+		}
+		return new(big.Float).SetPrec(prec).SetInt(i), nil
+	}
+
+	r, _, err := big.ParseFloat(value, 10, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse '%s': %w", value, err)
+	}
+	return r, nil
+}
+
+// ParseRatLiteral parses a literal (in any form supported by ParseValue)
+// exactly (so for example "0.1" is exactly 1/10). It returns an error if
+// `value` is not a literal or it is not finite.
+func ParseRatLiteral(value string) (*big.Rat, error) {
+	if base, digits := splitIntLiteral(value); base != 0 {
+		i, ok := new(big.Int).SetString(digits, base)
+		if !ok {
+			return nil, fmt.Errorf("unable to parse integer '%s'", value)
+		}
+		return new(big.Rat).SetInt(i), nil
+	}
+
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		return nil, fmt.Errorf("unable to parse '%s': %w", value, err)
+	}
+	r, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return nil, fmt.Errorf("unable to parse '%s'", value)
+	}
+	return r, nil
+}
+
+// splitIntLiteral returns the base and the digits of an integer literal
+// with a prefix ("0x", "h", "b" or "o"). The base is zero if `value` has
+// no such prefix.
+func splitIntLiteral(value string) (base int, digits string) {
+	switch {
+	case strings.HasPrefix(value, "0x"):
+		return 16, value[2:]
+	case strings.HasPrefix(value, "h"):
+		return 16, value[1:]
+	case strings.HasPrefix(value, "b"):
+		return 2, value[1:]
+	case strings.HasPrefix(value, "o"):
+		return 8, value[1:]
+	}
+	return 0, value
+}
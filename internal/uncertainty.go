@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"math"
+)
+
+// Uncertainty propagates the standard deviations of the symbols of
+// an expression to the standard deviation of its value.
+type Uncertainty struct {
+	// Symbols are the names of the symbols (in the order of the gradient).
+	Symbols []string
+
+	// StdDevFuncs are the loaders of the standard deviations of Symbols
+	// (nil for exact symbols).
+	StdDevFuncs []func() float64
+
+	stdDevs []float64
+}
+
+// NewUncertainty returns a new Uncertainty of the symbols with standard
+// deviations loaded by `stdDevFuncs`.
+func NewUncertainty(symbols []string, stdDevFuncs []func() float64) *Uncertainty {
+	return &Uncertainty{
+		Symbols:     symbols,
+		StdDevFuncs: stdDevFuncs,
+		stdDevs:     make([]float64, len(symbols)),
+	}
+}
+
+// StdDev loads the standard deviations of the symbols and returns
+// the first-order estimation of the standard deviation of a value from
+// its gradient (the partial derivatives with respect to Symbols):
+//
+//	stdDev^2 = sum_i sum_j grad[i]*grad[j]*stdDevs[i]*stdDevs[j]*corr(i, j)
+//
+// where corr(i, i) is 1 and corr(i, j) is taken from `correlations` by
+// key {Symbols[i], Symbols[j]} or {Symbols[j], Symbols[i]} (zero if
+// absent, so the symbols are independent by default).
+func (u *Uncertainty) StdDev(grad []float64, correlations map[[2]string]float64) float64 {
+	stdDevs := u.stdDevs
+	for idx, stdDevFunc := range u.StdDevFuncs {
+		stdDevs[idx] = 0
+		if stdDevFunc != nil {
+			stdDevs[idx] = stdDevFunc()
+		}
+	}
+
+	symbols := u.Symbols
+	var variance float64
+	for i := range symbols {
+		if stdDevs[i] == 0 {
+			continue
+		}
+		term := grad[i] * stdDevs[i]
+		variance += term * term
+		for j := i + 1; j < len(symbols); j++ {
+			if stdDevs[j] == 0 {
+				continue
+			}
+			corr, ok := correlations[[2]string{symbols[i], symbols[j]}]
+			if !ok {
+				corr = correlations[[2]string{symbols[j], symbols[i]}]
+			}
+			if corr == 0 {
+				continue
+			}
+			variance += 2 * corr * term * grad[j] * stdDevs[j]
+		}
+	}
+	return math.Sqrt(variance)
+}
@@ -0,0 +1,97 @@
+package ir
+
+import (
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// BalanceChains is an option of Parse which rebalances chains of
+// additions and multiplications (see Expr.Balance).
+var BalanceChains Option = balanceChainsOption{}
+
+type balanceChainsOption struct{}
+
+func (balanceChainsOption) apply(cfg *config) {
+	cfg.BalanceChains = true
+}
+
+// StrictIEEE is an option of Parse which forbids the transformations
+// which may change the result of the expression (even in the last bits):
+// the optimization level is limited to O1, chains are not rebalanced and
+// compensated summation is not used.
+var StrictIEEE Option = strictIEEEOption{}
+
+type strictIEEEOption struct{}
+
+func (strictIEEEOption) apply(cfg *config) {
+	cfg.StrictIEEE = true
+}
+
+// Balance returns a copy of the expression where chains of additions
+// and multiplications are converted to balanced trees:
+//
+//	"a b + c + d +" -> "a b + c d + +"
+//
+// This reduces the depth of the tree from N to log2(N) (and so
+// the depth of calls and of the stack required to evaluate it), makes
+// halves of a chain independent and usually reduces the accumulated
+// rounding error. But the result may differ in the last bits, so see
+// also StrictIEEE.
+//
+// The order of the operands is preserved. The nodes of the original
+// expression are not modified.
+func (expr *Expr) Balance() *Expr {
+	refCounts := expr.RefCounts()
+	balanced := map[*Node]*Node{}
+
+	// collectOperands flattens a chain of operations "op": shared nodes
+	// are not flattened to keep them shared.
+	var collectOperands func(node *Node, op types.Op, operands []*Node) []*Node
+	collectOperands = func(node *Node, op types.Op, operands []*Node) []*Node {
+		if node.Op != op || refCounts[node] > 1 {
+			return append(operands, node)
+		}
+		operands = collectOperands(node.LHS, op, operands)
+		return collectOperands(node.RHS, op, operands)
+	}
+
+	var balance func(node *Node) *Node
+	balance = func(node *Node) *Node {
+		if node.Op == types.OpFetch {
+			return node
+		}
+		if r, ok := balanced[node]; ok {
+			return r
+		}
+
+		var r *Node
+		switch node.Op {
+		case types.OpPlus, types.OpMultiply:
+			operands := collectOperands(node.LHS, node.Op, nil)
+			operands = collectOperands(node.RHS, node.Op, operands)
+			for idx, operand := range operands {
+				operands[idx] = balance(operand)
+			}
+			r = balancedTree(node.Op, operands, node.Pos)
+		default:
+			r = node.mapOperands(balance)
+		}
+		balanced[node] = r
+		return r
+	}
+
+	return &Expr{
+		Source:   expr.Source,
+		Root:     balance(expr.Root),
+		Snapshot: expr.Snapshot,
+	}
+}
+
+func balancedTree(op types.Op, operands []*Node, pos Position) *Node {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	// the left half is not shorter, so chains of 3 operands
+	// are kept left-deep (as they are written in RPN usually).
+	middle := (len(operands) + 1) / 2
+	return opNode(op, balancedTree(op, operands[:middle], pos), balancedTree(op, operands[middle:], pos), pos)
+}
@@ -0,0 +1,84 @@
+package ir_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	calltree "github.com/xaionaro-go/rpn/implementations/calltree"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+func depth(node *ir.Node) int {
+	if node.Op == types.OpFetch {
+		return 1
+	}
+	lhs, rhs := depth(node.LHS), depth(node.RHS)
+	if lhs > rhs {
+		return lhs + 1
+	}
+	return rhs + 1
+}
+
+func TestExpr_Balance(t *testing.T) {
+	for expression, expected := range map[string]string{
+		"x y +":                 "x y +",
+		"x y + 1 +":             "x y + 1 +",
+		"x y + 1 + 2 +":         "x y + 1 2 + +",
+		"x y 1 2 + + +":         "x y + 1 2 + +",
+		"x y * 1 * 2 * 3 *":     "x y * 1 * 2 3 * *",
+		"x y * 1 * 2 * 3 + 4 +": "x y * 1 2 * * 3 + 4 +",
+		"x y - 1 + 2 + 3 +":     "x y - 1 + 2 3 + +",
+		"x y + 1 + 2 + 3 ^":     "x y + 1 2 + + 3 ^",
+		"x 1 2 3 4 + + + ^":     "x 1 2 + 3 4 + + ^",
+	} {
+		expr, err := ir.Parse(expression, &variables{})
+		require.NoError(t, err)
+		require.Equal(t, expected, expr.Balance().Root.String(), expression)
+		require.Equal(t, expression, expr.Root.String(), "the original expression should not be modified")
+	}
+
+	t.Run("shared", func(t *testing.T) {
+		expr, err := ir.Parse("x y + 1 + x y + 1 + 2 + +", &variables{})
+		require.NoError(t, err)
+		balanced := expr.EliminateCommonSubexpressions().Balance()
+		require.Equal(t, "x y + 1 + x y + 1 + + 2 +", balanced.Root.String())
+		require.Same(t, balanced.Root.LHS.LHS, balanced.Root.LHS.RHS)
+	})
+
+	t.Run("long_chain", func(t *testing.T) {
+		vars := &variables{X: 0.1}
+		expression := strings.Repeat("x ", 10000) + strings.Repeat("+ ", 9999)
+		expr, err := ir.Parse(expression, vars)
+		require.NoError(t, err)
+		require.Equal(t, 10000, depth(expr.Root))
+		require.Equal(t, 15, depth(expr.Balance().Root))
+
+		unbalanced, err := calltree.Parse(expression, vars)
+		require.NoError(t, err)
+		balanced, err := calltree.Parse(expression, vars, ir.BalanceChains)
+		require.NoError(t, err)
+		require.InDelta(t, 1000, unbalanced.Eval(), 1e-9)
+		require.InDelta(t, 1000, balanced.Eval(), 1e-12)
+	})
+}
+
+func TestParse_balanceOptions(t *testing.T) {
+	const expression = "x 1 + 2 + y + x +"
+	for _, testCase := range []struct {
+		Options  []ir.Option
+		Expected string
+	}{
+		{nil, "x 1 + 2 + y + x +"},
+		{[]ir.Option{ir.BalanceChains}, "x 1 + 2 + y x + +"},
+		{[]ir.Option{ir.O1}, "x 1 + 2 + y + x +"},
+		{[]ir.Option{ir.O2}, "x 3 + y x + +"},
+		{[]ir.Option{ir.O2, ir.StrictIEEE}, "x 1 + 2 + y + x +"},
+		{[]ir.Option{ir.BalanceChains, ir.StrictIEEE}, "x 1 + 2 + y + x +"},
+	} {
+		expr, err := ir.Parse(expression, &variables{}, testCase.Options...)
+		require.NoError(t, err)
+		require.Equal(t, testCase.Expected, expr.Root.String(), "%v", testCase.Options)
+	}
+}
@@ -0,0 +1,78 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// ParseUnbound converts Reverse Polish Notation expression "expression"
+// to an expression tree without resolving the symbols (any token which
+// is neither an operation nor a literal is considered a symbol).
+//
+// The result cannot be evaluated, it is a template which could be
+// bound to different resolvers by Bind (to not parse the same
+// expression again).
+func ParseUnbound(expression string) (*Expr, error) {
+	return parseTree(expression, unboundResolver{})
+}
+
+type unboundResolver struct{}
+
+func (unboundResolver) Resolve(sym string) (types.ValueLoader, error) {
+	return types.FuncValue(nil), nil
+}
+
+// Bind returns a copy of the expression where the symbols are resolved
+// by `symResolver` and then the options are applied (as Parse does).
+//
+// The expression should be the result of ParseUnbound (or of Parse without
+// options): the options are not expected to be applied twice and folded
+// constant symbols cannot be resolved again. The nodes of the original
+// expression are not modified.
+func (expr *Expr) Bind(symResolver types.SymbolResolver, opts ...Option) (*Expr, error) {
+	// a symbol is resolved once even if it is used multiple times
+	resolved := map[string]internal.ParsedValue{}
+	replaced := map[*Node]*Node{}
+	var err error
+	var bind func(node *Node) *Node
+	bind = func(node *Node) *Node {
+		if r, ok := replaced[node]; ok {
+			return r
+		}
+		r := node
+		switch {
+		case err != nil:
+		case node.Op != types.OpFetch:
+			r = node.mapOperands(bind)
+		case node.IsSymbol:
+			parsedValue, ok := resolved[node.Token]
+			if !ok {
+				parsedValue, err = internal.ParseValue(node.Token, symResolver)
+				if err != nil {
+					err = fmt.Errorf("unable to parse value '%s' at %s: %w", node.Token, node.Pos, err)
+					return node
+				}
+				resolved[node.Token] = parsedValue
+			}
+			newNode := *node
+			newNode.ConstValue = parsedValue.ConstValue
+			newNode.FuncValue = parsedValue.FuncValue
+			newNode.StdDevFunc = parsedValue.StdDevFunc
+			newNode.BigFunc = parsedValue.BigFunc
+			r = &newNode
+		}
+		replaced[node] = r
+		return r
+	}
+
+	bound := &Expr{
+		Source: expr.Source,
+		Root:   bind(expr.Root),
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bound.withOptions(opts), nil
+}
@@ -0,0 +1,32 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+func TestExpr_Bind(t *testing.T) {
+	unbound, err := ir.ParseUnbound("x y * 2 3 + +")
+	require.NoError(t, err)
+	require.Equal(t, "x y * 2 3 + +", unbound.Root.String())
+
+	varsA, varsB := &variables{X: 1, Y: 2}, &variables{X: 3, Y: 4}
+	boundA, err := unbound.Bind(varsA)
+	require.NoError(t, err)
+	boundB, err := unbound.Bind(varsB, ir.O1)
+	require.NoError(t, err)
+	require.Equal(t, "x y * 2 3 + +", boundA.Root.String())
+	require.Equal(t, "x y * 5 +", boundB.Root.String())
+	require.Equal(t, float64(7), boundA.Root.Func()())
+	require.Equal(t, float64(17), boundB.Root.Func()())
+
+	_, err = ir.ParseUnbound("x +")
+	require.Error(t, err)
+
+	unbound, err = ir.ParseUnbound("x unknown +")
+	require.NoError(t, err)
+	_, err = unbound.Bind(varsA)
+	require.Error(t, err)
+}
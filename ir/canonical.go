@@ -0,0 +1,137 @@
+package ir
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// Canonicalize returns a canonical form of the expression, so
+// equivalent expressions have the same canonical form:
+//
+//	"x y +" and "y x +" -> "x y +" (or "y x +", but the same for both)
+//	"x 1 *" and "x"     -> "x"
+//	"0x10" and "16"     -> "16"
+//
+// The optimizations of O1 are applied, then the operands of commutative
+// operations ("+", "*" and the factors of "fma") are ordered by their
+// hashes and constants are formatted in the same way. Associativity is
+// not used, because it may change the result ("x y + z +" and
+// "x y z + +" are different expressions).
+//
+// Constant symbols (see types.StaticValue) are replaced with their
+// values. The nodes of the original expression are not modified.
+func (expr *Expr) Canonicalize() *Expr {
+	optimized := expr.Optimize(O1)
+	hashes := map[*Node]uint64{}
+	replaced := map[*Node]*Node{}
+	var canonicalize func(node *Node) *Node
+	canonicalize = func(node *Node) *Node {
+		if r, ok := replaced[node]; ok {
+			return r
+		}
+		r := node
+		switch {
+		case node.IsConst():
+			v := node.ConstValue.Float64
+			if math.IsNaN(v) {
+				v = math.NaN()
+			}
+			r = derivedNode(node, v)
+		case node.Sum != nil:
+			sum := &Sum{
+				Terms:      make([]*Node, len(node.Sum.Terms)),
+				IsNegative: node.Sum.IsNegative,
+			}
+			for idx, term := range node.Sum.Terms {
+				sum.Terms[idx] = canonicalize(term)
+			}
+			r = sumNode(sum, node.Pos)
+		case node.Op != types.OpFetch:
+			r = node.mapOperands(canonicalize)
+			if isCommutative(r.Op) && r.LHS.hash(hashes) > r.RHS.hash(hashes) {
+				newNode := *r
+				newNode.LHS, newNode.RHS = r.RHS, r.LHS
+				r = &newNode
+			}
+		}
+		replaced[node] = r
+		return r
+	}
+
+	return &Expr{
+		Source:   expr.Source,
+		Root:     canonicalize(optimized.Root),
+		Snapshot: expr.Snapshot,
+	}
+}
+
+// isCommutative returns true if the first two operands of
+// the operation could be swapped.
+func isCommutative(op types.Op) bool {
+	switch op {
+	case types.OpPlus, types.OpMultiply, types.OpFMA:
+		return true
+	}
+	return false
+}
+
+// Hash returns a hash of the canonical form of the expression (see
+// Canonicalize), so equivalent expressions have the same hash. The hash
+// is stable: it does not depend on the process or the platform, so it
+// could be stored.
+func (expr *Expr) Hash() uint64 {
+	return expr.Canonicalize().Root.hash(map[*Node]uint64{})
+}
+
+// Equal returns true if the canonical forms of the expressions are
+// the same (see Canonicalize).
+func Equal(a, b *Expr) bool {
+	a, b = a.Canonicalize(), b.Canonicalize()
+	hashes := map[*Node]uint64{}
+	if a.Root.hash(hashes) != b.Root.hash(hashes) {
+		return false
+	}
+	return a.Root.String() == b.Root.String()
+}
+
+// hash returns a structural hash of the (sub-)expression of the node.
+func (node *Node) hash(hashes map[*Node]uint64) uint64 {
+	if h, ok := hashes[node]; ok {
+		return h
+	}
+
+	h := fnv.New64a()
+	var buf [8]byte
+	writeUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		_, _ = h.Write(buf[:])
+	}
+
+	_, _ = h.Write([]byte{byte(node.Op)})
+	switch {
+	case node.IsConst():
+		writeUint64(math.Float64bits(node.ConstValue.Float64))
+	case node.Sum != nil:
+		for idx, term := range node.Sum.Terms {
+			writeUint64(term.hash(hashes))
+			if node.Sum.IsNegative[idx] {
+				_, _ = h.Write([]byte{1})
+			} else {
+				_, _ = h.Write([]byte{0})
+			}
+		}
+	case node.Op == types.OpFetch:
+		_, _ = h.Write([]byte(node.Token))
+	default:
+		for _, operand := range node.Operands() {
+			writeUint64(operand.hash(hashes))
+		}
+	}
+
+	r := h.Sum64()
+	hashes[node] = r
+	return r
+}
@@ -0,0 +1,55 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+func TestExpr_Canonicalize(t *testing.T) {
+	for _, equivalent := range [][]string{
+		{"x y +", "y x +"},
+		{"x", "x 1 *", "1 x *", "x 1 /", "x 0 -"},
+		{"16 x *", "0x10 x *", "x h10 *", "x b10000 *", "x 8 2 * *"},
+		{"x y * 2 +", "y x * 2 +", "2 x y * +", "2 y x * +"},
+		{"x y 2 fma", "y x 2 fma"},
+		{"x y + y x + *", "y x + x y + *"},
+		{"x y - y x - /", "x y - 1 * y x - /"},
+	} {
+		for _, expression := range equivalent {
+			expr, err := ir.Parse(expression, &variables{})
+			require.NoError(t, err)
+			reference, err := ir.Parse(equivalent[0], &variables{})
+			require.NoError(t, err)
+
+			require.True(t, ir.Equal(reference, expr), "'%s' vs '%s'", equivalent[0], expression)
+			require.Equal(t, reference.Hash(), expr.Hash(), "'%s' vs '%s'", equivalent[0], expression)
+			require.Equal(t, reference.Canonicalize().Root.String(), expr.Canonicalize().Root.String())
+			require.Equal(t, expression, expr.Root.String(), "the original expression should not be modified")
+		}
+	}
+
+	for _, different := range [][2]string{
+		{"x y -", "y x -"},
+		{"x y /", "y x /"},
+		{"x y if", "y x if"},
+		{"x y + 1 +", "x y 1 + +"},
+		{"x 0 +", "x"},
+		{"x y 2 fma", "x 2 y fma"},
+		{"x 0.1 +", "x 0.10000000000000002 +"},
+	} {
+		a, err := ir.Parse(different[0], &variables{})
+		require.NoError(t, err)
+		b, err := ir.Parse(different[1], &variables{})
+		require.NoError(t, err)
+		require.False(t, ir.Equal(a, b), "'%s' vs '%s'", different[0], different[1])
+		require.NotEqual(t, a.Hash(), b.Hash(), "'%s' vs '%s'", different[0], different[1])
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		expr, err := ir.Parse("x y +", &variables{})
+		require.NoError(t, err)
+		require.Equal(t, uint64(0x48faaced439a151f), expr.Hash(), "the hash should not change between versions")
+	})
+}
@@ -0,0 +1,90 @@
+package ir
+
+import (
+	"math"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+type nodeKey struct {
+	Op         types.Op
+	LHS        *Node
+	RHS        *Node
+	Addend     *Node
+	Symbol     string
+	ConstValue uint64
+}
+
+// EliminateCommonSubexpressions returns a copy of the expression where
+// equal sub-expressions are merged into one node (so the tree becomes
+// a DAG). The nodes of the original expression are not modified.
+//
+// Symbols with the same name are considered equal, so a SymbolResolver
+// should return the same value for the same symbol.
+func (expr *Expr) EliminateCommonSubexpressions() *Expr {
+	nodes := map[nodeKey]*Node{}
+	replaced := map[*Node]*Node{}
+	var merge func(node *Node) *Node
+	merge = func(node *Node) *Node {
+		if r, ok := replaced[node]; ok {
+			return r
+		}
+
+		var key nodeKey
+		r := node
+		switch {
+		case node.Op != types.OpFetch:
+			r = node.mapOperands(merge)
+			key = nodeKey{Op: r.Op, LHS: r.LHS, RHS: r.RHS, Addend: r.Addend}
+		case node.ConstValue.Valid:
+			key = nodeKey{Op: types.OpFetch, ConstValue: math.Float64bits(node.ConstValue.Float64)}
+		default:
+			key = nodeKey{Op: types.OpFetch, Symbol: node.Token}
+		}
+
+		if existing, ok := nodes[key]; ok {
+			r = existing
+		} else {
+			nodes[key] = r
+		}
+		replaced[node] = r
+		return r
+	}
+
+	return &Expr{
+		Source:   expr.Source,
+		Root:     merge(expr.Root),
+		Snapshot: expr.Snapshot,
+	}
+}
+
+// WalkDAG calls `fn` for each node in post-order, like Walk does, but
+// does not walk the same node twice: on the repeated occurrences of
+// a node `fn` is called with isVisited == true (and the children of
+// the node are not walked).
+func (node *Node) WalkDAG(fn func(node *Node, isVisited bool)) {
+	visited := map[*Node]struct{}{}
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		if _, ok := visited[node]; ok {
+			fn(node, true)
+			return
+		}
+		for _, operand := range node.Operands() {
+			walk(operand)
+		}
+		visited[node] = struct{}{}
+		fn(node, false)
+	}
+	walk(node)
+}
+
+// RefCounts returns how many times each node is referenced in the
+// expression (the root is referenced once).
+func (expr *Expr) RefCounts() map[*Node]int {
+	refCounts := map[*Node]int{}
+	expr.Root.WalkDAG(func(node *Node, isVisited bool) {
+		refCounts[node]++
+	})
+	return refCounts
+}
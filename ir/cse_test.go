@@ -0,0 +1,34 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+func TestExpr_EliminateCommonSubexpressions(t *testing.T) {
+	expr, err := ir.Parse("x y + 2 * x y + 2.0 * +", &variables{})
+	require.NoError(t, err)
+
+	dag := expr.EliminateCommonSubexpressions()
+	require.Equal(t, "x y + 2 * x y + 2 * +", dag.Root.String())
+	require.NotSame(t, expr.Root.LHS, expr.Root.RHS, "the original expression should not be modified")
+	require.Same(t, dag.Root.LHS, dag.Root.RHS)
+
+	refCounts := dag.RefCounts()
+	require.Equal(t, 1, refCounts[dag.Root])
+	require.Equal(t, 2, refCounts[dag.Root.LHS])
+	require.Equal(t, 1, refCounts[dag.Root.LHS.LHS])
+	require.Equal(t, 1, refCounts[dag.Root.LHS.LHS.LHS])
+
+	var walked []string
+	dag.Root.WalkDAG(func(node *ir.Node, isVisited bool) {
+		if isVisited {
+			walked = append(walked, "("+node.String()+")")
+			return
+		}
+		walked = append(walked, node.Token)
+	})
+	require.Equal(t, []string{"x", "y", "+", "2", "*", "(x y + 2 *)", "+"}, walked)
+}
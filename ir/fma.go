@@ -0,0 +1,77 @@
+package ir
+
+import (
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// ContractFMA is an option of Parse which contracts multiplications
+// followed by additions into fused multiply-add operations
+// (see Expr.ContractFMA).
+var ContractFMA Option = contractFMAOption{}
+
+type contractFMAOption struct{}
+
+func (contractFMAOption) apply(cfg *config) {
+	cfg.ContractFMA = true
+}
+
+// ContractFMA returns a copy of the expression where additions of
+// a product are replaced with fused multiply-add operations:
+//
+//	"a b * c +" -> "a b c fma"
+//	"c a b * +" -> "a b c fma"
+//
+// The product is rounded only once together with the addition, so
+// the result is usually more precise (and faster to evaluate), but it
+// may differ in the last bits, so see also StrictIEEE.
+//
+// Shared products (see EliminateCommonSubexpressions) are not contracted
+// to not evaluate them twice. The nodes of the original expression are
+// not modified.
+func (expr *Expr) ContractFMA() *Expr {
+	refCounts := expr.RefCounts()
+	contracted := map[*Node]*Node{}
+
+	isContractible := func(node *Node) bool {
+		return node.Op == types.OpMultiply && refCounts[node] == 1
+	}
+
+	var contract func(node *Node) *Node
+	contract = func(node *Node) *Node {
+		if node.Op == types.OpFetch {
+			return node
+		}
+		if r, ok := contracted[node]; ok {
+			return r
+		}
+
+		var r *Node
+		switch {
+		case node.Op == types.OpPlus && isContractible(node.LHS):
+			r = fmaNode(node.LHS, node.RHS, node.Pos, contract)
+		case node.Op == types.OpPlus && isContractible(node.RHS):
+			r = fmaNode(node.RHS, node.LHS, node.Pos, contract)
+		default:
+			r = node.mapOperands(contract)
+		}
+		contracted[node] = r
+		return r
+	}
+
+	return &Expr{
+		Source:   expr.Source,
+		Root:     contract(expr.Root),
+		Snapshot: expr.Snapshot,
+	}
+}
+
+func fmaNode(product, addend *Node, pos Position, contract func(*Node) *Node) *Node {
+	return &Node{
+		Op:     types.OpFMA,
+		LHS:    contract(product.LHS),
+		RHS:    contract(product.RHS),
+		Addend: contract(addend),
+		Token:  types.OpFMA.String(),
+		Pos:    pos,
+	}
+}
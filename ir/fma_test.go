@@ -0,0 +1,60 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+func TestExpr_ContractFMA(t *testing.T) {
+	for expression, expected := range map[string]string{
+		"x y *":                     "x y *",
+		"x y * 1 +":                 "x y 1 fma",
+		"1 x y * +":                 "x y 1 fma",
+		"x y * x y * +":             "x y x y * fma",
+		"x y * 1 -":                 "x y * 1 -",
+		"x 2 * 3 + x * 4 +":         "x 2 3 fma x 4 fma",
+		"x y * 1 + 2 ^":             "x y 1 fma 2 ^",
+		"x y 1 fma 2 *":             "x y 1 fma 2 *",
+		"x y x y * 1 + fma 2 * 3 +": "x y x y 1 fma fma 2 3 fma",
+	} {
+		expr, err := ir.Parse(expression, &variables{})
+		require.NoError(t, err)
+		require.Equal(t, expected, expr.ContractFMA().Root.String(), expression)
+		require.Equal(t, expression, expr.Root.String(), "the original expression should not be modified")
+	}
+
+	t.Run("shared", func(t *testing.T) {
+		expr, err := ir.Parse("x y * 1 + x y * *", &variables{}, ir.O1)
+		require.NoError(t, err)
+		require.Equal(t, "x y * 1 + x y * *", expr.ContractFMA().Root.String())
+	})
+}
+
+func TestParse_fma(t *testing.T) {
+	for _, testCase := range []struct {
+		Expression string
+		Options    []ir.Option
+		Expected   string
+	}{
+		{"x y 1 fma", nil, "x y 1 fma"},
+		{"2 3 4 fma", []ir.Option{ir.O1}, "10"},
+		{"x 3 4 fma", []ir.Option{ir.O1}, "x 3 4 fma"},
+		{"x y 1 fma x y 1 fma +", []ir.Option{ir.O1}, "x y 1 fma x y 1 fma +"},
+		{"x y * 1 +", []ir.Option{ir.ContractFMA}, "x y 1 fma"},
+		{"x 2 * 3 * 1 +", []ir.Option{ir.ContractFMA, ir.O2}, "x 6 1 fma"},
+		{"x y * 1 +", []ir.Option{ir.ContractFMA, ir.StrictIEEE}, "x y * 1 +"},
+	} {
+		expr, err := ir.Parse(testCase.Expression, &variables{}, testCase.Options...)
+		require.NoError(t, err)
+		require.Equal(t, testCase.Expected, expr.Root.String(), testCase.Expression)
+	}
+
+	expr, err := ir.Parse("x y 1 fma x y 1 fma +", &variables{}, ir.O1)
+	require.NoError(t, err)
+	require.Same(t, expr.Root.LHS, expr.Root.RHS)
+
+	_, err = ir.Parse("x y fma", &variables{})
+	require.Error(t, err)
+}
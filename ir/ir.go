@@ -0,0 +1,330 @@
+// Package ir contains the intermediate representation of expressions,
+// which is shared by all the implementations: the expression is parsed
+// (and validated) by Parse once, and the implementations are built
+// from the resulting tree.
+package ir
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// Position is a location of a token in the source expression.
+type Position struct {
+	// Offset is the offset (in bytes) of the token from the beginning
+	// of the expression.
+	Offset int
+
+	// PartIdx is the index of the token in the expression split by
+	// spaces (including empty parts).
+	PartIdx int
+}
+
+// String implements fmt.Stringer
+func (pos Position) String() string {
+	return fmt.Sprintf("offset %d (part index %d)", pos.Offset, pos.PartIdx)
+}
+
+// Node is a node of an expression tree.
+//
+// A node is either an operation (Op is not types.OpFetch, LHS and RHS
+// are set, and Addend is set for types.OpFMA) or a value (Op is types.OpFetch): a constant (ConstValue
+// is valid) or a symbol which value should be loaded by FuncValue.
+type Node struct {
+	Op  types.Op
+	LHS *Node
+	RHS *Node
+
+	// Addend is the third operand of types.OpFMA (the node is
+	// "LHS*RHS+Addend"), it is nil for other operations.
+	Addend *Node
+
+	// Token is the text of the node in the source expression.
+	Token string
+
+	// Pos is the location of Token in the source expression.
+	Pos Position
+
+	// IsSymbol defines if the value is a symbol resolved by
+	// a types.SymbolResolver (otherwise it is a literal or an operation).
+	IsSymbol bool
+
+	// ConstValue is the value of a constant node.
+	ConstValue types.NullFloat64
+
+	// IsFolded defines if the constant is computed with float64
+	// precision by an optimization (like the value of a sub-expression
+	// with O1, or a negated symbol with O2), so Token is not a literal
+	// of the source expression.
+	IsFolded bool
+
+	// FuncValue is the loader of the value of a non-constant symbol.
+	FuncValue types.FuncValue
+
+	// StdDevFunc is the loader of the standard deviation of a symbol
+	// resolved to a types.UncertainValueLoader (otherwise nil).
+	StdDevFunc func() float64
+
+	// BigFunc is the loader of the value of a symbol with arbitrary
+	// precision if it is resolved to a types.BigValueLoader (otherwise nil).
+	BigFunc func() *big.Float
+
+	// Sum is set if the node is a compensated sum (see Expr.CompensateSums),
+	// its value is loaded by FuncValue as well.
+	Sum *Sum
+}
+
+// Expr is a parsed expression.
+type Expr struct {
+	// Source is the source expression (in Reverse Polish Notation).
+	Source string
+
+	// Root is the root node of the expression tree.
+	Root *Node
+
+	// Snapshot is the snapshot of the values of the symbols, which
+	// should be loaded at the beginning of each evaluation (if not nil).
+	// See WithSnapshot.
+	Snapshot *Snapshot
+}
+
+// IsConst returns true if the node is a constant value.
+func (node *Node) IsConst() bool {
+	return node.Op == types.OpFetch && node.ConstValue.Valid
+}
+
+// Walk calls `fn` for each node of the tree in post-order (the order of
+// Reverse Polish Notation).
+func (node *Node) Walk(fn func(node *Node)) {
+	for _, operand := range node.Operands() {
+		operand.Walk(fn)
+	}
+	fn(node)
+}
+
+// Operands returns the operands of the node in the order they are put
+// to the stack (nil for a value).
+func (node *Node) Operands() []*Node {
+	switch node.Op.Arity() {
+	case 0:
+		return nil
+	case 3:
+		return []*Node{node.LHS, node.RHS, node.Addend}
+	default:
+		return []*Node{node.LHS, node.RHS}
+	}
+}
+
+// mapOperands returns the node with each operand replaced by
+// fn(operand). The node is copied only if an operand is changed.
+func (node *Node) mapOperands(fn func(operand *Node) *Node) *Node {
+	if node.Op == types.OpFetch {
+		return node
+	}
+	lhs, rhs := fn(node.LHS), fn(node.RHS)
+	var addend *Node
+	if node.Addend != nil {
+		addend = fn(node.Addend)
+	}
+	if lhs == node.LHS && rhs == node.RHS && addend == node.Addend {
+		return node
+	}
+	newNode := *node
+	newNode.LHS, newNode.RHS, newNode.Addend = lhs, rhs, addend
+	return &newNode
+}
+
+// Len returns the amount of nodes in the expression.
+func (expr *Expr) Len() int {
+	count := 0
+	expr.Root.Walk(func(*Node) {
+		count++
+	})
+	return count
+}
+
+// Symbols returns the names of the distinct symbols of the expression
+// (see Node.IsSymbol) in the order of their first occurrence.
+func (expr *Expr) Symbols() []string {
+	var names []string
+	isAdded := map[string]bool{}
+	expr.Root.Walk(func(node *Node) {
+		if node.Op == types.OpFetch && node.IsSymbol && !isAdded[node.Token] {
+			isAdded[node.Token] = true
+			names = append(names, node.Token)
+		}
+	})
+	return names
+}
+
+// StdDevFuncs returns the loaders of the standard deviations of
+// the symbols (see types.UncertainValueLoader), nil for the symbols
+// which are not uncertain (or are absent in the expression).
+func (expr *Expr) StdDevFuncs(symbols []string) []func() float64 {
+	indices := make(map[string]int, len(symbols))
+	for idx, name := range symbols {
+		indices[name] = idx
+	}
+	stdDevFuncs := make([]func() float64, len(symbols))
+	expr.Root.Walk(func(node *Node) {
+		if node.StdDevFunc == nil {
+			return
+		}
+		if idx, ok := indices[node.Token]; ok {
+			stdDevFuncs[idx] = node.StdDevFunc
+		}
+	})
+	return stdDevFuncs
+}
+
+// String implements fmt.Stringer
+func (expr *Expr) String() string {
+	return expr.Source
+}
+
+// String implements fmt.Stringer. It returns the expression in
+// Reverse Polish Notation.
+func (node *Node) String() string {
+	var tokens []string
+	node.Walk(func(node *Node) {
+		switch {
+		case node.Op != types.OpFetch:
+			tokens = append(tokens, node.Op.String())
+		case node.Token != "":
+			tokens = append(tokens, node.Token)
+		default:
+			tokens = append(tokens, fmt.Sprint(node.ConstValue.Float64))
+		}
+	})
+	return strings.Join(tokens, " ")
+}
+
+// Option is an option of Parse (for example OptimizationLevel).
+type Option interface {
+	apply(cfg *config)
+}
+
+type config struct {
+	OptimizationLevel    OptimizationLevel
+	SnapshotSymbols      bool
+	BalanceChains        bool
+	CompensatedSummation bool
+	ContractFMA          bool
+	StrictIEEE           bool
+}
+
+// Parse converts Reverse Polish Notation expression "expression" to
+// an expression tree.
+//
+// input example: "z x y + *"
+// calculation interpretation: z * (x + y)
+// tree: *(z,+(x,y))
+func Parse(expression string, symResolver types.SymbolResolver, opts ...Option) (*Expr, error) {
+	expr, err := parseTree(expression, symResolver)
+	if err != nil {
+		return nil, err
+	}
+	return expr.withOptions(opts), nil
+}
+
+// parseTree converts the expression to a tree (without applying
+// any options).
+func parseTree(expression string, symResolver types.SymbolResolver) (*Expr, error) {
+	var stack []*Node
+	offset := 0
+	for partIdx, part := range strings.Split(expression, " ") {
+		pos := Position{
+			Offset:  offset,
+			PartIdx: partIdx,
+		}
+		offset += len(part) + 1
+		if part == "" {
+			continue
+		}
+
+		op := types.ParseOp(part)
+		if op != types.OpUndefined {
+			arity := op.Arity()
+			if len(stack) < arity {
+				return nil, fmt.Errorf("invalid expression '%s' at %s: expected at least %d entries in the stack", expression, pos, arity)
+			}
+			operands := stack[len(stack)-arity:]
+			node := &Node{
+				Op:    op,
+				LHS:   operands[0],
+				RHS:   operands[1],
+				Token: part,
+				Pos:   pos,
+			}
+			if arity == 3 {
+				node.Addend = operands[2]
+			}
+			stack = append(stack[:len(stack)-arity], node)
+			continue
+		}
+
+		parsedValue, err := internal.ParseValue(part, symResolver)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse value '%s' at %s: %w", part, pos, err)
+		}
+		stack = append(stack, &Node{
+			Op:         types.OpFetch,
+			Token:      part,
+			Pos:        pos,
+			IsSymbol:   parsedValue.IsSymbol,
+			ConstValue: parsedValue.ConstValue,
+			FuncValue:  parsedValue.FuncValue,
+			StdDevFunc: parsedValue.StdDevFunc,
+			BigFunc:    parsedValue.BigFunc,
+		})
+	}
+
+	switch len(stack) {
+	case 0:
+		return nil, fmt.Errorf("empty expression: '%s'", expression)
+	case 1:
+	default:
+		return nil, fmt.Errorf("invalid expression '%s': expected stack length is 1, but got %d", expression, len(stack))
+	}
+
+	return &Expr{
+		Source: expression,
+		Root:   stack[0],
+	}, nil
+}
+
+// withOptions returns the expression transformed according
+// to the options.
+func (expr *Expr) withOptions(opts []Option) *Expr {
+	var cfg config
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+
+	if cfg.SnapshotSymbols {
+		expr = expr.WithSnapshot()
+	}
+	if cfg.StrictIEEE {
+		if cfg.OptimizationLevel > O1 {
+			cfg.OptimizationLevel = O1
+		}
+		cfg.BalanceChains = false
+		cfg.CompensatedSummation = false
+		cfg.ContractFMA = false
+	}
+	expr = expr.Optimize(cfg.OptimizationLevel)
+	if cfg.BalanceChains && cfg.OptimizationLevel < O2 {
+		expr = expr.Balance()
+	}
+	if cfg.ContractFMA {
+		expr = expr.ContractFMA()
+	}
+	if cfg.CompensatedSummation {
+		expr = expr.CompensateSums()
+	}
+	return expr
+}
@@ -0,0 +1,49 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/tests"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+func TestParse(t *testing.T) {
+	expr, err := ir.Parse("y  x0 x1 + *", tests.DummyResolver{T: t})
+	require.NoError(t, err)
+	require.Equal(t, "y x0 x1 + *", expr.Root.String())
+	require.Equal(t, 5, expr.Len())
+
+	root := expr.Root
+	require.Equal(t, types.OpMultiply, root.Op)
+	require.Equal(t, ir.Position{Offset: 11, PartIdx: 5}, root.Pos)
+
+	y := root.LHS
+	require.True(t, y.IsSymbol)
+	require.True(t, y.IsConst())
+	require.Equal(t, float64(4), y.ConstValue.Float64)
+	require.Equal(t, ir.Position{Offset: 0, PartIdx: 0}, y.Pos)
+
+	x0 := root.RHS.LHS
+	require.Equal(t, "x0", x0.Token)
+	require.True(t, x0.IsSymbol)
+	require.False(t, x0.IsConst())
+	require.Equal(t, float64(2), x0.FuncValue())
+	require.Equal(t, ir.Position{Offset: 3, PartIdx: 2}, x0.Pos)
+
+	literal, err := ir.Parse("h10", nil)
+	require.NoError(t, err)
+	require.False(t, literal.Root.IsSymbol)
+	require.Equal(t, float64(16), literal.Root.ConstValue.Float64)
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, expression := range []string{"", "  ", "1 +", "1 2", "1 2 + 3", "1 unknown +"} {
+		_, err := ir.Parse(expression, nil)
+		require.Error(t, err, expression)
+	}
+
+	_, err := ir.Parse("1 2 + + 3", nil)
+	require.Contains(t, err.Error(), "offset 6 (part index 3)")
+}
@@ -0,0 +1,183 @@
+package ir
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/xaionaro-go/rpn/internal"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// Severity is the importance of a Diagnostic.
+type Severity uint8
+
+const (
+	// SeverityWarning means the problem may happen for some values
+	// of the symbols.
+	SeverityWarning = Severity(iota)
+
+	// SeverityError means the problem happens for all values of
+	// the symbols.
+	SeverityError
+)
+
+// String implements fmt.Stringer
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("unknown_severity_%d", severity)
+	}
+}
+
+// Diagnostic is a problem of an expression found by Lint.
+type Diagnostic struct {
+	// Pos is the location of the operation which has the problem.
+	Pos Position
+
+	// Severity is the importance of the problem.
+	Severity Severity
+
+	// Message is the human-readable description of the problem.
+	Message string
+}
+
+// String implements fmt.Stringer
+func (diag Diagnostic) String() string {
+	return fmt.Sprintf("%s at %s: %s", diag.Severity, diag.Pos, diag.Message)
+}
+
+// Lint analyzes the expression for all values of the symbols within
+// the given ranges ("ranges[sym][0]" is the lower bound of the symbol
+// and "ranges[sym][1]" is the upper bound) and returns the found
+// problems ordered by their positions:
+//
+//	division by zero;
+//	a negative base of "^" with a non-integer exponent (the result is NaN);
+//	a condition of "if" which is always true or always false;
+//	an overflow to Inf of finite operands.
+//
+// The ranges are optional: a non-constant symbol absent in `ranges`
+// could have any value, and a constant symbol (see types.StaticValue)
+// has its value. The analysis uses interval arithmetic, so it reports
+// every possible problem, but it may also report problems which cannot
+// happen (for example, for "x x x - /" the divisor is considered to be
+// possibly zero).
+func (expr *Expr) Lint(ranges map[string][2]float64) []Diagnostic {
+	var diags []Diagnostic
+	var report lintReportFunc = func(node *Node, severity Severity, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{
+			Pos:      node.Pos,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	intervals := map[*Node]internal.Interval{}
+	var lint func(node *Node) internal.Interval
+	lint = func(node *Node) internal.Interval {
+		if r, ok := intervals[node]; ok {
+			return r
+		}
+		var r internal.Interval
+		switch node.Op {
+		case types.OpFetch:
+			r = lintValue(node, ranges)
+		case types.OpFMA:
+			a, b, c := lint(node.LHS), lint(node.RHS), lint(node.Addend)
+			r = internal.EvalIntervalFMA(a, b, c)
+			lintOverflow(node, r, report, a, b, c)
+		default:
+			lhs, rhs := lint(node.LHS), lint(node.RHS)
+			r = internal.EvalInterval(node.Op, lhs, rhs)
+			switch node.Op {
+			case types.OpDivide:
+				lintDivision(node, rhs, report)
+			case types.OpPower:
+				lintPower(node, lhs, rhs, report)
+			case types.OpIf:
+				lintCondition(node, lhs, report)
+			}
+			lintOverflow(node, r, report, lhs, rhs)
+		}
+		intervals[node] = r
+		return r
+	}
+	lint(expr.ExpandSums().Root)
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Pos.Offset < diags[j].Pos.Offset
+	})
+	return diags
+}
+
+// lintReportFunc adds a Diagnostic about the node.
+type lintReportFunc func(node *Node, severity Severity, format string, args ...interface{})
+
+func lintValue(node *Node, ranges map[string][2]float64) internal.Interval {
+	if node.IsSymbol {
+		if r, ok := ranges[node.Token]; ok {
+			return internal.Interval{Lo: r[0], Hi: r[1]}
+		}
+	}
+	if node.ConstValue.Valid {
+		return internal.PointInterval(node.ConstValue.Float64)
+	}
+	return internal.Interval{Lo: math.Inf(-1), Hi: math.Inf(1)}
+}
+
+func lintDivision(node *Node, divisor internal.Interval, report lintReportFunc) {
+	switch {
+	case divisor.Lo == 0 && divisor.Hi == 0 && !divisor.MaybeNaN:
+		report(node, SeverityError, "division by zero: divisor '%s' is zero", node.RHS)
+	case divisor.Contains(0):
+		report(node, SeverityWarning, "possible division by zero: divisor '%s' is within [%g, %g]", node.RHS, divisor.Lo, divisor.Hi)
+	}
+}
+
+func lintPower(node *Node, base, exponent internal.Interval, report lintReportFunc) {
+	if !(base.Lo < 0) {
+		return
+	}
+	isIntegerExponent := exponent.Lo == exponent.Hi && exponent.Lo == math.Trunc(exponent.Lo)
+	if isIntegerExponent && !exponent.MaybeNaN {
+		return
+	}
+	isFractionalExponent := exponent.Lo == exponent.Hi && exponent.Lo != math.Trunc(exponent.Lo)
+	if base.Hi < 0 && isFractionalExponent && !exponent.MaybeNaN {
+		report(node, SeverityError, "negative base '%s' with fractional exponent '%s'", node.LHS, node.RHS)
+		return
+	}
+	report(node, SeverityWarning, "possible negative base '%s' (within [%g, %g]) with non-integer exponent '%s'", node.LHS, base.Lo, base.Hi, node.RHS)
+}
+
+func lintCondition(node *Node, condition internal.Interval, report lintReportFunc) {
+	switch {
+	case condition.Lo > 0 && !condition.MaybeNaN:
+		report(node, SeverityWarning, "condition '%s' is always true", node.LHS)
+	case !(condition.Hi > 0):
+		report(node, SeverityWarning, "condition '%s' is always false", node.LHS)
+	}
+}
+
+// lintOverflow reports if the result of finite operands could be infinite.
+func lintOverflow(node *Node, r internal.Interval, report lintReportFunc, operands ...internal.Interval) {
+	if r.MaybeNaN || (!math.IsInf(r.Lo, 0) && !math.IsInf(r.Hi, 0)) {
+		// NaN-s are reported by the other checks
+		return
+	}
+	for _, operand := range operands {
+		if math.IsInf(operand.Lo, 0) || math.IsInf(operand.Hi, 0) || operand.MaybeNaN {
+			return
+		}
+	}
+	if r.Lo >= math.MaxFloat64 || r.Hi <= -math.MaxFloat64 {
+		report(node, SeverityError, "overflow: the result is out of [%g, %g]", -math.MaxFloat64, math.MaxFloat64)
+		return
+	}
+	report(node, SeverityWarning, "possible overflow: the result is within [%g, %g]", r.Lo, r.Hi)
+}
@@ -0,0 +1,63 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+func TestExpr_Lint(t *testing.T) {
+	ranges := map[string][2]float64{
+		"x": {-1, 2},
+	}
+	type diag struct {
+		PartIdx  int
+		Severity ir.Severity
+	}
+	for _, testCase := range []struct {
+		Expression string
+		Expected   []diag
+	}{
+		{"x 1 +", nil},
+		{"1 x /", []diag{{2, ir.SeverityWarning}}},
+		{"1 x 2 + /", nil},
+		{"1 x 1 + /", []diag{{4, ir.SeverityWarning}}},
+		{"1 0 /", []diag{{2, ir.SeverityError}}},
+		{"1 y /", []diag{{2, ir.SeverityWarning}}},
+		{"x 2 ^", nil},
+		{"x 0.5 ^", []diag{{2, ir.SeverityWarning}}},
+		{"x 3 + 0.5 ^", nil},
+		{"x 3 - 0.5 ^", []diag{{4, ir.SeverityError}}},
+		{"x 3 - y ^", []diag{{4, ir.SeverityWarning}}},
+		{"x 2 + y if", []diag{{4, ir.SeverityWarning}}},
+		{"x 2 - y if", []diag{{4, ir.SeverityWarning}}},
+		{"x 1 - y if", nil},
+		{"y x if", nil},
+		{"x 1e308 *", []diag{{2, ir.SeverityWarning}}},
+		{"x 3 + 1e308 *", []diag{{4, ir.SeverityError}}},
+		{"y 1e308 *", nil},
+		{"1 x / 0.5 ^ 1 0 / +", []diag{
+			{2, ir.SeverityWarning},
+			{4, ir.SeverityWarning},
+			{7, ir.SeverityError},
+		}},
+	} {
+		expr, err := ir.Parse(testCase.Expression, &variables{})
+		require.NoError(t, err)
+		var actual []diag
+		for _, d := range expr.Lint(ranges) {
+			require.NotEmpty(t, d.Message)
+			actual = append(actual, diag{d.Pos.PartIdx, d.Severity})
+		}
+		require.Equal(t, testCase.Expected, actual, testCase.Expression)
+	}
+
+	t.Run("compensated_sum", func(t *testing.T) {
+		expr, err := ir.Parse("1 x y + x + y - /", &variables{}, ir.CompensatedSummation)
+		require.NoError(t, err)
+		diags := expr.Lint(ranges)
+		require.Len(t, diags, 1)
+		require.Equal(t, "warning at offset 16 (part index 8): possible division by zero: divisor 'x y + x + y -' is within [-Inf, +Inf]", diags[0].String())
+	})
+}
@@ -0,0 +1,241 @@
+package ir
+
+import (
+	"strconv"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// OptimizationLevel defines which optimizations are applied to an expression.
+type OptimizationLevel int
+
+const (
+	// O0 disables optimizations.
+	O0 = OptimizationLevel(iota)
+
+	// O1 enables optimizations which never change the result of the
+	// expression: folding of constant sub-expressions, removal of
+	// identities ("x 0 -", "x 1 *", "x 1 /", "x 1 ^", "x 0 ^") and
+	// elimination of dead branches of "if" and common subexpressions
+	// (see Expr.EliminateCommonSubexpressions).
+	O1
+
+	// O2 enables also optimizations which may change the result
+	// in the last bits or the sign of a zero (like "-ffast-math" does):
+	// reassociation of constants ("x 1 + 2 +" -> "x 3 +"), removal of
+	// "x 0 +", replacement of "x 2 ^" with "x x *" and rebalancing of
+	// chains of additions and multiplications (see Expr.Balance).
+	O2
+)
+
+// String implements fmt.Stringer
+func (level OptimizationLevel) String() string {
+	return "-O" + strconv.Itoa(int(level))
+}
+
+func (level OptimizationLevel) apply(cfg *config) {
+	cfg.OptimizationLevel = level
+}
+
+// Optimize returns an optimized copy of the expression (the nodes of
+// the original expression are not modified).
+func (expr *Expr) Optimize(level OptimizationLevel) *Expr {
+	if level <= O0 {
+		return expr
+	}
+	optimized := &Expr{
+		Source:   expr.Source,
+		Root:     optimizeNode(expr.Root, level, map[*Node]*Node{}),
+		Snapshot: expr.Snapshot,
+	}
+	if level >= O2 {
+		optimized = optimized.Balance()
+	}
+	return optimized.EliminateCommonSubexpressions()
+}
+
+func optimizeNode(node *Node, level OptimizationLevel, optimized map[*Node]*Node) *Node {
+	if node.Op == types.OpFetch {
+		return node
+	}
+	if r, ok := optimized[node]; ok {
+		return r
+	}
+
+	r := simplify(node.mapOperands(func(operand *Node) *Node {
+		return optimizeNode(operand, level, optimized)
+	}), level)
+	optimized[node] = r
+	return r
+}
+
+// simplify applies the optimizations to the node (its children are
+// expected to be already optimized).
+func simplify(node *Node, level OptimizationLevel) *Node {
+	lhs, rhs := node.LHS, node.RHS
+
+	if node.Op == types.OpFMA {
+		if lhs.IsConst() && rhs.IsConst() && node.Addend.IsConst() {
+			return foldedNode(node.Op.Eval3(lhs.ConstValue.Float64, rhs.ConstValue.Float64, node.Addend.ConstValue.Float64), node.Pos)
+		}
+		return node
+	}
+
+	if lhs.IsConst() && rhs.IsConst() {
+		return foldedNode(node.Op.Eval(lhs.ConstValue.Float64, rhs.ConstValue.Float64), node.Pos)
+	}
+
+	switch node.Op {
+	case types.OpMinus:
+		if isConstEqual(rhs, 0) {
+			return lhs
+		}
+	case types.OpMultiply:
+		if isConstEqual(rhs, 1) {
+			return lhs
+		}
+		if isConstEqual(lhs, 1) {
+			return rhs
+		}
+	case types.OpDivide:
+		if isConstEqual(rhs, 1) {
+			return lhs
+		}
+	case types.OpPower:
+		if isConstEqual(rhs, 1) {
+			return lhs
+		}
+		if rhs.IsConst() && rhs.ConstValue.Float64 == 0 {
+			return constNode(1, node.Pos)
+		}
+	case types.OpIf:
+		if lhs.IsConst() {
+			if lhs.ConstValue.Float64 > 0 {
+				return rhs
+			}
+			return constNode(0, node.Pos)
+		}
+		if isConstEqual(rhs, 0) {
+			return rhs
+		}
+	}
+
+	if level < O2 {
+		return node
+	}
+
+	switch node.Op {
+	case types.OpPlus:
+		if rhs.IsConst() && rhs.ConstValue.Float64 == 0 {
+			return lhs
+		}
+		if lhs.IsConst() && lhs.ConstValue.Float64 == 0 {
+			return rhs
+		}
+	case types.OpPower:
+		if isConstEqual(rhs, 2) {
+			return opNode(types.OpMultiply, lhs, lhs, node.Pos)
+		}
+	}
+
+	return reassociate(node)
+}
+
+// reassociate merges constants of chains of additions and multiplications:
+//
+//	"x c0 - c1 +" -> "x c0 neg c1 + +"
+//	"c0 x + c1 +" -> "x c0 c1 + +"
+//	"x c0 * c1 *" -> "x c0 c1 * *"
+func reassociate(node *Node) *Node {
+	op := node.Op
+	lhs, rhs := node.LHS, node.RHS
+	if op == types.OpMinus && rhs.IsConst() {
+		op = types.OpPlus
+		rhs = derivedNode(rhs, -rhs.ConstValue.Float64)
+	}
+	if op != types.OpPlus && op != types.OpMultiply {
+		return node
+	}
+
+	// move the constant to the right side
+	if lhs.IsConst() {
+		lhs, rhs = rhs, lhs
+	}
+	if !rhs.IsConst() {
+		if lhs == node.LHS && rhs == node.RHS && op == node.Op {
+			return node
+		}
+		return opNode(op, lhs, rhs, node.Pos)
+	}
+
+	inner := lhs
+	innerRHS := inner.RHS
+	innerOp := inner.Op
+	if innerOp == types.OpMinus && op == types.OpPlus && innerRHS.IsConst() {
+		innerOp = types.OpPlus
+		innerRHS = derivedNode(innerRHS, -innerRHS.ConstValue.Float64)
+	}
+	if innerOp != op {
+		return opNode(op, lhs, rhs, node.Pos)
+	}
+	innerLHS := inner.LHS
+	if innerLHS.IsConst() {
+		innerLHS, innerRHS = innerRHS, innerLHS
+	}
+	if !innerRHS.IsConst() {
+		return opNode(op, lhs, rhs, node.Pos)
+	}
+
+	merged := foldedNode(op.Eval(innerRHS.ConstValue.Float64, rhs.ConstValue.Float64), rhs.Pos)
+	return simplify(opNode(op, innerLHS, merged, node.Pos), O2)
+}
+
+func isConstEqual(node *Node, v float64) bool {
+	return node.IsConst() && node.ConstValue.Float64 == v && !signbit(node.ConstValue.Float64)
+}
+
+func signbit(v float64) bool {
+	return v < 0 || (v == 0 && 1/v < 0)
+}
+
+func constNode(v float64, pos Position) *Node {
+	return &Node{
+		Op:    types.OpFetch,
+		Token: strconv.FormatFloat(v, 'g', -1, 64),
+		Pos:   pos,
+		ConstValue: types.NullFloat64{
+			Float64: v,
+			Valid:   true,
+		},
+	}
+}
+
+// foldedNode returns a constant node with the value of a sub-expression
+// evaluated by an optimization (see Node.IsFolded).
+func foldedNode(v float64, pos Position) *Node {
+	node := constNode(v, pos)
+	node.IsFolded = true
+	return node
+}
+
+// derivedNode returns a constant node with value `v` computed from
+// the value of constant node `node` (like its negation). It is folded
+// (see Node.IsFolded) unless `node` is a literal written as the shortest
+// representation of its value, because only then the new token is
+// exact as well.
+func derivedNode(node *Node, v float64) *Node {
+	r := constNode(v, node.Pos)
+	r.IsFolded = node.IsFolded || node.IsSymbol ||
+		node.Token != strconv.FormatFloat(node.ConstValue.Float64, 'g', -1, 64)
+	return r
+}
+
+func opNode(op types.Op, lhs, rhs *Node, pos Position) *Node {
+	return &Node{
+		Op:    op,
+		LHS:   lhs,
+		RHS:   rhs,
+		Token: op.String(),
+		Pos:   pos,
+	}
+}
@@ -0,0 +1,137 @@
+package ir_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	calltree "github.com/xaionaro-go/rpn/implementations/calltree"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+type variables struct {
+	X, Y float64
+}
+
+func (r *variables) Resolve(sym string) (types.ValueLoader, error) {
+	switch sym {
+	case "x":
+		return types.FuncValue(func() float64 {
+			return r.X
+		}), nil
+	case "y":
+		return types.FuncValue(func() float64 {
+			return r.Y
+		}), nil
+	}
+	return nil, fmt.Errorf("symbol '%s' not found", sym)
+}
+
+func TestExpr_Optimize(t *testing.T) {
+	for _, testCase := range []struct {
+		Expression string
+		O1         string
+		O2         string
+	}{
+		{"x 1 + 2 +", "x 1 + 2 +", "x 3 +"},
+		{"1 x + 2 +", "1 x + 2 +", "x 3 +"},
+		{"x 1 - 2 +", "x 1 - 2 +", "x 1 +"},
+		{"x 2 * 3 * y +", "x 2 * 3 * y +", "x 6 * y +"},
+		{"x 1 2 + +", "x 3 +", "x 3 +"},
+		{"x 0 +", "x 0 +", "x"},
+		{"x 0 -", "x", "x"},
+		{"x 1 *", "x", "x"},
+		{"1 x *", "x", "x"},
+		{"x 1 /", "x", "x"},
+		{"x 1 ^", "x", "x"},
+		{"x 0 ^", "1", "1"},
+		{"x 2 ^", "x 2 ^", "x x *"},
+		{"0 x if", "0", "0"},
+		{"2 x if", "x", "x"},
+		{"x 0 if", "0", "0"},
+		{"x y if", "x y if", "x y if"},
+		{"x 0 * 1 +", "x 0 * 1 +", "x 0 * 1 +"},
+		{"x 1 1 - -", "x", "x"},
+		{"x -0 -", "x -0 -", "x 0 +"},
+	} {
+		expr, err := ir.Parse(testCase.Expression, &variables{})
+		require.NoError(t, err)
+		require.Equal(t, testCase.Expression, expr.Optimize(ir.O0).Root.String())
+		require.Equal(t, testCase.O1, expr.Optimize(ir.O1).Root.String(), testCase.Expression)
+		require.Equal(t, testCase.O2, expr.Optimize(ir.O2).Root.String(), testCase.Expression)
+		require.Equal(t, testCase.Expression, expr.Root.String(), "the original expression should not be modified")
+	}
+}
+
+func randExpression(randGen *rand.Rand, valDict []string) string {
+	opDict := []string{"+", "-", "*", "/", "^", "if"}
+	parts := []string{valDict[randGen.Intn(len(valDict))]}
+	for i := randGen.Intn(10); i >= 0; i-- {
+		parts = append(parts, valDict[randGen.Intn(len(valDict))])
+		if randGen.Intn(2) == 0 {
+			parts[len(parts)-1], parts[len(parts)-2] = parts[len(parts)-2], parts[len(parts)-1]
+		}
+		parts = append(parts, opDict[randGen.Intn(len(opDict))])
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParse_O1(t *testing.T) {
+	vars := &variables{}
+	randGen := rand.New(rand.NewSource(0))
+	valDict := []string{"x", "y", "0", "-0", "1", "2", "-1", "0.5", "3"}
+	inputs := []float64{0, math.Copysign(0, -1), 1, -1, 0.5, 2, -3.25, 1e10, math.Inf(1), math.NaN()}
+	for i := 0; i < 1000; i++ {
+		expression := randExpression(randGen, valDict)
+		unoptimized, err := calltree.Parse(expression, vars)
+		require.NoError(t, err)
+		optimized, err := calltree.Parse(expression, vars, ir.O1)
+		require.NoError(t, err)
+
+		for j := 0; j < 10; j++ {
+			vars.X = inputs[randGen.Intn(len(inputs))]
+			vars.Y = inputs[randGen.Intn(len(inputs))]
+			description := fmt.Sprintf("'%s' with x=%v y=%v", expression, vars.X, vars.Y)
+
+			expected := unoptimized.Eval()
+			actual := optimized.Eval()
+			if math.IsNaN(expected) {
+				require.True(t, math.IsNaN(actual), description)
+				continue
+			}
+			require.Equal(t, math.Float64bits(expected), math.Float64bits(actual), description)
+		}
+	}
+}
+
+func TestParse_O2(t *testing.T) {
+	// O2 may change the sign of a zero (which in turn may change
+	// the sign of an infinity, and so on), so zeros are avoided here.
+	vars := &variables{}
+	randGen := rand.New(rand.NewSource(0))
+	valDict := []string{"x", "y", "1", "2", "-1", "0.5", "3"}
+	inputs := []float64{1, -1, 0.5, 2, -3.25, 1e10}
+	for i := 0; i < 1000; i++ {
+		expression := randExpression(randGen, valDict)
+		unoptimized, err := calltree.Parse(expression, vars)
+		require.NoError(t, err)
+		optimized, err := calltree.Parse(expression, vars, ir.O2)
+		require.NoError(t, err)
+
+		for j := 0; j < 10; j++ {
+			vars.X = inputs[randGen.Intn(len(inputs))]
+			vars.Y = inputs[randGen.Intn(len(inputs))]
+			description := fmt.Sprintf("'%s' with x=%v y=%v", expression, vars.X, vars.Y)
+
+			expected := unoptimized.Eval()
+			if math.IsNaN(expected) || math.IsInf(expected, 0) {
+				continue
+			}
+			require.InDelta(t, expected, optimized.Eval(), math.Max(1, math.Abs(expected))*1e-12, description)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package ir
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// SnapshotSymbols is an option of Parse which makes the expression load
+// each distinct symbol exactly once per evaluation (see Expr.WithSnapshot).
+var SnapshotSymbols Option = snapshotSymbolsOption{}
+
+type snapshotSymbolsOption struct{}
+
+func (snapshotSymbolsOption) apply(cfg *config) {
+	cfg.SnapshotSymbols = true
+}
+
+// Snapshot contains the values of the symbols of an expression loaded
+// at the beginning of an evaluation.
+type Snapshot struct {
+	// Names are the names of the symbols.
+	Names []string
+
+	// Loaders are the loaders of the values of the symbols.
+	Loaders []types.FuncValue
+
+	// Values are the loaded values of the symbols.
+	Values []float64
+
+	// BigLoaders are the loaders of the values of the symbols with
+	// arbitrary precision (nil for the symbols which are not resolved to
+	// types.BigValueLoader).
+	BigLoaders []func() *big.Float
+
+	// BigValues are the loaded values of the symbols with arbitrary
+	// precision (nil if there is no such value).
+	BigValues []*big.Float
+}
+
+// Load loads the values of all the symbols. It should be called
+// at the beginning of each evaluation of the expression.
+//
+// A symbol with a loader with arbitrary precision is loaded by it only,
+// and its float64 value is the rounded value of the loaded one.
+func (snapshot *Snapshot) Load() {
+	values := snapshot.Values
+	for idx, loader := range snapshot.Loaders {
+		bigLoader := snapshot.BigLoaders[idx]
+		if bigLoader == nil {
+			values[idx] = loader()
+			continue
+		}
+		v := bigLoader()
+		if v == nil {
+			snapshot.BigValues[idx] = nil
+			values[idx] = math.NaN()
+			continue
+		}
+		// the loader could change the returned value later
+		snapshot.BigValues[idx] = new(big.Float).Copy(v)
+		values[idx], _ = v.Float64()
+	}
+}
+
+// WithSnapshot returns a copy of the expression where the symbols
+// are read from Expr.Snapshot instead of being loaded on each occurrence,
+// so a symbol has the same value everywhere in the expression (even if
+// it is being changed concurrently). The nodes of the original expression
+// are not modified.
+//
+// Symbols with the same name are considered to be the same symbol.
+func (expr *Expr) WithSnapshot() *Expr {
+	if expr.Snapshot != nil {
+		return expr
+	}
+	snapshot := &Snapshot{}
+	symbolIndices := map[string]int{}
+	replaced := map[*Node]*Node{}
+	var replace func(node *Node) *Node
+	replace = func(node *Node) *Node {
+		if r, ok := replaced[node]; ok {
+			return r
+		}
+		r := node
+		switch {
+		case node.Op != types.OpFetch:
+			r = node.mapOperands(replace)
+		case node.FuncValue != nil:
+			idx, ok := symbolIndices[node.Token]
+			if !ok {
+				idx = len(snapshot.Loaders)
+				symbolIndices[node.Token] = idx
+				snapshot.Names = append(snapshot.Names, node.Token)
+				snapshot.Loaders = append(snapshot.Loaders, node.FuncValue)
+				snapshot.Values = append(snapshot.Values, 0)
+				snapshot.BigLoaders = append(snapshot.BigLoaders, node.BigFunc)
+				snapshot.BigValues = append(snapshot.BigValues, nil)
+			}
+			newNode := *node
+			newNode.FuncValue = func() float64 {
+				return snapshot.Values[idx]
+			}
+			if node.BigFunc != nil {
+				newNode.BigFunc = func() *big.Float {
+					return snapshot.BigValues[idx]
+				}
+			}
+			r = &newNode
+		}
+		replaced[node] = r
+		return r
+	}
+
+	return &Expr{
+		Source:   expr.Source,
+		Root:     replace(expr.Root),
+		Snapshot: snapshot,
+	}
+}
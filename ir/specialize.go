@@ -0,0 +1,79 @@
+package ir
+
+import (
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// Specialize returns a copy of the expression where the symbols
+// with names from `values` are replaced with constants, and then
+// the constant sub-expressions are folded and dead branches of "if"
+// are eliminated (see O1).
+//
+// Names which are not used in the expression are ignored. The nodes
+// of the original expression are not modified.
+func (expr *Expr) Specialize(values map[string]float64) *Expr {
+	return expr.substitute(values, true).Optimize(O1)
+}
+
+// Substitute returns a copy of the expression where the symbols with
+// names from `values` are replaced with constants, like Specialize does,
+// but nothing is folded (so the expression could be evaluated with
+// a precision higher than float64).
+//
+// Names which are not used in the expression are ignored. The nodes
+// of the original expression are not modified.
+func (expr *Expr) Substitute(values map[string]float64) *Expr {
+	return expr.substitute(values, false)
+}
+
+// substitute replaces the symbols with constants; if `foldSums` is true,
+// then compensated sums of constants are folded.
+func (expr *Expr) substitute(values map[string]float64, foldSums bool) *Expr {
+	replaced := map[*Node]*Node{}
+	var specialize func(node *Node) *Node
+	specialize = func(node *Node) *Node {
+		if r, ok := replaced[node]; ok {
+			return r
+		}
+		r := node
+		switch {
+		case node.Op != types.OpFetch:
+			r = node.mapOperands(specialize)
+		case node.Sum != nil:
+			r = specializeSum(node, specialize, foldSums)
+		case node.IsSymbol:
+			if v, ok := values[node.Token]; ok {
+				r = constNode(v, node.Pos)
+			}
+		}
+		replaced[node] = r
+		return r
+	}
+
+	return &Expr{
+		Source:   expr.Source,
+		Root:     specialize(expr.Root),
+		Snapshot: expr.Snapshot,
+	}
+}
+
+func specializeSum(node *Node, specialize func(*Node) *Node, foldSums bool) *Node {
+	sum := &Sum{
+		Terms:      make([]*Node, len(node.Sum.Terms)),
+		IsNegative: node.Sum.IsNegative,
+	}
+	isChanged, isConst := false, true
+	for idx, term := range node.Sum.Terms {
+		sum.Terms[idx] = specialize(term)
+		isChanged = isChanged || sum.Terms[idx] != term
+		isConst = isConst && sum.Terms[idx].IsConst()
+	}
+	if !isChanged {
+		return node
+	}
+	r := sumNode(sum, node.Pos)
+	if isConst && foldSums {
+		return foldedNode(r.FuncValue(), node.Pos)
+	}
+	return r
+}
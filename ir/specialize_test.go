@@ -0,0 +1,60 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+func TestExpr_Specialize(t *testing.T) {
+	for _, testCase := range []struct {
+		Expression string
+		Values     map[string]float64
+		Expected   string
+	}{
+		{"x y +", nil, "x y +"},
+		{"x y +", map[string]float64{"z": 1}, "x y +"},
+		{"x y +", map[string]float64{"y": 2}, "x 2 +"},
+		{"x 2 * y 3 * +", map[string]float64{"y": 2}, "x 2 * 6 +"},
+		{"x 1 y - * 2 +", map[string]float64{"y": 0}, "x 2 +"},
+		{"y x if", map[string]float64{"y": -1}, "0"},
+		{"y x if", map[string]float64{"y": 1}, "x"},
+		{"x y 3 fma", map[string]float64{"x": 2, "y": 4}, "11"},
+	} {
+		expr, err := ir.Parse(testCase.Expression, &variables{})
+		require.NoError(t, err)
+		require.Equal(t, testCase.Expected, expr.Specialize(testCase.Values).Root.String(), testCase.Expression)
+		require.Equal(t, testCase.Expression, expr.Root.String(), "the original expression should not be modified")
+	}
+
+	t.Run("compensated_sum", func(t *testing.T) {
+		vars := &variables{X: 1}
+		expr, err := ir.Parse("x y + x + y -", vars, ir.CompensatedSummation)
+		require.NoError(t, err)
+
+		specialized := expr.Specialize(map[string]float64{"y": 1e100})
+		require.NotNil(t, specialized.Root.Sum)
+		require.Equal(t, float64(2), specialized.Root.FuncValue())
+
+		specialized = expr.Specialize(map[string]float64{"x": 1, "y": 1e100})
+		require.True(t, specialized.Root.IsConst())
+		require.Equal(t, float64(2), specialized.Root.ConstValue.Float64)
+	})
+}
+
+func TestExpr_Substitute(t *testing.T) {
+	expr, err := ir.Parse("x 0.1 0.2 + * y 1 * +", &variables{})
+	require.NoError(t, err)
+
+	substituted := expr.Substitute(map[string]float64{"y": 2, "z": 3})
+	require.Equal(t, "x 0.1 0.2 + * 2 1 * +", substituted.Root.String())
+	require.Equal(t, "x 0.1 0.2 + * y 1 * +", expr.Root.String(), "the original expression should not be modified")
+	substituted.Root.Walk(func(node *ir.Node) {
+		require.False(t, node.IsFolded, node.Token)
+	})
+
+	specialized := expr.Specialize(map[string]float64{"y": 2})
+	require.True(t, specialized.Root.RHS.IsFolded)
+	require.True(t, specialized.Root.LHS.RHS.IsFolded)
+}
@@ -0,0 +1,233 @@
+package ir
+
+import (
+	"math"
+	"strings"
+
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// CompensatedSummation is an option of Parse which makes chains of
+// additions and subtractions to be evaluated with compensated summation
+// (see Expr.CompensateSums).
+var CompensatedSummation Option = compensatedSummationOption{}
+
+type compensatedSummationOption struct{}
+
+func (compensatedSummationOption) apply(cfg *config) {
+	cfg.CompensatedSummation = true
+}
+
+// Sum is a chain of additions and subtractions which is evaluated with
+// Kahan-Babuska-Neumaier compensated summation.
+type Sum struct {
+	// Terms are the summands in the order of the source expression.
+	Terms []*Node
+
+	// IsNegative defines if the term is subtracted.
+	IsNegative []bool
+}
+
+// CompensateSums returns a copy of the expression where chains of
+// additions and subtractions (of at least 3 terms) are replaced with
+// nodes of compensated summation: such node is a value (Op is
+// types.OpFetch) with FuncValue which evaluates the sum and with
+// Sum which describes it (for implementations which evaluate sums
+// by themselves).
+//
+// The error of a compensated sum does not grow with the amount of terms,
+// for example "1 1e100 + 1 + 1e100 -" is 2 (instead of 0).
+//
+// The nodes of the original expression are not modified.
+func (expr *Expr) CompensateSums() *Expr {
+	refCounts := expr.RefCounts()
+	replaced := map[*Node]*Node{}
+
+	var sum *Sum
+	var collectTerms func(node *Node, isNegative bool)
+	collectTerms = func(node *Node, isNegative bool) {
+		if (node.Op != types.OpPlus && node.Op != types.OpMinus) || refCounts[node] > 1 {
+			sum.Terms = append(sum.Terms, node)
+			sum.IsNegative = append(sum.IsNegative, isNegative)
+			return
+		}
+		collectTerms(node.LHS, isNegative)
+		collectTerms(node.RHS, isNegative != (node.Op == types.OpMinus))
+	}
+
+	var compensate func(node *Node) *Node
+	compensate = func(node *Node) *Node {
+		if node.Op == types.OpFetch {
+			return node
+		}
+		if r, ok := replaced[node]; ok {
+			return r
+		}
+
+		var r *Node
+		switch node.Op {
+		case types.OpPlus, types.OpMinus:
+			sum = &Sum{}
+			collectTerms(node, false)
+			nodeSum := sum
+			for idx, term := range nodeSum.Terms {
+				nodeSum.Terms[idx] = compensate(term)
+			}
+			if len(nodeSum.Terms) >= 3 {
+				r = sumNode(nodeSum, node.Pos)
+				break
+			}
+			r = node
+			if nodeSum.Terms[0] != node.LHS || nodeSum.Terms[1] != node.RHS {
+				newNode := *node
+				newNode.LHS, newNode.RHS = nodeSum.Terms[0], nodeSum.Terms[1]
+				r = &newNode
+			}
+		default:
+			r = node.mapOperands(compensate)
+		}
+		replaced[node] = r
+		return r
+	}
+
+	return &Expr{
+		Source:   expr.Source,
+		Root:     compensate(expr.Root),
+		Snapshot: expr.Snapshot,
+	}
+}
+
+// ExpandSums returns a copy of the expression where compensated sums
+// (see CompensateSums) are replaced back with chains of additions and
+// subtractions. It is useful for evaluators which cannot evaluate sums
+// as a whole. The nodes of the original expression are not modified.
+func (expr *Expr) ExpandSums() *Expr {
+	replaced := map[*Node]*Node{}
+	var expand func(node *Node) *Node
+	expand = func(node *Node) *Node {
+		if r, ok := replaced[node]; ok {
+			return r
+		}
+		var r *Node
+		switch {
+		case node.Sum != nil:
+			r = expand(node.Sum.Terms[0])
+			for idx, term := range node.Sum.Terms[1:] {
+				op := types.OpPlus
+				if node.Sum.IsNegative[idx+1] {
+					op = types.OpMinus
+				}
+				r = opNode(op, r, expand(term), node.Pos)
+			}
+		default:
+			r = node.mapOperands(expand)
+		}
+		replaced[node] = r
+		return r
+	}
+
+	return &Expr{
+		Source:   expr.Source,
+		Root:     expand(expr.Root),
+		Snapshot: expr.Snapshot,
+	}
+}
+
+func sumNode(sum *Sum, pos Position) *Node {
+	terms := make([]types.FuncValue, len(sum.Terms))
+	for idx, term := range sum.Terms {
+		terms[idx] = term.Func()
+		if sum.IsNegative[idx] {
+			fn := terms[idx]
+			terms[idx] = func() float64 {
+				return -fn()
+			}
+		}
+	}
+
+	return &Node{
+		Op:    types.OpFetch,
+		Token: sum.String(),
+		Pos:   pos,
+		Sum:   sum,
+		FuncValue: func() float64 {
+			total := terms[0]()
+			compensation := float64(0)
+			for _, term := range terms[1:] {
+				v := term()
+				t := total + v
+				if math.Abs(total) >= math.Abs(v) {
+					compensation += (total - t) + v
+				} else {
+					compensation += (v - t) + total
+				}
+				total = t
+			}
+			if math.IsInf(total, 0) || math.IsNaN(total) {
+				return total
+			}
+			return total + compensation
+		},
+	}
+}
+
+// String implements fmt.Stringer. It returns the sum in
+// Reverse Polish Notation.
+func (sum *Sum) String() string {
+	// the first term is never negative (it is the left-most operand
+	// of the chain)
+	parts := []string{sum.Terms[0].String()}
+	for idx, term := range sum.Terms[1:] {
+		parts = append(parts, term.String())
+		if sum.IsNegative[idx+1] {
+			parts = append(parts, "-")
+		} else {
+			parts = append(parts, "+")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Func returns a function which evaluates the (sub-)expression
+// of the node.
+func (node *Node) Func() types.FuncValue {
+	if node.Op == types.OpFetch {
+		if node.ConstValue.Valid {
+			v := node.ConstValue.Float64
+			return func() float64 {
+				return v
+			}
+		}
+		return node.FuncValue
+	}
+
+	op := node.Op
+	lhs, rhs := node.LHS.Func(), node.RHS.Func()
+	switch op {
+	case types.OpFMA:
+		addend := node.Addend.Func()
+		return func() float64 {
+			return math.FMA(lhs(), rhs(), addend())
+		}
+	case types.OpPlus:
+		return func() float64 {
+			return lhs() + rhs()
+		}
+	case types.OpMinus:
+		return func() float64 {
+			return lhs() - rhs()
+		}
+	case types.OpMultiply:
+		return func() float64 {
+			return lhs() * rhs()
+		}
+	case types.OpDivide:
+		return func() float64 {
+			return lhs() / rhs()
+		}
+	default:
+		return func() float64 {
+			return op.Eval(lhs(), rhs())
+		}
+	}
+}
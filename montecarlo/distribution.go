@@ -0,0 +1,68 @@
+package montecarlo
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Distribution is a probability distribution of a symbol.
+type Distribution interface {
+	// Sample returns a random value from the distribution (using only
+	// `rand` as the source of randomness, to be deterministic).
+	Sample(rand *rand.Rand) float64
+}
+
+var (
+	_ Distribution = Uniform{}
+	_ Distribution = Normal{}
+	_ Distribution = LogNormal{}
+	_ Distribution = Empirical{}
+)
+
+// Uniform is the continuous uniform distribution on [Min, Max).
+type Uniform struct {
+	Min float64
+	Max float64
+}
+
+// Sample implements Distribution
+func (d Uniform) Sample(rand *rand.Rand) float64 {
+	return d.Min + rand.Float64()*(d.Max-d.Min)
+}
+
+// Normal is the normal (Gaussian) distribution.
+type Normal struct {
+	Mean   float64
+	StdDev float64
+}
+
+// Sample implements Distribution
+func (d Normal) Sample(rand *rand.Rand) float64 {
+	return d.Mean + d.StdDev*rand.NormFloat64()
+}
+
+// LogNormal is the distribution of a value which logarithm has
+// the normal distribution with mean Mu and standard deviation Sigma.
+type LogNormal struct {
+	Mu    float64
+	Sigma float64
+}
+
+// Sample implements Distribution
+func (d LogNormal) Sample(rand *rand.Rand) float64 {
+	return math.Exp(d.Mu + d.Sigma*rand.NormFloat64())
+}
+
+// Empirical is the distribution of observed values: each sample is
+// one of Samples chosen with equal probability.
+type Empirical struct {
+	Samples []float64
+}
+
+// Sample implements Distribution. It returns NaN if there are no Samples.
+func (d Empirical) Sample(rand *rand.Rand) float64 {
+	if len(d.Samples) == 0 {
+		return math.NaN()
+	}
+	return d.Samples[rand.Intn(len(d.Samples))]
+}
@@ -0,0 +1,128 @@
+package montecarlo_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn"
+	exprtree "github.com/xaionaro-go/rpn/implementations/exprtree"
+	"github.com/xaionaro-go/rpn/montecarlo"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+type constants struct{}
+
+func (constants) Resolve(sym string) (types.ValueLoader, error) {
+	switch sym {
+	case "k":
+		return types.StaticValue(10), nil
+	}
+	return nil, fmt.Errorf("symbol '%s' not found", sym)
+}
+
+func TestRunner_Run(t *testing.T) {
+	runner := montecarlo.NewRunner(0, constants{})
+	runner.SetDistribution("x", montecarlo.Normal{Mean: 1, StdDev: 2})
+	runner.SetDistribution("y", montecarlo.Uniform{Min: 0, Max: 1})
+	runner.SetDistribution("z", montecarlo.LogNormal{Mu: 0, Sigma: 0.5})
+	runner.SetDistribution("w", montecarlo.Empirical{Samples: []float64{1, 2, 3, 4}})
+
+	for expression, expected := range map[string]struct {
+		Mean     float64
+		Variance float64
+	}{
+		"x y +":   {1.5, 4 + 1./12},
+		"x x -":   {0, 0},
+		"y k *":   {5, 100. / 12},
+		"z":       {math.Exp(0.125), (math.Exp(0.25) - 1) * math.Exp(0.25)},
+		"w 2 *":   {5, 5},
+		"x 2 ^":   {5, 2*16 + 4*1*4},
+		"y 0.5 -": {0, 1. / 12},
+	} {
+		expr, err := rpn.Parse(expression, runner)
+		require.NoError(t, err)
+		result, err := runner.Run(expr, 100000)
+		require.NoError(t, err)
+		require.Len(t, result.Samples, 100000)
+		require.Zero(t, result.NaNCount)
+		require.InDelta(t, expected.Mean, result.Mean, 0.03*math.Max(1, math.Sqrt(expected.Variance)), expression)
+		require.InDelta(t, expected.Variance, result.Variance, 0.03*math.Max(1, expected.Variance), expression)
+
+		again, err := runner.Run(expr, 100000)
+		require.NoError(t, err)
+		require.Equal(t, result, again, "a run should be deterministic")
+	}
+
+	t.Run("any_implementation", func(t *testing.T) {
+		expr0, err := rpn.Parse("x y * z +", runner)
+		require.NoError(t, err)
+		expr1, err := exprtree.Parse("x y * z +", runner)
+		require.NoError(t, err)
+		expr1.EnableMemoization(true)
+
+		result0, err := runner.Run(expr0, 1000)
+		require.NoError(t, err)
+		result1, err := runner.Run(expr1, 1000)
+		require.NoError(t, err)
+		require.Equal(t, result0, result1)
+		require.True(t, expr1.IsUpdateCache, "memoization should be restored")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := rpn.Parse("x q +", runner)
+		require.Error(t, err)
+
+		expr, err := rpn.Parse("x", runner)
+		require.NoError(t, err)
+		_, err = runner.Run(expr, 0)
+		require.Error(t, err)
+
+		parsingRunner := montecarlo.NewRunner(0, nil)
+		parsingRunner.SetDistribution("x", montecarlo.Uniform{Min: 0, Max: 1})
+		expr, err = rpn.Parse("x", parsingRunner)
+		require.NoError(t, err)
+		otherRunner := montecarlo.NewRunner(0, nil)
+		otherRunner.SetDistribution("x", montecarlo.Uniform{Min: 0, Max: 1})
+		_, err = otherRunner.Run(expr, 1)
+		require.Error(t, err, "parsed with another resolver")
+
+		lateRunner := montecarlo.NewRunner(0, constants{})
+		lateRunner.SetDistribution("x", montecarlo.Uniform{Min: 0, Max: 1})
+		expr, err = rpn.Parse("x k +", lateRunner)
+		require.NoError(t, err)
+		lateRunner.SetDistribution("k", montecarlo.Uniform{Min: 0, Max: 1})
+		_, err = lateRunner.Run(expr, 1)
+		require.Error(t, err, "the distribution is set after parsing")
+	})
+}
+
+func TestResult(t *testing.T) {
+	runner := montecarlo.NewRunner(1, nil)
+	runner.SetDistribution("x", montecarlo.Empirical{Samples: []float64{0, 1, 2, 3, 4}})
+	expr, err := rpn.Parse("x x 1 - x 1 - / if", runner)
+	require.NoError(t, err)
+	result, err := runner.Run(expr, 1000)
+	require.NoError(t, err)
+
+	// x=0 gives 0, x=1 gives NaN ("0 0 /") and the others give 1
+	require.NotZero(t, result.NaNCount)
+	require.Equal(t, 1000, result.NaNCount+len(result.Samples))
+	require.Equal(t, float64(0), result.Quantile(0))
+	require.Equal(t, float64(1), result.Quantile(1))
+	require.Equal(t, float64(1), result.Quantile(0.5))
+	require.True(t, math.IsNaN(result.Quantile(1.5)))
+
+	histogram := result.Histogram(4)
+	require.Len(t, histogram, 4)
+	require.Equal(t, float64(0), histogram[0].Lo)
+	require.Equal(t, float64(1), histogram[3].Hi)
+	require.Zero(t, histogram[1].Count)
+	total := 0
+	for _, bin := range histogram {
+		total += bin.Count
+	}
+	require.Equal(t, len(result.Samples), total)
+	require.Nil(t, result.Histogram(0))
+}
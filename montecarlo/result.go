@@ -0,0 +1,114 @@
+package montecarlo
+
+import (
+	"math"
+	"sort"
+)
+
+// Result is the distribution of the results of an expression
+// (see Runner.Run).
+type Result struct {
+	// Samples are the results (except NaN-s) in ascending order.
+	Samples []float64
+
+	// NaNCount is the amount of results which are NaN (they are
+	// not taken into account by the statistics).
+	NaNCount int
+
+	// Mean is the mean of Samples.
+	Mean float64
+
+	// Variance is the unbiased sample variance of Samples.
+	Variance float64
+}
+
+// HistogramBin is a bin of a histogram: the amount of samples
+// within [Lo, Hi) (or [Lo, Hi] for the last bin).
+type HistogramBin struct {
+	Lo    float64
+	Hi    float64
+	Count int
+}
+
+func newResult(values []float64) *Result {
+	result := &Result{
+		Samples: values[:0],
+	}
+	var m2 float64
+	for _, v := range values {
+		if math.IsNaN(v) {
+			result.NaNCount++
+			continue
+		}
+		result.Samples = append(result.Samples, v)
+		// see Welford's online algorithm
+		delta := v - result.Mean
+		result.Mean += delta / float64(len(result.Samples))
+		m2 += delta * (v - result.Mean)
+	}
+	sort.Float64s(result.Samples)
+	switch len(result.Samples) {
+	case 0:
+		result.Mean = math.NaN()
+		result.Variance = math.NaN()
+	case 1:
+		result.Variance = 0
+	default:
+		result.Variance = m2 / float64(len(result.Samples)-1)
+	}
+	return result
+}
+
+// StdDev returns the standard deviation of the samples.
+func (result *Result) StdDev() float64 {
+	return math.Sqrt(result.Variance)
+}
+
+// Quantile returns the q-quantile of the samples (q is within [0, 1]),
+// linearly interpolated between the closest samples. It returns NaN if
+// there are no samples or q is out of [0, 1].
+func (result *Result) Quantile(q float64) float64 {
+	if len(result.Samples) == 0 || !(q >= 0 && q <= 1) {
+		return math.NaN()
+	}
+	pos := q * float64(len(result.Samples)-1)
+	idx := int(pos)
+	if idx == len(result.Samples)-1 {
+		return result.Samples[idx]
+	}
+	frac := pos - float64(idx)
+	return result.Samples[idx] + frac*(result.Samples[idx+1]-result.Samples[idx])
+}
+
+// Histogram returns a histogram of the samples with `bins` bins of
+// equal width from the minimal sample to the maximal one. It returns
+// nil if there are no samples, some samples are infinite or `bins` is
+// not positive.
+func (result *Result) Histogram(bins int) []HistogramBin {
+	if len(result.Samples) == 0 || bins <= 0 {
+		return nil
+	}
+	min, max := result.Samples[0], result.Samples[len(result.Samples)-1]
+	if math.IsInf(min, 0) || math.IsInf(max, 0) {
+		return nil
+	}
+	width := (max - min) / float64(bins)
+	histogram := make([]HistogramBin, bins)
+	for idx := range histogram {
+		histogram[idx].Lo = min + float64(idx)*width
+		histogram[idx].Hi = min + float64(idx+1)*width
+	}
+	histogram[bins-1].Hi = max
+
+	for _, v := range result.Samples {
+		idx := bins - 1
+		if width > 0 {
+			idx = int((v - min) / width)
+		}
+		if idx >= bins {
+			idx = bins - 1
+		}
+		histogram[idx].Count++
+	}
+	return histogram
+}
@@ -0,0 +1,107 @@
+// Package montecarlo evaluates expressions with random values of
+// the symbols (with the given distributions) to get the distribution
+// of the result.
+package montecarlo
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/xaionaro-go/rpn/internal/numeric"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+var (
+	_ types.SymbolResolver = &Runner{}
+)
+
+// Runner is a seeded Monte Carlo runner of expressions parsed with it
+// (as the types.SymbolResolver, after the distributions are set by
+// SetDistribution). It samples only the symbols it resolved: an
+// expression parsed with another resolver, or a distribution set after
+// its symbol is resolved, is reported as an error by Run.
+//
+// Runner is not safe for concurrent use.
+type Runner struct {
+	seed          int64
+	distributions map[string]Distribution
+	variables     *numeric.Resolver
+}
+
+// NewRunner returns a new Runner. Each Run is deterministic: it starts
+// from the same `seed`. The symbols without distributions are resolved
+// by `resolver` (which could be nil if all the symbols have distributions).
+func NewRunner(seed int64, resolver types.SymbolResolver) *Runner {
+	return &Runner{
+		seed:          seed,
+		distributions: map[string]Distribution{},
+		variables:     numeric.NewResolver(resolver, "a random variable"),
+	}
+}
+
+// SetDistribution sets the distribution of symbol `symbol`. It should
+// be called before an expression using the symbol is parsed.
+func (r *Runner) SetDistribution(symbol string, distribution Distribution) {
+	r.distributions[symbol] = distribution
+	r.variables.Add(symbol, 0)
+}
+
+// Resolve implements types.SymbolResolver
+func (r *Runner) Resolve(symbol string) (types.ValueLoader, error) {
+	return r.variables.Resolve(symbol)
+}
+
+// Run evaluates the expression `iterations` times, each time with new
+// random values of the symbols, and returns the distribution of
+// the results. The expression should be parsed with the Runner as
+// the symbol resolver.
+func (r *Runner) Run(expr types.Expr, iterations int) (*Result, error) {
+	if iterations <= 0 {
+		return nil, fmt.Errorf("the amount of iterations should be positive, but it is %d", iterations)
+	}
+	if err := r.checkResolved(); err != nil {
+		return nil, err
+	}
+
+	defer numeric.DisableMemoization(expr)()
+
+	// the symbols are sampled in the same order each time
+	symbols := make([]string, 0, len(r.distributions))
+	values := make(map[string]*float64, len(r.distributions))
+	for symbol := range r.distributions {
+		symbols = append(symbols, symbol)
+		values[symbol], _ = r.variables.Value(symbol)
+	}
+	sort.Strings(symbols)
+
+	randGen := rand.New(rand.NewSource(r.seed))
+	samples := make([]float64, 0, iterations)
+	for i := 0; i < iterations; i++ {
+		for _, symbol := range symbols {
+			*values[symbol] = r.distributions[symbol].Sample(randGen)
+		}
+		samples = append(samples, expr.Eval())
+	}
+	return newResult(samples), nil
+}
+
+// checkResolved returns an error if the random variables are not
+// resolved by the Runner (so the expressions do not depend on their
+// samples).
+func (r *Runner) checkResolved() error {
+	if len(r.distributions) == 0 {
+		return fmt.Errorf("no distributions are set")
+	}
+	isResolved := false
+	for symbol := range r.distributions {
+		if r.variables.IsResolvedByFallback(symbol) && !r.variables.IsResolved(symbol) {
+			return fmt.Errorf("the distribution of symbol '%s' is set after the symbol is resolved: the expression should be parsed after SetDistribution", symbol)
+		}
+		isResolved = isResolved || r.variables.IsResolved(symbol)
+	}
+	if !isResolved {
+		return fmt.Errorf("none of the random variables is resolved by the Runner: the expression should be parsed with the Runner as the symbol resolver")
+	}
+	return nil
+}
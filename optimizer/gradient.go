@@ -0,0 +1,104 @@
+package optimizer
+
+import (
+	"math"
+
+	"github.com/xaionaro-go/rpn/internal/numeric"
+)
+
+// gradient minimizes the problem by the projected gradient descent
+// with a backtracking line search (with the Armijo condition).
+func (optimizer *Optimizer) gradient(p *problem, start []float64) ([]float64, int, bool) {
+	const (
+		armijo      = 1e-4
+		maxHalvings = 60
+	)
+	n := len(start)
+
+	point := append([]float64{}, start...)
+	cost := p.Cost(point)
+	grad := make([]float64, n)
+	candidate := make([]float64, n)
+
+	// the step is kept between iterations, the initial one moves
+	// the point by a tenth of the bounds
+	stepSize := math.NaN()
+	for iteration := 1; iteration <= optimizer.MaxIterations; iteration++ {
+		p.Gradient(point, grad)
+		if math.IsNaN(stepSize) {
+			stepSize = p.initialStepSize(grad)
+		}
+
+		isDecreased := false
+		for halving := 0; halving < maxHalvings; halving++ {
+			var decrease float64
+			for idx := range candidate {
+				candidate[idx] = point[idx] - stepSize*grad[idx]
+			}
+			p.Clamp(candidate)
+			for idx := range candidate {
+				decrease += grad[idx] * (point[idx] - candidate[idx])
+			}
+			candidateCost := p.Cost(candidate)
+			if decrease > 0 && candidateCost < cost && candidateCost <= cost-armijo*decrease {
+				isDecreased = true
+				cost = candidateCost
+				break
+			}
+			stepSize /= 2
+		}
+		if !isDecreased {
+			// no descent direction within the bounds: a stationary point
+			// (or the derivatives are too inaccurate to improve it)
+			return point, iteration, true
+		}
+
+		distance := p.Distance(point, candidate)
+		copy(point, candidate)
+		if distance <= optimizer.Tolerance {
+			return point, iteration, true
+		}
+		stepSize *= 2
+	}
+	return point, optimizer.MaxIterations, false
+}
+
+// Gradient stores the gradient of the cost at the point (by central
+// finite differences, one-sided at the bounds) to `grad`.
+func (p *problem) Gradient(point, grad []float64) {
+	shifted := append([]float64{}, point...)
+	for idx := range point {
+		h := numeric.DifferenceStep(point[idx])
+		lo := math.Max(p.Lo[idx], point[idx]-h)
+		hi := math.Min(p.Hi[idx], point[idx]+h)
+		if lo == hi {
+			grad[idx] = 0
+			continue
+		}
+		shifted[idx] = hi
+		fHi := p.Cost(shifted)
+		shifted[idx] = lo
+		fLo := p.Cost(shifted)
+		shifted[idx] = point[idx]
+		grad[idx] = (fHi - fLo) / (hi - lo)
+		if math.IsNaN(grad[idx]) || math.IsInf(grad[idx], 0) {
+			grad[idx] = 0
+		}
+	}
+}
+
+// initialStepSize returns the step size which moves the point by
+// a tenth of the bounds along the gradient.
+func (p *problem) initialStepSize(grad []float64) float64 {
+	stepSize := math.Inf(1)
+	for idx, g := range grad {
+		if g == 0 {
+			continue
+		}
+		stepSize = math.Min(stepSize, (p.Hi[idx]-p.Lo[idx])/10/math.Abs(g))
+	}
+	if math.IsInf(stepSize, 0) || stepSize == 0 {
+		return 1
+	}
+	return stepSize
+}
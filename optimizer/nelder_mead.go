@@ -0,0 +1,123 @@
+package optimizer
+
+import (
+	"sort"
+)
+
+// vertex is a vertex of a simplex.
+type vertex struct {
+	Point []float64
+	Cost  float64
+}
+
+// nelderMead minimizes the problem by the Nelder-Mead method where
+// the points are clamped to the bounds. The method is restarted from
+// the found point until it is not improved, because the simplex may
+// degenerate (especially at the bounds).
+func (optimizer *Optimizer) nelderMead(p *problem, start []float64) ([]float64, int, bool) {
+	point, cost, iterations, isConverged := optimizer.nelderMeadOnce(p, start, optimizer.MaxIterations)
+	for isConverged {
+		restartPoint, restartCost, restartIterations, isRestartConverged := optimizer.nelderMeadOnce(p, point, optimizer.MaxIterations-iterations)
+		iterations += restartIterations
+		if !(restartCost < cost) {
+			break
+		}
+		point, cost, isConverged = restartPoint, restartCost, isRestartConverged
+	}
+	return point, iterations, isConverged
+}
+
+func (optimizer *Optimizer) nelderMeadOnce(p *problem, start []float64, maxIterations int) ([]float64, float64, int, bool) {
+	const (
+		reflection  = 1
+		expansion   = 2
+		contraction = 0.5
+		shrinkage   = 0.5
+	)
+	n := len(start)
+
+	newVertex := func(point []float64) vertex {
+		p.Clamp(point)
+		return vertex{Point: point, Cost: p.Cost(point)}
+	}
+	// combine returns "a + k*(a-b)"
+	combine := func(a, b []float64, k float64) vertex {
+		point := make([]float64, n)
+		for idx := range point {
+			point[idx] = a[idx] + k*(a[idx]-b[idx])
+		}
+		return newVertex(point)
+	}
+
+	simplex := make([]vertex, n+1)
+	simplex[0] = newVertex(append([]float64{}, start...))
+	for idx := 0; idx < n; idx++ {
+		point := append([]float64{}, start...)
+		step := (p.Hi[idx] - p.Lo[idx]) / 10
+		if point[idx]+step > p.Hi[idx] {
+			step = -step
+		}
+		point[idx] += step
+		simplex[idx+1] = newVertex(point)
+	}
+
+	centroid := make([]float64, n)
+	for iteration := 1; iteration <= maxIterations; iteration++ {
+		sort.SliceStable(simplex, func(i, j int) bool {
+			return simplex[i].Cost < simplex[j].Cost
+		})
+		best, worst := simplex[0], simplex[n]
+
+		var diameter float64
+		for _, v := range simplex[1:] {
+			if d := p.Distance(best.Point, v.Point); d > diameter {
+				diameter = d
+			}
+		}
+		if diameter <= optimizer.Tolerance {
+			return best.Point, best.Cost, iteration, true
+		}
+
+		for idx := range centroid {
+			centroid[idx] = 0
+			for _, v := range simplex[:n] {
+				centroid[idx] += v.Point[idx]
+			}
+			centroid[idx] /= float64(n)
+		}
+
+		reflected := combine(centroid, worst.Point, reflection)
+		switch {
+		case reflected.Cost < best.Cost:
+			if expanded := combine(centroid, worst.Point, expansion); expanded.Cost < reflected.Cost {
+				simplex[n] = expanded
+			} else {
+				simplex[n] = reflected
+			}
+			continue
+		case reflected.Cost < simplex[n-1].Cost:
+			simplex[n] = reflected
+			continue
+		}
+
+		var contracted vertex
+		if reflected.Cost < worst.Cost {
+			contracted = combine(centroid, reflected.Point, -contraction)
+		} else {
+			contracted = combine(centroid, worst.Point, -contraction)
+		}
+		if contracted.Cost < worst.Cost && contracted.Cost <= reflected.Cost {
+			simplex[n] = contracted
+			continue
+		}
+
+		for idx := 1; idx <= n; idx++ {
+			simplex[idx] = combine(best.Point, simplex[idx].Point, -shrinkage)
+		}
+	}
+
+	sort.SliceStable(simplex, func(i, j int) bool {
+		return simplex[i].Cost < simplex[j].Cost
+	})
+	return simplex[0].Point, simplex[0].Cost, maxIterations, false
+}
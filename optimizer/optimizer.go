@@ -0,0 +1,256 @@
+// Package optimizer finds the values of bounded symbols which minimize
+// or maximize an expression.
+package optimizer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/xaionaro-go/rpn/internal/numeric"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+var (
+	_ types.SymbolResolver = &Optimizer{}
+)
+
+// Method is an optimization method.
+type Method uint8
+
+const (
+	// MethodNelderMead is the Nelder-Mead (downhill simplex) method. It
+	// does not use derivatives, so it works for non-smooth expressions
+	// as well.
+	MethodNelderMead = Method(iota)
+
+	// MethodGradient is the projected gradient descent with derivatives
+	// by finite differences and a backtracking line search. It is
+	// usually faster for smooth expressions.
+	MethodGradient
+)
+
+// String implements fmt.Stringer
+func (method Method) String() string {
+	switch method {
+	case MethodNelderMead:
+		return "nelder_mead"
+	case MethodGradient:
+		return "gradient"
+	default:
+		return fmt.Sprintf("unknown_method_%d", method)
+	}
+}
+
+// Status is the result of an optimization.
+type Status = numeric.Status
+
+const (
+	// StatusConverged means the optimum is found within the tolerance.
+	StatusConverged = numeric.StatusConverged
+
+	// StatusMaxIterations means the optimum is not found within
+	// MaxIterations iterations (Result.Point is the best found point).
+	StatusMaxIterations = numeric.StatusMaxIterations
+)
+
+// Result is the result of Minimize or Maximize.
+type Result struct {
+	// Point is the found values of the symbols.
+	Point map[string]float64
+
+	// Value is the value of the expression at Point.
+	Value float64
+
+	// Iterations is the amount of iterations of the method.
+	Iterations int
+
+	// Status defines if the method converged.
+	Status Status
+}
+
+// Optimizer finds optimums of expressions parsed with it (as
+// the types.SymbolResolver) over its variables, so it works with any
+// implementation.
+//
+// Optimizer is not safe for concurrent use.
+type Optimizer struct {
+	// Tolerance is the tolerance of the optimum relative to the widths
+	// of the bounds.
+	Tolerance float64
+
+	// MaxIterations is the maximal amount of iterations of a method.
+	MaxIterations int
+
+	variables *numeric.Resolver
+}
+
+// New returns a new Optimizer for the variables `variables`. The other
+// symbols are resolved by `resolver` (which could be nil if there are no
+// other symbols).
+func New(resolver types.SymbolResolver, variables ...string) *Optimizer {
+	optimizer := &Optimizer{
+		Tolerance:     1e-9,
+		MaxIterations: 10000,
+		variables:     numeric.NewResolver(resolver, "a variable"),
+	}
+	for _, symbol := range variables {
+		optimizer.variables.Add(symbol, math.NaN())
+	}
+	return optimizer
+}
+
+// Resolve implements types.SymbolResolver
+func (optimizer *Optimizer) Resolve(symbol string) (types.ValueLoader, error) {
+	return optimizer.variables.Resolve(symbol)
+}
+
+// Set sets the value of a variable. It is used by the expressions when
+// the variable is not optimized, and it is the starting point of
+// an optimization (if it is within the bounds).
+func (optimizer *Optimizer) Set(symbol string, value float64) error {
+	return optimizer.variables.Set(symbol, value)
+}
+
+// Minimize finds values of the variables within `bounds` (the lower and
+// the upper bound of each optimized variable) which minimize the
+// expression. The search starts from the current values of the variables
+// (see Set), or from the centers of the bounds if the values are not
+// within the bounds. NaN values of the expression are considered to be
+// worse than any other value.
+//
+// The found optimum is local (unless the expression is convex). After
+// the search the variables keep the values of the found point.
+//
+// It returns an error if none of the optimized variables is resolved by
+// the Optimizer (the expression is parsed with another resolver).
+func (optimizer *Optimizer) Minimize(expr types.Expr, method Method, bounds map[string][2]float64) (Result, error) {
+	return optimizer.optimize(expr, method, bounds, 1)
+}
+
+// Maximize is the same as Minimize, but it maximizes the expression.
+func (optimizer *Optimizer) Maximize(expr types.Expr, method Method, bounds map[string][2]float64) (Result, error) {
+	return optimizer.optimize(expr, method, bounds, -1)
+}
+
+func (optimizer *Optimizer) optimize(expr types.Expr, method Method, bounds map[string][2]float64, sign float64) (Result, error) {
+	if len(bounds) == 0 {
+		return Result{}, fmt.Errorf("no variables to optimize")
+	}
+	symbols := make([]string, 0, len(bounds))
+	for symbol := range bounds {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	p := &problem{
+		Values: make([]*float64, len(symbols)),
+		Lo:     make([]float64, len(symbols)),
+		Hi:     make([]float64, len(symbols)),
+		Expr:   expr,
+		Sign:   sign,
+	}
+	start := make([]float64, len(symbols))
+	isResolved := false
+	for idx, symbol := range symbols {
+		value, err := optimizer.variables.Value(symbol)
+		if err != nil {
+			return Result{}, err
+		}
+		isResolved = isResolved || optimizer.variables.IsResolved(symbol)
+		lo, hi := bounds[symbol][0], bounds[symbol][1]
+		if !(lo <= hi) || math.IsInf(lo, 0) || math.IsInf(hi, 0) {
+			return Result{}, fmt.Errorf("invalid bounds [%g, %g] of symbol '%s'", lo, hi, symbol)
+		}
+		p.Values[idx], p.Lo[idx], p.Hi[idx] = value, lo, hi
+		start[idx] = *value
+		if !(start[idx] >= lo && start[idx] <= hi) {
+			start[idx] = lo + (hi-lo)/2
+		}
+	}
+	if !isResolved {
+		// otherwise the expression does not depend on the variables and
+		// any point is the optimum
+		return Result{}, fmt.Errorf("none of the variables %v is resolved by the Optimizer: the expression should be parsed with the Optimizer as the symbol resolver", symbols)
+	}
+
+	defer numeric.DisableMemoization(expr)()
+
+	var (
+		point       []float64
+		iterations  int
+		isConverged bool
+	)
+	switch method {
+	case MethodNelderMead:
+		point, iterations, isConverged = optimizer.nelderMead(p, start)
+	case MethodGradient:
+		point, iterations, isConverged = optimizer.gradient(p, start)
+	default:
+		return Result{}, fmt.Errorf("unknown method %s", method)
+	}
+
+	result := Result{
+		Point:      make(map[string]float64, len(symbols)),
+		Value:      p.Eval(point) * sign,
+		Iterations: iterations,
+		Status:     StatusMaxIterations,
+	}
+	if isConverged {
+		result.Status = StatusConverged
+	}
+	for idx, symbol := range symbols {
+		result.Point[symbol] = point[idx]
+	}
+	return result, nil
+}
+
+// problem is a minimization problem of an expression.
+type problem struct {
+	Values []*float64
+	Lo     []float64
+	Hi     []float64
+	Expr   types.Expr
+
+	// Sign is -1 to maximize the expression
+	Sign float64
+}
+
+// Eval sets the values of the variables and returns the value of
+// the expression multiplied by Sign.
+func (p *problem) Eval(point []float64) float64 {
+	for idx, value := range p.Values {
+		*value = point[idx]
+	}
+	return p.Expr.Eval() * p.Sign
+}
+
+// Cost returns the value to minimize at the point (NaN is the worst).
+func (p *problem) Cost(point []float64) float64 {
+	v := p.Eval(point)
+	if math.IsNaN(v) {
+		return math.Inf(1)
+	}
+	return v
+}
+
+// Clamp moves the point to the bounds.
+func (p *problem) Clamp(point []float64) {
+	for idx := range point {
+		point[idx] = math.Max(p.Lo[idx], math.Min(p.Hi[idx], point[idx]))
+	}
+}
+
+// Distance returns the maximal distance between the coordinates of
+// the points relative to the widths of the bounds.
+func (p *problem) Distance(a, b []float64) float64 {
+	var r float64
+	for idx := range a {
+		width := p.Hi[idx] - p.Lo[idx]
+		if width == 0 {
+			continue
+		}
+		r = math.Max(r, math.Abs(a[idx]-b[idx])/width)
+	}
+	return r
+}
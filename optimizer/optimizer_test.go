@@ -0,0 +1,105 @@
+package optimizer_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn"
+	exprtree "github.com/xaionaro-go/rpn/implementations/exprtree"
+	"github.com/xaionaro-go/rpn/optimizer"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+type constants struct{}
+
+func (constants) Resolve(sym string) (types.ValueLoader, error) {
+	switch sym {
+	case "a":
+		return types.StaticValue(1), nil
+	case "b":
+		return types.FuncValue(func() float64 {
+			return 100
+		}), nil
+	}
+	return nil, fmt.Errorf("symbol '%s' not found", sym)
+}
+
+func TestOptimizer(t *testing.T) {
+	parsers := map[string]func(string, types.SymbolResolver) (types.Expr, error){
+		"default": func(s string, r types.SymbolResolver) (types.Expr, error) {
+			return rpn.Parse(s, r)
+		},
+		"exprtree": func(s string, r types.SymbolResolver) (types.Expr, error) {
+			return exprtree.Parse(s, r)
+		},
+	}
+	bounds := map[string][2]float64{
+		"x": {-2, 2},
+		"y": {-1, 3},
+	}
+	for parserName, parse := range parsers {
+		for _, method := range []optimizer.Method{optimizer.MethodNelderMead, optimizer.MethodGradient} {
+			t.Run(parserName+"/"+method.String(), func(t *testing.T) {
+				opt := optimizer.New(constants{}, "x", "y", "z")
+				for _, testCase := range []struct {
+					Expression string
+					IsMaximize bool
+					Point      map[string]float64
+					Value      float64
+					Delta      float64
+
+					// the gradient descent is too slow for ill-conditioned
+					// expressions
+					IsNelderMeadOnly bool
+				}{
+					{"x 1 - 2 ^ y 2 + 2 ^ +", false, map[string]float64{"x": 1, "y": -1}, 1, 1e-6, false},
+					{"x 2 ^ y 0.5 - 2 ^ + 3 -", false, map[string]float64{"x": 0, "y": 0.5}, -3, 1e-6, false},
+					{"0 x 2 ^ - y 2 ^ - z +", true, map[string]float64{"x": 0, "y": 0}, 7, 1e-6, false},
+					{"x y +", true, map[string]float64{"x": 2, "y": 3}, 5, 1e-6, false},
+					// NaN for negative x
+					{"x 0.5 ^ 1 - 2 ^ y 2 ^ +", false, map[string]float64{"x": 1, "y": 0}, 0, 1e-6, false},
+					// Rosenbrock's function
+					{"a x - 2 ^ b y x 2 ^ - 2 ^ * +", false, map[string]float64{"x": 1, "y": 1}, 0, 1e-3, true},
+				} {
+					if testCase.IsNelderMeadOnly && method != optimizer.MethodNelderMead {
+						continue
+					}
+					require.NoError(t, opt.Set("z", 7))
+					require.NoError(t, opt.Set("x", 0.5))
+					require.NoError(t, opt.Set("y", -5))
+
+					expr, err := parse(testCase.Expression, opt)
+					require.NoError(t, err)
+					optimize := opt.Minimize
+					if testCase.IsMaximize {
+						optimize = opt.Maximize
+					}
+					result, err := optimize(expr, method, bounds)
+					require.NoError(t, err)
+					require.Equal(t, optimizer.StatusConverged, result.Status, testCase.Expression)
+					require.Len(t, result.Point, 2)
+					for symbol, expected := range testCase.Point {
+						require.InDelta(t, expected, result.Point[symbol], testCase.Delta, "%s: %s", testCase.Expression, symbol)
+					}
+					require.InDelta(t, testCase.Value, result.Value, testCase.Delta, testCase.Expression)
+					require.Equal(t, result.Value, expr.Eval(), "the variables should keep the found point")
+				}
+
+				expr, err := parse("x y +", opt)
+				require.NoError(t, err)
+				_, err = opt.Minimize(expr, method, map[string][2]float64{"a": {0, 1}})
+				require.Error(t, err, "not a variable")
+				_, err = opt.Minimize(expr, method, map[string][2]float64{"x": {1, 0}})
+				require.Error(t, err, "invalid bounds")
+				_, err = opt.Minimize(expr, method, nil)
+				require.Error(t, err, "no variables")
+
+				expr, err = parse("x y +", optimizer.New(nil, "x", "y"))
+				require.NoError(t, err)
+				_, err = optimizer.New(nil, "x", "y").Minimize(expr, method, bounds)
+				require.Error(t, err, "parsed with another resolver")
+			})
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package rpn
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	bigfloat "github.com/xaionaro-go/rpn/implementations/bigfloat"
+	callslice "github.com/xaionaro-go/rpn/implementations/callslice"
+	calltree "github.com/xaionaro-go/rpn/implementations/calltree"
+	decimal "github.com/xaionaro-go/rpn/implementations/decimal"
+	exprtree "github.com/xaionaro-go/rpn/implementations/exprtree"
+	regvm "github.com/xaionaro-go/rpn/implementations/regvm"
+	tokenslice "github.com/xaionaro-go/rpn/implementations/tokenslice"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+// FromIRFunc builds an Expr from a parsed expression (like FromIR of
+// the implementations).
+type FromIRFunc func(irExpr *ir.Expr) (Expr, error)
+
+// ParseCacheStats is the statistics of a ParseCache.
+type ParseCacheStats struct {
+	// Hits is the amount of Parse calls which found the expression
+	// in the cache.
+	Hits uint64
+
+	// Misses is the amount of Parse calls which had to parse the
+	// expression.
+	Misses uint64
+
+	// Evictions is the amount of expressions removed from the cache
+	// to not exceed the size.
+	Evictions uint64
+
+	// Len is the current amount of expressions in the cache.
+	Len int
+}
+
+// ParseCache is a concurrency-safe LRU cache of parsed expressions.
+//
+// The expressions are cached without the resolved symbols, so each Parse
+// returns a new Expr bound to the passed types.SymbolResolver (only
+// the parsing of the text is skipped).
+type ParseCache struct {
+	locker          sync.Mutex
+	size            int
+	entries         map[parseCacheKey]*list.Element
+	lru             *list.List
+	stats           ParseCacheStats
+	implementations map[string]FromIRFunc
+}
+
+type parseCacheKey struct {
+	Implementation string
+	Expression     string
+}
+
+type parseCacheEntry struct {
+	Key    parseCacheKey
+	IRExpr *ir.Expr
+}
+
+// NewParseCache returns a new ParseCache which contains up to `size`
+// expressions (a non-positive size disables caching).
+//
+// The supported implementations are: "default" (see FromIR), "bigfloat",
+// "callslice", "calltree", "decimal", "exprtree", "regvm" and
+// "tokenslice", and also "compile" on amd64 (the only architecture it
+// supports); others could be added by SetImplementation.
+func NewParseCache(size int) *ParseCache {
+	if size < 0 {
+		size = 0
+	}
+	cache := &ParseCache{
+		size:    size,
+		entries: map[parseCacheKey]*list.Element{},
+		lru:     list.New(),
+		implementations: map[string]FromIRFunc{
+			"default": FromIR,
+			"bigfloat": func(irExpr *ir.Expr) (Expr, error) {
+				return bigfloat.FromIR(irExpr)
+			},
+			"callslice": func(irExpr *ir.Expr) (Expr, error) {
+				return callslice.FromIR(irExpr)
+			},
+			"calltree": func(irExpr *ir.Expr) (Expr, error) {
+				return calltree.FromIR(irExpr)
+			},
+			"decimal": func(irExpr *ir.Expr) (Expr, error) {
+				return decimal.FromIR(irExpr)
+			},
+			"exprtree": func(irExpr *ir.Expr) (Expr, error) {
+				return exprtree.FromIR(irExpr)
+			},
+			"regvm": func(irExpr *ir.Expr) (Expr, error) {
+				return regvm.FromIR(irExpr)
+			},
+			"tokenslice": func(irExpr *ir.Expr) (Expr, error) {
+				return tokenslice.FromIR(irExpr)
+			},
+		},
+	}
+	addArchImplementations(cache.implementations)
+	return cache
+}
+
+// SetImplementation adds (or replaces) an implementation with name
+// `name`, which could be used in Parse.
+func (cache *ParseCache) SetImplementation(name string, fromIR FromIRFunc) {
+	cache.locker.Lock()
+	defer cache.locker.Unlock()
+	cache.implementations[name] = fromIR
+}
+
+// Parse returns an Expr of implementation `implementation` for
+// the expression (like Parse of the implementation does), but parses
+// the text only if it is not cached yet.
+func (cache *ParseCache) Parse(implementation string, expression string, symResolver types.SymbolResolver, opts ...ir.Option) (Expr, error) {
+	key := parseCacheKey{
+		Implementation: implementation,
+		Expression:     expression,
+	}
+
+	cache.locker.Lock()
+	fromIR := cache.implementations[implementation]
+	if fromIR == nil {
+		cache.locker.Unlock()
+		return nil, fmt.Errorf("unknown implementation '%s'", implementation)
+	}
+	irExpr := cache.get(key)
+	cache.locker.Unlock()
+
+	if irExpr == nil {
+		var err error
+		irExpr, err = ir.ParseUnbound(expression)
+		if err != nil {
+			return nil, err
+		}
+		cache.locker.Lock()
+		cache.add(key, irExpr)
+		cache.locker.Unlock()
+	}
+
+	boundExpr, err := irExpr.Bind(symResolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return fromIR(boundExpr)
+}
+
+func (cache *ParseCache) get(key parseCacheKey) *ir.Expr {
+	element, ok := cache.entries[key]
+	if !ok {
+		cache.stats.Misses++
+		return nil
+	}
+	cache.stats.Hits++
+	cache.lru.MoveToFront(element)
+	return element.Value.(*parseCacheEntry).IRExpr
+}
+
+func (cache *ParseCache) add(key parseCacheKey, irExpr *ir.Expr) {
+	if _, ok := cache.entries[key]; ok {
+		// was added concurrently
+		return
+	}
+	cache.entries[key] = cache.lru.PushFront(&parseCacheEntry{
+		Key:    key,
+		IRExpr: irExpr,
+	})
+	for cache.lru.Len() > cache.size {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*parseCacheEntry).Key)
+		cache.stats.Evictions++
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (cache *ParseCache) Stats() ParseCacheStats {
+	cache.locker.Lock()
+	defer cache.locker.Unlock()
+	stats := cache.stats
+	stats.Len = cache.lru.Len()
+	return stats
+}
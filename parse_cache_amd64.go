@@ -0,0 +1,14 @@
+package rpn
+
+import (
+	compile "github.com/xaionaro-go/rpn/implementations/compile"
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+// addArchImplementations adds the implementations which are supported
+// only on this architecture.
+func addArchImplementations(implementations map[string]FromIRFunc) {
+	implementations["compile"] = func(irExpr *ir.Expr) (Expr, error) {
+		return compile.FromIR(irExpr)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !amd64
+
+package rpn
+
+// addArchImplementations adds the implementations which are supported
+// only on this architecture (there are no such implementations).
+func addArchImplementations(implementations map[string]FromIRFunc) {}
@@ -0,0 +1,94 @@
+package rpn_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn"
+	"github.com/xaionaro-go/rpn/ir"
+)
+
+func TestParseCache(t *testing.T) {
+	cache := rpn.NewParseCache(2)
+
+	varsA, varsB := &variables{X: 1}, &variables{X: 2}
+	exprA, err := cache.Parse("calltree", "y x 2 * +", varsA)
+	require.NoError(t, err)
+	exprB, err := cache.Parse("calltree", "y x 2 * +", varsB)
+	require.NoError(t, err)
+	require.Equal(t, float64(12), exprA.Eval())
+	require.Equal(t, float64(14), exprB.Eval())
+	varsB.X = 3
+	require.Equal(t, float64(12), exprA.Eval())
+	require.Equal(t, float64(16), exprB.Eval())
+	require.Equal(t, rpn.ParseCacheStats{Hits: 1, Misses: 1, Len: 1}, cache.Stats())
+
+	// the implementation is a part of the key
+	exprC, err := cache.Parse("default", "y x 2 * +", varsA, ir.O1)
+	require.NoError(t, err)
+	require.Equal(t, float64(12), exprC.Eval())
+	require.Equal(t, rpn.ParseCacheStats{Hits: 1, Misses: 2, Len: 2}, cache.Stats())
+
+	// "calltree" is the least recently used
+	_, err = cache.Parse("default", "y x 2 * +", varsA)
+	require.NoError(t, err)
+	_, err = cache.Parse("default", "x", varsA)
+	require.NoError(t, err)
+	_, err = cache.Parse("calltree", "y x 2 * +", varsA)
+	require.NoError(t, err)
+	require.Equal(t, rpn.ParseCacheStats{Hits: 2, Misses: 4, Evictions: 2, Len: 2}, cache.Stats())
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := cache.Parse("unknown", "x", varsA)
+		require.Error(t, err)
+		_, err = cache.Parse("default", "x +", varsA)
+		require.Error(t, err)
+		_, err = cache.Parse("default", "x z +", varsA)
+		require.Error(t, err)
+		_, err = cache.Parse("default", "x z +", varsA)
+		require.Error(t, err)
+	})
+
+	t.Run("negative_size", func(t *testing.T) {
+		cache := rpn.NewParseCache(-1)
+		for i := 0; i < 2; i++ {
+			expr, err := cache.Parse("default", "x 1 +", varsA)
+			require.NoError(t, err)
+			require.Equal(t, float64(2), expr.Eval())
+		}
+		require.Equal(t, rpn.ParseCacheStats{Misses: 2, Evictions: 2}, cache.Stats())
+	})
+
+	t.Run("compile", func(t *testing.T) {
+		if runtime.GOARCH != "amd64" {
+			t.Skip("compile is supported only on amd64")
+		}
+		expr, err := rpn.NewParseCache(1).Parse("compile", "y x 2 * +", varsA)
+		require.NoError(t, err)
+		require.Equal(t, float64(12), expr.Eval())
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		cache := rpn.NewParseCache(10)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				vars := &variables{X: float64(i)}
+				for j := 0; j < 100; j++ {
+					expr, err := cache.Parse("callslice", fmt.Sprintf("x %d +", j%20), vars)
+					require.NoError(t, err)
+					require.Equal(t, float64(i+j%20), expr.Eval())
+				}
+			}(i)
+		}
+		wg.Wait()
+		stats := cache.Stats()
+		require.Equal(t, uint64(1000), stats.Hits+stats.Misses)
+		require.Equal(t, 10, stats.Len)
+	})
+}
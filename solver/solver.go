@@ -0,0 +1,199 @@
+// Package solver finds the values of symbols which make an expression
+// equal to a target value (like a break-even point or an internal rate
+// of return).
+package solver
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/xaionaro-go/rpn/internal/numeric"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+var (
+	_ types.SymbolResolver = &Solver{}
+)
+
+// Status is the result of a search of a root.
+type Status = numeric.Status
+
+const (
+	// StatusConverged means the root is found within the tolerance.
+	StatusConverged = numeric.StatusConverged
+
+	// StatusMaxIterations means the root is not found within
+	// MaxIterations iterations (Result.Root is the best approximation).
+	StatusMaxIterations = numeric.StatusMaxIterations
+)
+
+// Result is the result of Solve.
+type Result struct {
+	// Root is the found value of the symbol.
+	Root float64
+
+	// Value is the value of the expression at Root.
+	Value float64
+
+	// Iterations is the amount of iterations of the search.
+	Iterations int
+
+	// Status defines if the search converged.
+	Status Status
+}
+
+// Solver finds values of the unknowns of expressions parsed with it
+// (as the types.SymbolResolver), so it works with any implementation.
+//
+// Solver is not safe for concurrent use.
+type Solver struct {
+	// Tolerance is the relative tolerance of a root.
+	Tolerance float64
+
+	// MaxIterations is the maximal amount of iterations of Solve.
+	MaxIterations int
+
+	unknowns *numeric.Resolver
+}
+
+// New returns a new Solver for the unknown symbols `unknowns`. The other
+// symbols are resolved by `resolver` (which could be nil if there are no
+// other symbols).
+func New(resolver types.SymbolResolver, unknowns ...string) *Solver {
+	solver := &Solver{
+		Tolerance:     1e-12,
+		MaxIterations: 100,
+		unknowns:      numeric.NewResolver(resolver, "an unknown"),
+	}
+	for _, symbol := range unknowns {
+		solver.unknowns.Add(symbol, math.NaN())
+	}
+	return solver
+}
+
+// Resolve implements types.SymbolResolver
+func (solver *Solver) Resolve(symbol string) (types.ValueLoader, error) {
+	return solver.unknowns.Resolve(symbol)
+}
+
+// Set sets the value of an unknown (it is used by the expressions
+// when the unknown is not being solved).
+func (solver *Solver) Set(symbol string, value float64) error {
+	return solver.unknowns.Set(symbol, value)
+}
+
+// Solve finds a value of unknown `symbol` within `bracket` which makes
+// the expression equal to `target`. The expression minus the target
+// should have different signs at the ends of the bracket.
+//
+// It uses Newton's method (with derivatives by finite differences)
+// safeguarded by bisection: if a Newton step leaves the bracket or
+// does not shrink it fast enough, then the bracket is bisected. So
+// it converges for any continuous expression.
+//
+// After the search the unknown keeps the value of the found root.
+//
+// It returns an error if the unknown is not resolved by the Solver (the
+// expression is parsed with another resolver).
+func (solver *Solver) Solve(expr types.Expr, symbol string, target float64, bracket [2]float64) (Result, error) {
+	x, err := solver.unknowns.Value(symbol)
+	if err != nil {
+		return Result{}, err
+	}
+	if !solver.unknowns.IsResolved(symbol) {
+		return Result{}, fmt.Errorf("unknown '%s' is not resolved by the Solver: the expression should be parsed with the Solver as the symbol resolver", symbol)
+	}
+	defer numeric.DisableMemoization(expr)()
+
+	f := func(v float64) float64 {
+		*x = v
+		return expr.Eval() - target
+	}
+	result := func(root float64, iterations int, status Status) (Result, error) {
+		*x = root
+		return Result{
+			Root:       root,
+			Value:      expr.Eval(),
+			Iterations: iterations,
+			Status:     status,
+		}, nil
+	}
+
+	lo, hi := bracket[0], bracket[1]
+	fLo, fHi := f(lo), f(hi)
+	switch {
+	case math.IsNaN(fLo) || math.IsNaN(fHi):
+		return Result{}, fmt.Errorf("the expression is NaN at the bracket [%g, %g]", lo, hi)
+	case fLo == 0:
+		return result(lo, 0, StatusConverged)
+	case fHi == 0:
+		return result(hi, 0, StatusConverged)
+	case (fLo > 0) == (fHi > 0):
+		return Result{}, fmt.Errorf("the root is not bracketed: the expression minus the target is %g at %g and %g at %g", fLo, lo, fHi, hi)
+	}
+	if fLo > 0 {
+		// to keep f(lo) < 0 < f(hi)
+		lo, hi = hi, lo
+	}
+
+	updateBracket := func(x, fx float64) {
+		if fx < 0 {
+			lo = x
+		} else {
+			hi = x
+		}
+	}
+
+	root := lo + (hi-lo)/2
+	step, prevStep := math.Abs(hi-lo), math.Abs(hi-lo)
+	// the widths of the bracket at the two previous iterations
+	prevWidths := [2]float64{math.Inf(1), math.Inf(1)}
+	for iteration := 1; iteration <= solver.MaxIterations; iteration++ {
+		fRoot := f(root)
+		switch {
+		case math.IsNaN(fRoot):
+			return Result{}, fmt.Errorf("the expression is NaN at %g", root)
+		case fRoot == 0:
+			return result(root, iteration, StatusConverged)
+		}
+		updateBracket(root, fRoot)
+
+		tolerance := solver.Tolerance * math.Max(1, math.Abs(root))
+		if step <= tolerance {
+			// a small step does not mean the root is close if the
+			// derivative is inaccurate, so the root is verified by
+			// bracketing it within the tolerance
+			if math.Abs(hi-lo) <= 2*tolerance {
+				return result(root, iteration, StatusConverged)
+			}
+			if fNeighbour := f(root - tolerance); (fNeighbour < 0) != (fRoot < 0) {
+				updateBracket(root-tolerance, fNeighbour)
+				return result(root, iteration, StatusConverged)
+			}
+			if fNeighbour := f(root + tolerance); (fNeighbour < 0) != (fRoot < 0) {
+				updateBracket(root+tolerance, fNeighbour)
+				return result(root, iteration, StatusConverged)
+			}
+			// to bisect
+			step, prevStep = math.Abs(hi-lo), math.Abs(hi-lo)
+		}
+
+		width := math.Abs(hi - lo)
+		// Newton's method converges slowly for multiple roots, so the
+		// bracket should be halved at least each two iterations
+		isShrinking := width <= prevWidths[0]/2
+		prevWidths[0], prevWidths[1] = prevWidths[1], width
+
+		derivative := numeric.Derivative(f, root, width/4)
+		newtonRoot := root - fRoot/derivative
+		isNewtonInBracket := newtonRoot > math.Min(lo, hi) && newtonRoot < math.Max(lo, hi)
+		if isNewtonInBracket && isShrinking && math.Abs(2*fRoot) <= math.Abs(prevStep*derivative) {
+			prevStep, step = step, math.Abs(newtonRoot-root)
+			root = newtonRoot
+		} else {
+			prevStep, step = step, math.Abs(hi-lo)/2
+			root = lo + (hi-lo)/2
+		}
+	}
+	return result(root, solver.MaxIterations, StatusMaxIterations)
+}
@@ -0,0 +1,96 @@
+package solver_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/rpn"
+	exprtree "github.com/xaionaro-go/rpn/implementations/exprtree"
+	tokenslice "github.com/xaionaro-go/rpn/implementations/tokenslice"
+	"github.com/xaionaro-go/rpn/ir"
+	"github.com/xaionaro-go/rpn/solver"
+	"github.com/xaionaro-go/rpn/types"
+)
+
+type prices struct{}
+
+func (prices) Resolve(sym string) (types.ValueLoader, error) {
+	switch sym {
+	case "fixed":
+		return types.StaticValue(1000), nil
+	case "unit":
+		return types.FuncValue(func() float64 {
+			return 15
+		}), nil
+	}
+	return nil, fmt.Errorf("symbol '%s' not found", sym)
+}
+
+func TestSolver_Solve(t *testing.T) {
+	parsers := map[string]func(string, types.SymbolResolver) (types.Expr, error){
+		"default": func(s string, r types.SymbolResolver) (types.Expr, error) {
+			return rpn.Parse(s, r)
+		},
+		"exprtree": func(s string, r types.SymbolResolver) (types.Expr, error) {
+			return exprtree.Parse(s, r)
+		},
+		"tokenslice": func(s string, r types.SymbolResolver) (types.Expr, error) {
+			return tokenslice.Parse(s, r, ir.SnapshotSymbols)
+		},
+	}
+	for parserName, parse := range parsers {
+		t.Run(parserName, func(t *testing.T) {
+			s := solver.New(prices{}, "x", "r")
+			for _, testCase := range []struct {
+				Expression string
+				Symbol     string
+				Target     float64
+				Bracket    [2]float64
+				Root       float64
+			}{
+				{"x 2 *", "x", 6, [2]float64{0, 10}, 3},
+				{"x x *", "x", 2, [2]float64{0, 10}, math.Sqrt2},
+				{"x x *", "x", 2, [2]float64{-10, 0}, -math.Sqrt2},
+				// break-even: price*amount = fixed + unit*amount
+				{"x 25 * fixed - unit x * -", "x", 0, [2]float64{0, 1e6}, 100},
+				// IRR of -100, +60, +60
+				{"-100 60 1 r + / + 60 1 r + 2 ^ / +", "r", 0, [2]float64{0, 1}, 120/(-60+math.Sqrt(60*60+4*100*60)) - 1},
+				// not differentiable at the root
+				{"x x 1 - x 1 - if 2 * +", "x", 4, [2]float64{0, 3}, 2},
+				{"x 3 ^", "x", 8, [2]float64{-1, 5}, 2},
+				// a multiple root
+				{"x 1 - 3 ^", "x", 0, [2]float64{-1, 2}, 1},
+			} {
+				expr, err := parse(testCase.Expression, s)
+				require.NoError(t, err)
+				result, err := s.Solve(expr, testCase.Symbol, testCase.Target, testCase.Bracket)
+				require.NoError(t, err, testCase.Expression)
+				require.Equal(t, solver.StatusConverged, result.Status, testCase.Expression)
+				require.InDelta(t, testCase.Root, result.Root, 1e-9, testCase.Expression)
+				require.InDelta(t, testCase.Target, result.Value, 1e-9, testCase.Expression)
+				require.Equal(t, result.Value, expr.Eval(), "the unknown should keep the root")
+				require.Less(t, result.Iterations, s.MaxIterations, testCase.Expression)
+			}
+
+			expr, err := parse("x x *", s)
+			require.NoError(t, err)
+			_, err = s.Solve(expr, "x", -1, [2]float64{-10, 10})
+			require.Error(t, err, "not bracketed")
+			_, err = s.Solve(expr, "fixed", 1, [2]float64{-10, 10})
+			require.Error(t, err, "not an unknown")
+
+			otherExpr, err := parse("x x *", solver.New(nil, "x"))
+			require.NoError(t, err)
+			_, err = solver.New(nil, "x").Solve(otherExpr, "x", 2, [2]float64{0, 10})
+			require.Error(t, err, "parsed with another resolver")
+
+			s.MaxIterations = 2
+			result, err := s.Solve(expr, "x", 2, [2]float64{0, 1e6})
+			require.NoError(t, err)
+			require.Equal(t, solver.StatusMaxIterations, result.Status)
+			require.Equal(t, 2, result.Iterations)
+		})
+	}
+}
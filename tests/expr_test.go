@@ -3,6 +3,7 @@ package tests_test
 import (
 	"fmt"
 	"math"
+	"math/big"
 	"math/rand"
 	"strings"
 	"testing"
@@ -13,9 +14,12 @@ import (
 	calltree "github.com/xaionaro-go/rpn/implementations/calltree"
 	compile "github.com/xaionaro-go/rpn/implementations/compile"
 	exprtree "github.com/xaionaro-go/rpn/implementations/exprtree"
+	regvm "github.com/xaionaro-go/rpn/implementations/regvm"
 	tokenslice "github.com/xaionaro-go/rpn/implementations/tokenslice"
+	"github.com/xaionaro-go/rpn/ir"
 	"github.com/xaionaro-go/rpn/tests"
 	"github.com/xaionaro-go/rpn/types"
+	"golang.org/x/sys/cpu"
 )
 
 var implementations = map[string]func(string, types.SymbolResolver) (types.Expr, error){
@@ -34,11 +38,35 @@ var implementations = map[string]func(string, types.SymbolResolver) (types.Expr,
 	"tokenslice": func(s string, resolver types.SymbolResolver) (types.Expr, error) {
 		return tokenslice.Parse(s, resolver)
 	},
+	"regvm": func(s string, resolver types.SymbolResolver) (types.Expr, error) {
+		return regvm.Parse(s, resolver)
+	},
 	"default": func(s string, resolver types.SymbolResolver) (types.Expr, error) {
 		return rpn.Parse(s, resolver)
 	},
 }
 
+var irImplementations = map[string]func(*ir.Expr) (types.Expr, error){
+	"callslice": func(expr *ir.Expr) (types.Expr, error) {
+		return callslice.FromIR(expr)
+	},
+	"calltree": func(expr *ir.Expr) (types.Expr, error) {
+		return calltree.FromIR(expr)
+	},
+	"exprtree": func(expr *ir.Expr) (types.Expr, error) {
+		return exprtree.FromIR(expr)
+	},
+	"compile": func(expr *ir.Expr) (types.Expr, error) {
+		return compile.FromIR(expr)
+	},
+	"tokenslice": func(expr *ir.Expr) (types.Expr, error) {
+		return tokenslice.FromIR(expr)
+	},
+	"regvm": func(expr *ir.Expr) (types.Expr, error) {
+		return regvm.FromIR(expr)
+	},
+}
+
 func TestExpr(t *testing.T) {
 	for implName, impl := range implementations {
 		t.Run(implName, func(t *testing.T) {
@@ -52,24 +80,28 @@ func TestExpr(t *testing.T) {
 				require.NoError(t, err)
 				require.Equal(t, float64(20), expr.Eval(), fmt.Sprintf("%s: '%s'", implName, expr.String()))
 			})
-			if implName != "compile" {
-				t.Run("large_expression", func(t *testing.T) {
-					for _, sym := range []string{"1", "z"} {
-						var description string
-						if sym == "1" {
-							description = "const"
-						} else {
-							description = "variable"
-						}
-						t.Run(description, func(t *testing.T) {
-							rpn := strings.Repeat(sym+" ", 10000) + strings.Repeat("+ ", 9999)
-							expr, err := impl(rpn, tests.DummyResolver{T: t})
-							require.NoError(t, err)
-							require.Equal(t, float64(10000), expr.Eval(), implName)
-						})
+			t.Run("large_expression", func(t *testing.T) {
+				for _, sym := range []string{"1", "z"} {
+					var description string
+					if sym == "1" {
+						description = "const"
+					} else {
+						description = "variable"
 					}
-				})
-			}
+					t.Run(description, func(t *testing.T) {
+						rpn := strings.Repeat(sym+" ", 10000) + strings.Repeat("+ ", 9999)
+						expr, err := impl(rpn, tests.DummyResolver{T: t})
+						require.NoError(t, err)
+						require.Equal(t, float64(10000), expr.Eval(), implName)
+					})
+				}
+			})
+			t.Run("invalid_expressions", func(t *testing.T) {
+				for _, expression := range []string{"", " ", "1 +", "1 2", "x0 +", "1 2 + 3"} {
+					_, err := impl(expression, tests.DummyResolver{T: t})
+					require.Error(t, err, fmt.Sprintf("%s: '%s'", implName, expression))
+				}
+			})
 		})
 	}
 
@@ -147,6 +179,402 @@ func TestExpr(t *testing.T) {
 	})
 }
 
+// countingResolver resolves any symbol to its length and counts the loads.
+type countingResolver map[string]int
+
+func (r countingResolver) Resolve(sym string) (types.ValueLoader, error) {
+	return types.FuncValue(func() float64 {
+		r[sym]++
+		return float64(len(sym))
+	}), nil
+}
+
+func TestExpr_commonSubexpressions(t *testing.T) {
+	const exprString = "a b + ccc * a b + dddd * +"
+	for implName, fromIR := range irImplementations {
+		t.Run(implName, func(t *testing.T) {
+			loads := countingResolver{}
+			irExpr, err := ir.Parse(exprString, loads)
+			require.NoError(t, err)
+			expr, err := fromIR(irExpr.EliminateCommonSubexpressions())
+			require.NoError(t, err)
+			for i := 1; i <= 3; i++ {
+				require.Equal(t, float64(14), expr.Eval())
+			}
+
+			switch implName {
+			case "callslice", "calltree":
+				require.Equal(t, countingResolver{"a": 3, "b": 3, "ccc": 3, "dddd": 3}, loads)
+			}
+		})
+	}
+
+	t.Run("random_expressions", func(t *testing.T) {
+		randGen := rand.New(rand.NewSource(0))
+		for i := 0; i < 1000; i++ {
+			exprString := randExpression(randGen)
+			irExpr, err := ir.Parse(exprString, tests.DummyResolver{T: t})
+			if err != nil {
+				continue
+			}
+			expected, err := rpn.Parse(exprString, tests.DummyResolver{T: t})
+			require.NoError(t, err)
+			for implName, fromIR := range irImplementations {
+				if implName == "compile" {
+					continue
+				}
+				expr, err := fromIR(irExpr.Optimize(ir.O1))
+				require.NoError(t, err)
+				if math.IsNaN(expected.Eval()) && math.IsNaN(expr.Eval()) {
+					continue
+				}
+				require.Equal(t, expected.Eval(), expr.Eval(), fmt.Sprintf("%s: '%s'", implName, exprString))
+			}
+		}
+	})
+}
+
+// changingResolver resolves any symbol to a value which is changed
+// on each load (like a concurrently changed variable).
+type changingResolver map[string]int
+
+func (r changingResolver) Resolve(sym string) (types.ValueLoader, error) {
+	return types.FuncValue(func() float64 {
+		r[sym]++
+		return float64(r[sym])
+	}), nil
+}
+
+func TestExpr_snapshotSymbols(t *testing.T) {
+	const exprString = "x x - y * x x * +"
+	check := func(t *testing.T, loads changingResolver, expr types.Expr) {
+		for i := 1; i <= 3; i++ {
+			loads["x"] = i * 10
+			require.Equal(t, float64((i*10+1)*(i*10+1)), expr.Eval())
+		}
+		require.Equal(t, changingResolver{"x": 31, "y": 3}, loads)
+	}
+
+	for implName, fromIR := range irImplementations {
+		t.Run(implName, func(t *testing.T) {
+			loads := changingResolver{}
+			irExpr, err := ir.Parse(exprString, loads, ir.SnapshotSymbols)
+			require.NoError(t, err)
+			require.Equal(t, []string{"x", "y"}, irExpr.Snapshot.Names)
+			expr, err := fromIR(irExpr)
+			require.NoError(t, err)
+			check(t, loads, expr)
+		})
+	}
+	t.Run("default", func(t *testing.T) {
+		loads := changingResolver{}
+		expr, err := rpn.Parse(exprString, loads, ir.SnapshotSymbols)
+		require.NoError(t, err)
+		check(t, loads, expr)
+	})
+}
+
+// valuesResolver resolves symbols to the values of the map.
+type valuesResolver map[string]float64
+
+func (r valuesResolver) Resolve(sym string) (types.ValueLoader, error) {
+	if _, ok := r[sym]; !ok {
+		return nil, fmt.Errorf("unknown symbol '%s'", sym)
+	}
+	return types.FuncValue(func() float64 {
+		return r[sym]
+	}), nil
+}
+
+func TestExpr_compensatedSummation(t *testing.T) {
+	vars := valuesResolver{"x": 1, "y": 1e100, "z": 0.1}
+
+	// the exact sum of 10000 values of 0.1 (which is not exactly 1/10
+	// in binary), rounded to float64
+	ledgerExpected, _ := new(big.Float).SetPrec(1000).Mul(big.NewFloat(0.1).SetPrec(1000), big.NewFloat(10000)).Float64()
+	ledger := "z " + strings.Repeat("z + ", 9999)
+	ulp := math.Nextafter(ledgerExpected, math.Inf(1)) - ledgerExpected
+
+	for implName, fromIR := range irImplementations {
+		t.Run(implName, func(t *testing.T) {
+			parse := func(exprString string, opts ...ir.Option) types.Expr {
+				irExpr, err := ir.Parse(exprString, vars, opts...)
+				require.NoError(t, err)
+				expr, err := fromIR(irExpr)
+				require.NoError(t, err)
+				return expr
+			}
+
+			require.Equal(t, float64(0), parse("x y + x + y -").Eval())
+			require.Equal(t, float64(2), parse("x y + x + y -", ir.CompensatedSummation).Eval())
+			require.Equal(t, float64(2), parse("x y x - y - - 0 - x 2 * + x 2 * -", ir.CompensatedSummation).Eval())
+			require.Equal(t, float64(6), parse("x y + x + y - 3 *", ir.CompensatedSummation).Eval())
+			require.Equal(t, float64(0), parse("x y + x + y -", ir.CompensatedSummation, ir.StrictIEEE).Eval())
+			require.Equal(t, math.Inf(1), parse("x y + x + y - 1e308 + 1e308 +", ir.CompensatedSummation).Eval())
+
+			naive := parse(ledger).Eval()
+			compensated := parse(ledger, ir.CompensatedSummation).Eval()
+			require.Greater(t, math.Abs(naive-ledgerExpected), 100*ulp)
+			require.LessOrEqual(t, math.Abs(compensated-ledgerExpected), ulp)
+		})
+	}
+}
+
+func TestExpr_fma(t *testing.T) {
+	// a*b is 1-2^-104, which is rounded to 1 unless fused
+	vars := valuesResolver{"a": 1 + 0x1p-52, "b": 1 - 0x1p-52, "c": -1, "x": 0.5}
+	fused := math.FMA(vars["a"], vars["b"], vars["c"])
+	require.Equal(t, -0x1p-104, fused)
+
+	for implName, fromIR := range irImplementations {
+		t.Run(implName, func(t *testing.T) {
+			if implName == "compile" && !cpu.X86.HasFMA {
+				t.Skip("the CPU does not support FMA")
+			}
+			parse := func(exprString string, opts ...ir.Option) types.Expr {
+				irExpr, err := ir.Parse(exprString, vars, opts...)
+				require.NoError(t, err)
+				expr, err := fromIR(irExpr)
+				require.NoError(t, err)
+				return expr
+			}
+
+			require.Equal(t, fused, parse("a b c fma").Eval())
+			require.Equal(t, fused*2, parse("a b c fma 2 *").Eval())
+			require.Equal(t, float64(17), parse("3 4 5 fma").Eval())
+			require.Equal(t, float64(3.5), parse("x 3 2 fma").Eval())
+			require.Equal(t, float64(2), parse("x 1 x 2 x fma fma").Eval())
+
+			require.Equal(t, float64(0), parse("a b * c +").Eval())
+			require.Equal(t, fused, parse("a b * c +", ir.ContractFMA).Eval())
+			require.Equal(t, fused, parse("c a b * +", ir.ContractFMA).Eval())
+			require.Equal(t, float64(0), parse("a b * c +", ir.ContractFMA, ir.StrictIEEE).Eval())
+
+			// Horner's method: ((2x + 3)x + 4)x + 5
+			horner := parse("2 x * 3 + x * 4 + x * 5 +", ir.ContractFMA)
+			require.Equal(t, float64(8), horner.Eval())
+
+			_, err := ir.Parse("a b fma", vars)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestExpr_specialize(t *testing.T) {
+	for implName, impl := range implementations {
+		t.Run(implName, func(t *testing.T) {
+			vars := valuesResolver{"x": 2, "y": 3, "tariff": 0.25}
+			expr, err := impl("x tariff * y + 1 tariff - *", vars)
+			require.NoError(t, err)
+			require.Equal(t, float64(2.625), expr.Eval())
+
+			specialized, err := expr.(types.SpecializableExpr).Specialize(map[string]float64{"tariff": 0.5, "unused": 1})
+			require.NoError(t, err)
+			require.Equal(t, float64(2), specialized.Eval())
+			require.Equal(t, float64(2.625), expr.Eval(), "the original expression should not be changed")
+
+			vars["x"] = 4
+			require.Equal(t, float64(2.5), specialized.Eval())
+
+			specialized, err = specialized.(types.SpecializableExpr).Specialize(map[string]float64{"x": 0, "y": 1})
+			require.NoError(t, err)
+			require.Equal(t, float64(0.5), specialized.Eval())
+		})
+	}
+}
+
+// gradEvaluator is implemented by the implementations which support
+// automatic differentiation.
+type gradEvaluator interface {
+	EvalGrad() (float64, map[string]float64)
+}
+
+func TestExpr_EvalGrad(t *testing.T) {
+	vars := valuesResolver{"x": 3, "y": 2, "z": 0.5}
+	for _, implName := range []string{"calltree", "tokenslice"} {
+		fromIR := irImplementations[implName]
+		t.Run(implName, func(t *testing.T) {
+			evalGrad := func(exprString string, opts ...ir.Option) (float64, map[string]float64) {
+				irExpr, err := ir.Parse(exprString, vars, opts...)
+				require.NoError(t, err)
+				expr, err := fromIR(irExpr)
+				require.NoError(t, err)
+				value, grad := expr.(gradEvaluator).EvalGrad()
+				require.Equal(t, expr.Eval(), value, exprString)
+				return value, grad
+			}
+
+			for _, testCase := range []struct {
+				Expression string
+				Value      float64
+				Grad       map[string]float64
+			}{
+				{"2", 2, map[string]float64{}},
+				{"x", 3, map[string]float64{"x": 1}},
+				{"x y * x 2 ^ +", 15, map[string]float64{"x": 8, "y": 3}},
+				{"x y - x y + /", 0.2, map[string]float64{"x": 0.16, "y": -0.24}},
+				{"z y ^", 0.25, map[string]float64{"y": 0.25 * math.Log(0.5), "z": 1}},
+				{"y z - x y * if", 6, map[string]float64{"x": 2, "y": 3, "z": 0}},
+				{"z y - x y * if", 0, map[string]float64{"x": 0, "y": 0, "z": 0}},
+				{"x y z fma", 6.5, map[string]float64{"x": 2, "y": 3, "z": 1}},
+				{"x x * x *", 27, map[string]float64{"x": 27}},
+			} {
+				value, grad := evalGrad(testCase.Expression)
+				require.Equal(t, testCase.Value, value, testCase.Expression)
+				require.Len(t, grad, len(testCase.Grad), testCase.Expression)
+				for name, expected := range testCase.Grad {
+					require.InDelta(t, expected, grad[name], 1e-15, "%s: d/d%s", testCase.Expression, name)
+				}
+			}
+
+			for _, opts := range [][]ir.Option{{ir.O2}, {ir.SnapshotSymbols}, {ir.CompensatedSummation}, {ir.ContractFMA}} {
+				value, grad := evalGrad("x y * x + y - z +", opts...)
+				require.Equal(t, float64(7.5), value)
+				require.Equal(t, map[string]float64{"x": 3, "y": 2, "z": 1}, grad)
+			}
+		})
+	}
+}
+
+func TestExpr_EvalGrad_derive(t *testing.T) {
+	vars := valuesResolver{"x0": 2, "x1": 3, "y": 4, "z": 1}
+	randGen := rand.New(rand.NewSource(0))
+	checked, skipped := 0, 0
+	for i := 0; i < 10000; i++ {
+		exprString := randExpression(randGen)
+		irExpr, err := ir.Parse(exprString, vars)
+		if err != nil {
+			continue
+		}
+		tree, err := exprtree.FromIR(irExpr)
+		require.NoError(t, err)
+		if value := tree.Eval(); math.IsNaN(value) || math.IsInf(value, 0) {
+			// the derivatives are not defined, but Derive may simplify
+			// them to finite values
+			continue
+		}
+
+		for _, symbol := range irExpr.Symbols() {
+			derivativeString, err := exprtree.Derive(tree, symbol)
+			if err != nil {
+				continue
+			}
+			derivative, err := rpn.Parse(derivativeString, vars)
+			require.NoError(t, err)
+			expected := derivative.Eval()
+			if math.IsNaN(expected) || math.IsInf(expected, 0) {
+				continue
+			}
+
+			for _, implName := range []string{"calltree", "tokenslice"} {
+				expr, err := irImplementations[implName](irExpr)
+				require.NoError(t, err)
+				_, grad := expr.(gradEvaluator).EvalGrad()
+				if math.IsNaN(grad[symbol]) {
+					// an intermediate value is infinite (like in
+					// "1 1 y 0 * / /"), so the chain rule gives NaN, but
+					// Derive simplifies "0 y *" to 0
+					skipped++
+					continue
+				}
+				require.InDelta(t, expected, grad[symbol], 1e-9*math.Max(1, math.Abs(expected)), "%s: '%s' d/d%s = '%s'", implName, exprString, symbol, derivativeString)
+				checked++
+			}
+		}
+	}
+	require.Greater(t, checked, 100*skipped)
+}
+
+// uncertaintyEvaluator is implemented by the implementations which support
+// uncertainty propagation.
+type uncertaintyEvaluator interface {
+	EvalUncertainty(correlations map[[2]string]float64) (float64, float64)
+}
+
+// measurementsResolver resolves symbols to measured values.
+type measurementsResolver map[string]types.UncertainValue
+
+func (r measurementsResolver) Resolve(sym string) (types.ValueLoader, error) {
+	if v, ok := r[sym]; ok {
+		return v, nil
+	}
+	if sym == "k" {
+		return types.FuncValue(func() float64 {
+			return 10
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown symbol '%s'", sym)
+}
+
+func TestExpr_EvalUncertainty(t *testing.T) {
+	vars := measurementsResolver{
+		"x": {Value: 2, Sigma: 0.1},
+		"y": {Value: 3, Sigma: 0.2},
+		"z": {Value: 4, Sigma: 0},
+	}
+
+	for implName, impl := range implementations {
+		expr, err := impl("x y * k +", vars)
+		require.NoError(t, err, implName)
+		require.Equal(t, float64(16), expr.Eval(), implName)
+	}
+
+	for _, implName := range []string{"calltree", "tokenslice"} {
+		fromIR := irImplementations[implName]
+		t.Run(implName, func(t *testing.T) {
+			evalUncertainty := func(exprString string, correlations map[[2]string]float64, opts ...ir.Option) (float64, float64) {
+				irExpr, err := ir.Parse(exprString, vars, opts...)
+				require.NoError(t, err)
+				expr, err := fromIR(irExpr)
+				require.NoError(t, err)
+				return expr.(uncertaintyEvaluator).EvalUncertainty(correlations)
+			}
+
+			for _, testCase := range []struct {
+				Expression   string
+				Correlations map[[2]string]float64
+				Value        float64
+				StdDev       float64
+			}{
+				{"2", nil, 2, 0},
+				{"k", nil, 10, 0},
+				{"x", nil, 2, 0.1},
+				{"x k *", nil, 20, 1},
+				{"x y +", nil, 5, math.Sqrt(0.01 + 0.04)},
+				{"x y *", nil, 6, math.Sqrt(0.3*0.3 + 0.4*0.4)},
+				{"x z *", nil, 8, 0.4},
+				// the same symbol is fully correlated with itself
+				{"x x *", nil, 4, 0.4},
+				{"x x -", nil, 0, 0},
+				{"x y +", map[[2]string]float64{{"x", "y"}: 1}, 5, 0.3},
+				{"x y -", map[[2]string]float64{{"y", "x"}: 1}, -1, 0.1},
+				{"x y -", map[[2]string]float64{{"x", "y"}: 0.5}, -1, math.Sqrt(0.01 + 0.04 - 0.02)},
+				{"x 0.5 ^", nil, math.Sqrt(2), 0.1 * 0.5 / math.Sqrt(2)},
+				{"x y x fma", nil, 8, math.Sqrt(0.4*0.4 + 0.4*0.4)},
+			} {
+				value, stdDev := evalUncertainty(testCase.Expression, testCase.Correlations)
+				require.Equal(t, testCase.Value, value, testCase.Expression)
+				require.InDelta(t, testCase.StdDev, stdDev, 1e-15, testCase.Expression)
+			}
+
+			for _, opts := range [][]ir.Option{{ir.O2}, {ir.SnapshotSymbols}, {ir.CompensatedSummation}} {
+				value, stdDev := evalUncertainty("x y + x + y -", nil, opts...)
+				require.Equal(t, float64(4), value)
+				require.InDelta(t, 0.2, stdDev, 1e-15)
+			}
+
+			unbound, err := ir.ParseUnbound("x y *")
+			require.NoError(t, err)
+			bound, err := unbound.Bind(vars)
+			require.NoError(t, err)
+			expr, err := fromIR(bound)
+			require.NoError(t, err)
+			_, stdDev := expr.(uncertaintyEvaluator).EvalUncertainty(nil)
+			require.InDelta(t, 0.5, stdDev, 1e-15)
+		})
+	}
+}
+
 func randExpression(randGen *rand.Rand) string {
 	valDict := []string{
 		"x0", "x1", "y", "z",
@@ -16,3 +16,41 @@ type Expr interface {
 
 	fmt.Stringer
 }
+
+// SpecializableExpr is an Expr which could be specialized for known
+// values of some of its symbols.
+type SpecializableExpr interface {
+	Expr
+
+	// Specialize returns a new expression where the symbols with names
+	// from the map are replaced with the constant values (and the
+	// expression is simplified accordingly)
+	Specialize(map[string]float64) (Expr, error)
+}
+
+// GradExpr is an Expr which could also be evaluated with its partial
+// derivatives.
+type GradExpr interface {
+	Expr
+
+	// EvalGrad evaluates the expression and its partial derivatives with
+	// respect to all the symbols of the expression in one pass (using
+	// forward-mode automatic differentiation).
+	//
+	// Symbols folded by optimizations (like constant symbols with ir.O1)
+	// are not included into `grad`. Compensated sums (see
+	// ir.CompensatedSummation) are evaluated as ordinary sums. Memoization
+	// is not used.
+	EvalGrad() (value float64, grad map[string]float64)
+
+	// EvalUncertainty evaluates the expression and the first-order
+	// estimation of its standard deviation (uncertainty) from the standard
+	// deviations of the symbols resolved to UncertainValueLoader (the other
+	// symbols are considered exact).
+	//
+	// Each symbol is a single random variable, so repeated uses of the same
+	// symbol are fully correlated (for example, the uncertainty of "x x -"
+	// is zero). Different symbols are independent unless their correlation
+	// coefficient is set in `correlations` (by key {"x", "y"} or {"y", "x"}).
+	EvalUncertainty(correlations map[[2]string]float64) (value, stdDev float64)
+}
@@ -45,6 +45,12 @@ const (
 	// zero to the stack.
 	OpIf
 
+	// OpFMA means to multiply the value before the before-last one by the
+	// before-last value and to add the last value from the stack, rounding
+	// the result only once (see math.FMA), and put the result back to the
+	// stack. Thus "a b c fma" is "a b * c +" but more precise.
+	OpFMA
+
 	// BoundaryOp could be used for iteration through all Op-s (to detect
 	// the end of the iteration process).
 	BoundaryOp
@@ -67,11 +73,36 @@ func (op Op) String() string {
 		return "^"
 	case OpIf:
 		return "if"
+	case OpFMA:
+		return "fma"
 	default:
 		return fmt.Sprintf("unknown_op_%d", op)
 	}
 }
 
+// Arity returns the amount of values the operation takes from the stack.
+func (op Op) Arity() int {
+	switch op {
+	case OpFetch:
+		return 0
+	case OpFMA:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// Eval3 just executes a three-operand operation and returns the result.
+//go:nosplit
+func (op Op) Eval3(a, b, c float64) float64 {
+	switch op {
+	case OpFMA:
+		return math.FMA(a, b, c)
+	default:
+		panic("do not know how to evaluate op: " + op.String())
+	}
+}
+
 // Eval just executes the operation and returns the result.
 //go:nosplit
 func (op Op) Eval(lhs, rhs float64) float64 {
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"math"
+	"math/big"
+)
+
 // StaticValue is an implementation of ValueLoader which is just
 // a static float64 value. Using of this type allows to avoid extra
 // function calls, sometimes.
@@ -25,3 +30,56 @@ type ValueLoader interface {
 	// Load returns the value of the variable.
 	Load() float64
 }
+
+// UncertainValueLoader is a ValueLoader of a measured value, which
+// also has an uncertainty (the standard deviation of the measurement).
+type UncertainValueLoader interface {
+	ValueLoader
+
+	// StdDev returns the standard deviation of the value.
+	StdDev() float64
+}
+
+// UncertainValue is an implementation of UncertainValueLoader which is
+// just a static measured value "Value ± Sigma".
+type UncertainValue struct {
+	Value float64
+	Sigma float64
+}
+
+// Load implements ValueLoader.
+func (r UncertainValue) Load() float64 {
+	return r.Value
+}
+
+// StdDev implements UncertainValueLoader.
+func (r UncertainValue) StdDev() float64 {
+	return r.Sigma
+}
+
+// BigValueLoader is a ValueLoader which also could return the value
+// with arbitrary precision (used by implementation "bigfloat").
+type BigValueLoader interface {
+	ValueLoader
+
+	// LoadBig returns the value of the variable. It should not be nil.
+	LoadBig() *big.Float
+}
+
+// BigFuncValue is a function wrapper which implements BigValueLoader.
+type BigFuncValue func() *big.Float
+
+// Load implements ValueLoader.
+func (r BigFuncValue) Load() float64 {
+	v := r()
+	if v == nil {
+		return math.NaN()
+	}
+	f, _ := v.Float64()
+	return f
+}
+
+// LoadBig implements BigValueLoader.
+func (r BigFuncValue) LoadBig() *big.Float {
+	return r()
+}